@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// AdminSessionView is everything the server knows about a session,
+// including the cards hidden from the players.
+type AdminSessionView struct {
+	ID               string      `json:"id"`
+	RoomCode         string      `json:"roomCode"`
+	State            string      `json:"state"`
+	GameState        GameState   `json:"gameState"`
+	ShipDeck         []ShipCard  `json:"shipDeck"`
+	PlayDeck         []SalvoCard `json:"playDeck"`
+	DiscardPile      []SalvoCard `json:"discardPile"`
+	ConnectedPlayers []string    `json:"connectedPlayers"`
+	Spectators       []string    `json:"spectators"`
+	TournamentID     string      `json:"tournamentId,omitempty"`
+	MatchID          string      `json:"matchId,omitempty"`
+	LastActivity     time.Time   `json:"lastActivity"`
+	Ended            bool        `json:"ended"`
+}
+
+type EndSessionRequest struct {
+	Reason string `json:"reason"`
+}
+
+type NoticeRequest struct {
+	Message string `json:"message"`
+}
+
+// requireAdmin allows requests bearing the configured admin token. The
+// admin API does not exist when no token is configured.
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if config.AdminToken == "" {
+			http.NotFound(w, r)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(config.AdminToken)) != 1 {
+			slog.Warn("Rejected admin request", "method", r.Method, "path", r.URL.Path, "remote", remoteIP(r))
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		slog.Info("Admin request", "method", r.Method, "path", r.URL.Path, "remote", remoteIP(r))
+		next(w, r)
+	}
+}
+
+func handleAdminGetSession(w http.ResponseWriter, r *http.Request) {
+	session, ok := findAdminSession(w, r)
+	if !ok {
+		return
+	}
+	var view AdminSessionView
+	err := session.call(func() error {
+		state := session.GameState.Clone()
+		view = AdminSessionView{
+			ID:               session.ID,
+			RoomCode:         session.RoomCode,
+			State:            session.lifecycleState(),
+			GameState:        state,
+			ShipDeck:         state.ShipDeck,
+			PlayDeck:         state.PlayDeck,
+			DiscardPile:      state.DiscardPile,
+			ConnectedPlayers: slices.Sorted(maps.Keys(session.Clients)),
+			Spectators:       slices.Sorted(maps.Keys(session.Spectators)),
+			TournamentID:     session.tournamentID,
+			MatchID:          session.matchID,
+			LastActivity:     session.lastActivity,
+			Ended:            session.ended,
+		}
+		return nil
+	})
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, view)
+}
+
+// handleAdminEndSession ends the game but keeps the session, so its players
+// can see the final state until it is cleaned up.
+func handleAdminEndSession(w http.ResponseWriter, r *http.Request) {
+	session, ok := findAdminSession(w, r)
+	if !ok {
+		return
+	}
+	var req EndSessionRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid end session request", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Reason == "" {
+		req.Reason = "ended by an administrator"
+	}
+	err := session.call(func() error {
+		session.end(req.Reason)
+		return nil
+	})
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAdminDeleteSession disconnects everyone in a session and removes it
+func handleAdminDeleteSession(w http.ResponseWriter, r *http.Request) {
+	session, ok := findAdminSession(w, r)
+	if !ok {
+		return
+	}
+	err := session.call(func() error {
+		session.end("deleted by an administrator")
+		reportForfeit(session)
+		session.closeClients()
+		return nil
+	})
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	manager.remove(session)
+	session.log.Info("Session deleted by an administrator")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleAdminKickPlayer(w http.ResponseWriter, r *http.Request) {
+	session, ok := findAdminSession(w, r)
+	if !ok {
+		return
+	}
+	playerID := r.PathValue("playerId")
+	if err := session.call(func() error { return session.kick(playerID) }); err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAdminNotice sends a serverNotice to every connection
+func handleAdminNotice(w http.ResponseWriter, r *http.Request) {
+	var req NoticeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Message) == "" {
+		http.Error(w, "invalid notice request", http.StatusBadRequest)
+		return
+	}
+	sent := allClients.broadcast(newServerMessage("serverNotice", ServerNoticePayload{Message: req.Message}))
+	slog.Info("Broadcast server notice", "recipients", sent)
+	writeJSON(w, http.StatusOK, map[string]int{"recipients": sent})
+}
+
+func findAdminSession(w http.ResponseWriter, r *http.Request) (*GameSession, bool) {
+	session, exists := manager.find(r.PathValue("id"))
+	if !exists {
+		http.Error(w, "game session not found", http.StatusNotFound)
+		return nil, false
+	}
+	return session, true
+}
+
+func writeAdminError(w http.ResponseWriter, err error) {
+	var protocolErr *ProtocolError
+	if errors.As(err, &protocolErr) && protocolErr.Code == CodeNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusConflict)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"game-server/internal/harness"
+)
+
+const testAdminToken = "admin-0123456789"
+
+// adminRequest sends an admin API request to srv with token as its bearer
+// token and returns the status and body.
+func adminRequest(t *testing.T, srv *harness.Server, method, path, token, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(data)
+}
+
+func TestAdminAPIRequiresToken(t *testing.T) {
+	srv := startServer(t)
+
+	// Without a configured token the admin API does not exist
+	if status, _ := adminRequest(t, srv, "POST", "/admin/notice", "", `{"message": "hi"}`); status != http.StatusNotFound {
+		t.Errorf("without an admin token configured answered %d, want 404", status)
+	}
+
+	config.AdminToken = testAdminToken
+	for _, token := range []string{"", "wrong-token-0123456", testAdminToken + "x"} {
+		if status, _ := adminRequest(t, srv, "POST", "/admin/notice", token, `{"message": "hi"}`); status != http.StatusUnauthorized {
+			t.Errorf("token %q answered %d, want 401", token, status)
+		}
+	}
+	req, _ := http.NewRequest("POST", srv.URL+"/admin/notice", strings.NewReader(`{"message": "hi"}`))
+	req.Header.Set("Authorization", "Basic "+testAdminToken)
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("a basic credential answered %d, want 401", resp.StatusCode)
+	}
+	if status, _ := adminRequest(t, srv, "GET", "/admin/sessions/NO-SUCH-GAME", testAdminToken, ""); status != http.StatusNotFound {
+		t.Errorf("an unknown session answered %d, want 404", status)
+	}
+}
+
+func TestAdminSessionControls(t *testing.T) {
+	srv := startServer(t)
+	config.AdminToken = testAdminToken
+	alice, bob := seatTwoPlayers(t, srv)
+	path := "/admin/sessions/" + alice.RoomCode
+
+	status, body := adminRequest(t, srv, "GET", path, testAdminToken, "")
+	var view AdminSessionView
+	if status != http.StatusOK || json.Unmarshal([]byte(body), &view) != nil {
+		t.Fatalf("viewing the session answered %d %s", status, body)
+	}
+	if view.ID != alice.SessionID || view.State != SessionLobby || len(view.ConnectedPlayers) != 2 {
+		t.Errorf("admin view %+v, want Alice's game in the lobby with two players connected", view)
+	}
+
+	if status, body := adminRequest(t, srv, "POST", "/admin/notice", testAdminToken, `{"message": "restarting soon"}`); status != http.StatusOK || !strings.Contains(body, `"recipients"`) {
+		t.Errorf("sending a notice answered %d %s", status, body)
+	}
+	var notice ServerNoticePayload
+	alice.Decode(alice.Expect("serverNotice"), &notice)
+	if notice.Message != "restarting soon" {
+		t.Errorf("notice %q, want the administrator's message", notice.Message)
+	}
+	if status, _ := adminRequest(t, srv, "POST", "/admin/notice", testAdminToken, `{"message": " "}`); status != http.StatusBadRequest {
+		t.Errorf("an empty notice answered %d, want 400", status)
+	}
+
+	// Kicking Bob closes Bob's connection and revokes the seat's token
+	if status, _ := adminRequest(t, srv, "POST", path+"/players/"+bob.PlayerID+"/kick", testAdminToken, ""); status != http.StatusNoContent {
+		t.Fatalf("kicking Bob answered %d", status)
+	}
+	session, _ := manager.find(alice.SessionID)
+	waitUntil(time.Now().Add(2*time.Second), func() bool {
+		connected := true
+		session.call(func() error { _, connected = session.Clients[bob.PlayerID]; return nil })
+		return !connected
+	})
+	session.call(func() error {
+		if _, connected := session.Clients[bob.PlayerID]; connected {
+			t.Error("Bob is still connected after being kicked")
+		}
+		return nil
+	})
+	srv.Connect("Bob").Reject("rejoinGame", map[string]any{"sessionId": bob.SessionID, "playerId": bob.PlayerID, "token": bob.Token}, CodeForbidden)
+	if status, _ := adminRequest(t, srv, "POST", path+"/players/9/kick", testAdminToken, ""); status != http.StatusNotFound {
+		t.Errorf("kicking an unknown player answered %d, want 404", status)
+	}
+
+	if status, _ := adminRequest(t, srv, "POST", path+"/end", testAdminToken, `{"reason": "cheating"}`); status != http.StatusNoContent {
+		t.Fatalf("ending the session answered %d", status)
+	}
+	var ended SessionEndedPayload
+	alice.Decode(alice.Expect("sessionEnded"), &ended)
+	if ended.Reason != "cheating" {
+		t.Errorf("session ended for %q, want the administrator's reason", ended.Reason)
+	}
+	alice.Reject("startGame", nil, CodeForbidden)
+	if _, ok := manager.find(alice.SessionID); !ok {
+		t.Fatal("ending the session removed it")
+	}
+
+	if status, _ := adminRequest(t, srv, "DELETE", path, testAdminToken, ""); status != http.StatusNoContent {
+		t.Fatalf("deleting the session answered %d", status)
+	}
+	if _, ok := manager.find(alice.SessionID); ok {
+		t.Error("the session is still hosted after being deleted")
+	}
+	if status, _ := adminRequest(t, srv, "GET", path, testAdminToken, ""); status != http.StatusNotFound {
+		t.Errorf("viewing the deleted session answered %d, want 404", status)
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The HTTP API lets scripts and tests play without a websocket. Requests are
+// turned into the same client messages the websocket carries and handled by
+// the session goroutine, so both kinds of player share one game.
+
+const (
+	defaultPollWait = 30 * time.Second
+	maxPollWait     = 60 * time.Second
+)
+
+// GameView is a player's view of a game. Version increases every time the
+// game changes.
+type GameView struct {
+	Version int `json:"version"`
+	StatePayload
+}
+
+// ActionRequest is a game action sent over HTTP, such as
+// {"type": "fireSalvo", "payload": {...}}.
+type ActionRequest struct {
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// errorStatuses maps protocol error codes to HTTP statuses. Any other code
+// is a move the rules do not allow right now and maps to 409 Conflict.
+var errorStatuses = map[string]int{
+	CodeBadRequest:   http.StatusBadRequest,
+	CodeUnknownType:  http.StatusBadRequest,
+	CodeNotFound:     http.StatusNotFound,
+	CodeForbidden:    http.StatusForbidden,
+	CodeRateLimited:  http.StatusTooManyRequests,
+	CodeShuttingDown: http.StatusServiceUnavailable,
+}
+
+// handleAPICreateGame creates a game and seats the caller as its first
+// player. The body is a createGame payload.
+func handleAPICreateGame(w http.ResponseWriter, r *http.Request) {
+	msg, err := readClientMessage(r, "createGame")
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	var createMsg CreateGamePayload
+	if err := decodePayload(msg, &createMsg); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	session, err := findOrCreateSession(msg)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	seatAPIPlayer(w, session, JoinGamePayload{PlayerName: createMsg.PlayerName, PlayerKey: createMsg.PlayerKey}, http.StatusCreated)
+}
+
+// handleAPIJoinGame seats the caller in an existing game. The body is
+// {"playerName": "..."}, with the entrantToken for a tournament match.
+func handleAPIJoinGame(w http.ResponseWriter, r *http.Request) {
+	var joinMsg JoinGamePayload
+	if err := json.NewDecoder(r.Body).Decode(&joinMsg); err != nil {
+		writeAPIError(w, newProtocolError(CodeBadRequest, "invalid join request"))
+		return
+	}
+	if draining.Load() {
+		writeAPIError(w, errShuttingDown)
+		return
+	}
+	session, exists := manager.find(r.PathValue("id"))
+	if !exists {
+		writeAPIError(w, newProtocolError(CodeNotFound, "game session not found"))
+		return
+	}
+	seatAPIPlayer(w, session, joinMsg, http.StatusOK)
+}
+
+// seatAPIPlayer seats the player in session and answers with the player's ID
+// and token. The token authenticates the player's later requests, and can
+// also be used to take the seat over a websocket with rejoinGame.
+func seatAPIPlayer(w http.ResponseWriter, session *GameSession, joinMsg JoinGamePayload, status int) {
+	var joined JoinedPayload
+	err := session.call(func() error {
+		if session.ended {
+			return errSessionEnded
+		}
+		player, err := session.seat(joinMsg.PlayerName, joinMsg.EntrantToken)
+		if err != nil {
+			return err
+		}
+		session.issuePlayerKey(player, joinMsg.PlayerKey)
+		joined = JoinedPayload{SessionID: session.ID, RoomCode: session.RoomCode, PlayerID: player.ID, Token: session.tokens[player.ID], PlayerKey: session.playerKeys[player.ID]}
+		session.lastActivity = time.Now()
+		session.broadcastGameState()
+		return nil
+	})
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, status, joined)
+}
+
+// handleAPIGetGame returns the caller's view of a game: the player's own
+// view with their token, or a spectator's without one. With ?after=<version>
+// the request waits until the game is newer than that version, for up to
+// ?wait=<duration> (30s by default, at most 60s), and then returns the
+// current view either way.
+func handleAPIGetGame(w http.ResponseWriter, r *http.Request) {
+	session, exists := manager.find(r.PathValue("id"))
+	if !exists {
+		writeAPIError(w, newProtocolError(CodeNotFound, "game session not found"))
+		return
+	}
+	after := -1
+	if value := r.URL.Query().Get("after"); value != "" {
+		var err error
+		if after, err = strconv.Atoi(value); err != nil {
+			writeAPIError(w, newProtocolError(CodeBadRequest, "after must be a version number"))
+			return
+		}
+	}
+	wait := defaultPollWait
+	if value := r.URL.Query().Get("wait"); value != "" {
+		var err error
+		if wait, err = time.ParseDuration(value); err != nil || wait < 0 {
+			writeAPIError(w, newProtocolError(CodeBadRequest, "wait must be a duration such as 30s"))
+			return
+		}
+		wait = min(wait, maxPollWait)
+	}
+	timeout := time.NewTimer(wait)
+	defer timeout.Stop()
+
+	for {
+		var view GameView
+		var updated <-chan struct{}
+		err := session.call(func() error {
+			playerID, err := apiPlayer(session, r)
+			if err != nil {
+				return err
+			}
+			if session.version <= after {
+				updated = session.updated
+				return nil
+			}
+			view = GameView{Version: session.version, StatePayload: createStatePayload(session, playerID)}
+			return nil
+		})
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		if updated == nil {
+			writeJSON(w, http.StatusOK, view)
+			return
+		}
+
+		select {
+		case <-updated:
+		case <-timeout.C:
+			after = -1
+		case <-session.done:
+			writeAPIError(w, errSessionClosed)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// handleAPIAction handles a client message for the player holding the token
+// and returns their view of the result. The message goes through the same
+// path as a websocket message, so chat works too; seating messages have
+// their own endpoints.
+func handleAPIAction(w http.ResponseWriter, r *http.Request) {
+	session, exists := manager.find(r.PathValue("id"))
+	if !exists {
+		writeAPIError(w, newProtocolError(CodeNotFound, "game session not found"))
+		return
+	}
+	var req ActionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIError(w, newProtocolError(CodeBadRequest, "invalid action request"))
+		return
+	}
+	msg := ClientMessage{Version: ProtocolVersion, Type: req.Type, Payload: req.Payload}
+	switch msg.Type {
+	case "createGame", "joinGame", "rejoinGame", "spectateGame":
+		writeAPIError(w, newProtocolError(CodeBadRequest, "%s is not an action; use the games endpoints", msg.Type))
+		return
+	}
+
+	var view GameView
+	err := session.call(func() error {
+		playerID, err := apiPlayer(session, r)
+		if err != nil {
+			return err
+		}
+		if playerID == "" {
+			return newProtocolError(CodeForbidden, "a player token is required")
+		}
+		if err := session.applyClientMessage(apiClient(session, playerID), msg); err != nil {
+			return err
+		}
+		view = GameView{Version: session.version, StatePayload: createStatePayload(session, playerID)}
+		return nil
+	})
+	if err != nil {
+		actionsRejected.inc(asProtocolError(err).Code)
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, view)
+}
+
+// apiClient returns a stand-in client for an HTTP request by playerID. It is
+// not added to the session, and the replies queued for it are dropped: the
+// response carries the player's view instead. It runs on the session
+// goroutine.
+func apiClient(session *GameSession, playerID string) *Client {
+	client := newClient()
+	client.playerID = playerID
+	if player := session.GameState.Player(playerID); player != nil {
+		client.playerName = player.Name
+	}
+	return client
+}
+
+// apiPlayer returns the player whose token the request carries as a bearer
+// token, or "" for a request without one. It runs on the session goroutine.
+func apiPlayer(session *GameSession, r *http.Request) (string, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return "", nil
+	}
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok {
+		return "", newProtocolError(CodeForbidden, "the Authorization header must be a bearer token")
+	}
+	playerID, ok := session.playerForToken(token)
+	if !ok {
+		return "", newProtocolError(CodeForbidden, "invalid player token")
+	}
+	return playerID, nil
+}
+
+// readClientMessage wraps the JSON request body as the payload of a client
+// message of type msgType.
+func readClientMessage(r *http.Request, msgType string) (ClientMessage, error) {
+	var payload json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		return ClientMessage{}, newProtocolError(CodeBadRequest, "invalid %s request", msgType)
+	}
+	return ClientMessage{Version: ProtocolVersion, Type: msgType, Payload: payload}, nil
+}
+
+// writeAPIError answers with the error payload the websocket would carry
+func writeAPIError(w http.ResponseWriter, err error) {
+	protocolErr := asProtocolError(err)
+	status, ok := errorStatuses[protocolErr.Code]
+	if !ok {
+		status = http.StatusConflict
+	}
+	writeJSON(w, status, ErrorPayload{Code: protocolErr.Code, Message: protocolErr.Message})
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// useAPI returns the server's routes with a message rate that no test
+// reaches and a fresh request limiter.
+func useAPI(t *testing.T) http.Handler {
+	t.Helper()
+	oldConfig, oldRequests := config, requests
+	cfg := *config
+	cfg.MessageRate, cfg.MessageBurst = 10000, 10000
+	config, requests = &cfg, &requestLimiter{byIP: make(map[string]*tokenBucket)}
+	t.Cleanup(func() { config, requests = oldConfig, oldRequests })
+
+	mux, err := newServeMux()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mux
+}
+
+// apiCall sends a request with body as JSON and the token as a bearer token,
+// decodes the response into out unless it is nil, and returns the status.
+func apiCall(t *testing.T, handler http.Handler, method, path, token string, body, out any) int {
+	t.Helper()
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if out != nil {
+		if err := json.NewDecoder(rec.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s answered %d with %q: %v", method, path, rec.Code, rec.Body.String(), err)
+		}
+	}
+	return rec.Code
+}
+
+// createAPIGame creates a two player game for Alice over the API and seats
+// Bob. The session is removed when the test ends.
+func createAPIGame(t *testing.T, handler http.Handler) (alice, bob JoinedPayload) {
+	t.Helper()
+	if status := apiCall(t, handler, "POST", "/games", "", map[string]any{"numberOfPlayers": 2, "playerName": "Alice"}, &alice); status != http.StatusCreated {
+		t.Fatalf("creating a game answered %d", status)
+	}
+	t.Cleanup(func() {
+		if session, ok := manager.find(alice.SessionID); ok {
+			manager.remove(session)
+		}
+	})
+	if status := apiCall(t, handler, "POST", "/games/"+alice.RoomCode+"/players", "", map[string]any{"playerName": "Bob"}, &bob); status != http.StatusOK {
+		t.Fatalf("joining the game answered %d", status)
+	}
+	if bob.SessionID != alice.SessionID || bob.PlayerID == alice.PlayerID || bob.Token == "" {
+		t.Fatalf("Bob joined as %+v, want a seat in Alice's game %s", bob, alice.SessionID)
+	}
+	return alice, bob
+}
+
+func TestAPICreateJoinAndAct(t *testing.T) {
+	handler := useAPI(t)
+	alice, bob := createAPIGame(t, handler)
+	path := "/games/" + alice.SessionID
+
+	var view GameView
+	if status := apiCall(t, handler, "POST", path+"/actions", alice.Token, ActionRequest{Type: "startGame"}, &view); status != http.StatusOK {
+		t.Fatalf("starting the game answered %d", status)
+	}
+	if !view.GameState.GameStarted || len(view.GameState.Players) != 2 {
+		t.Fatalf("Alice sees %+v after starting the game", view.GameState)
+	}
+	for _, player := range view.GameState.Players {
+		if (player.ID == alice.PlayerID) != (len(player.Hand) > 0) {
+			t.Errorf("Alice sees %d salvos in %s's hand", len(player.Hand), player.Name)
+		}
+	}
+
+	waiting := bob
+	if view.GameState.CurrentPlayerId == bob.PlayerID {
+		waiting = alice
+	}
+	for _, test := range []struct {
+		name, token string
+		action      ActionRequest
+		status      int
+		code        string
+	}{
+		{"no token", "", ActionRequest{Type: "drawSalvo"}, http.StatusForbidden, CodeForbidden},
+		{"wrong token", "wrong", ActionRequest{Type: "drawSalvo"}, http.StatusForbidden, CodeForbidden},
+		{"out of turn", waiting.Token, ActionRequest{Type: "drawSalvo"}, http.StatusConflict, CodeNotYourTurn},
+		{"unknown action", waiting.Token, ActionRequest{Type: "teleport"}, http.StatusBadRequest, CodeUnknownType},
+		{"seating message", waiting.Token, ActionRequest{Type: "joinGame"}, http.StatusBadRequest, CodeBadRequest},
+		{"resync without deltas", waiting.Token, ActionRequest{Type: "resync"}, http.StatusBadRequest, CodeBadRequest},
+	} {
+		var errPayload ErrorPayload
+		status := apiCall(t, handler, "POST", path+"/actions", test.token, test.action, &errPayload)
+		if status != test.status || errPayload.Code != test.code {
+			t.Errorf("%s: answered %d %q, want %d %q", test.name, status, errPayload.Code, test.status, test.code)
+		}
+	}
+
+	chat := ActionRequest{Type: "chat", Payload: json.RawMessage(`{"text": "good luck"}`)}
+	if status := apiCall(t, handler, "POST", path+"/actions", waiting.Token, chat, nil); status != http.StatusOK {
+		t.Fatalf("chatting answered %d", status)
+	}
+	session, _ := manager.find(alice.SessionID)
+	session.call(func() error {
+		if n := len(session.chat.history); n != 1 || session.chat.history[0].From != waiting.PlayerID || session.chat.history[0].Name == "" {
+			t.Errorf("chat history is %+v, want the message from %s", session.chat.history, waiting.PlayerID)
+		}
+		if len(session.Clients) != 0 {
+			t.Errorf("the session has %d clients, want the API players to have none", len(session.Clients))
+		}
+		return nil
+	})
+
+	var spectated GameView
+	if status := apiCall(t, handler, "GET", path, "", nil, &spectated); status != http.StatusOK {
+		t.Fatalf("spectating answered %d", status)
+	}
+	for _, player := range spectated.GameState.Players {
+		if len(player.Hand) != 0 {
+			t.Errorf("a spectator sees %s's hand", player.Name)
+		}
+	}
+	if status := apiCall(t, handler, "GET", "/games/NO-SUCH-GAME", "", nil, nil); status != http.StatusNotFound {
+		t.Errorf("an unknown game answered %d", status)
+	}
+}
+
+func TestAPILongPoll(t *testing.T) {
+	handler := useAPI(t)
+	alice, _ := createAPIGame(t, handler)
+	path := "/games/" + alice.SessionID
+
+	var current GameView
+	apiCall(t, handler, "GET", path, alice.Token, nil, &current)
+
+	// Nothing changes, so the poll returns the current view when it times
+	// out
+	start := time.Now()
+	var view GameView
+	if status := apiCall(t, handler, "GET", path+"?after="+strconv.Itoa(current.Version)+"&wait=50ms", alice.Token, nil, &view); status != http.StatusOK {
+		t.Fatalf("polling answered %d", status)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("the poll returned after %v, before its wait", elapsed)
+	}
+	if view.Version != current.Version {
+		t.Errorf("the timed out poll returned version %d, want %d", view.Version, current.Version)
+	}
+
+	// Starting the game wakes a waiting poll
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		apiCall(t, handler, "POST", path+"/actions", alice.Token, ActionRequest{Type: "startGame"}, nil)
+	}()
+	start = time.Now()
+	apiCall(t, handler, "GET", path+"?after="+strconv.Itoa(current.Version)+"&wait=10s", alice.Token, nil, &view)
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > 5*time.Second {
+		t.Errorf("the poll returned after %v, want it woken by the action", elapsed)
+	}
+	if view.Version <= current.Version || !view.GameState.GameStarted {
+		t.Errorf("the woken poll returned version %d, started %v; want the started game after version %d", view.Version, view.GameState.GameStarted, current.Version)
+	}
+
+	if status := apiCall(t, handler, "GET", path+"?after=soon", alice.Token, nil, nil); status != http.StatusBadRequest {
+		t.Errorf("a malformed version answered %d", status)
+	}
+}
+
+func TestAPIRefusesGamesWhileDraining(t *testing.T) {
+	handler := useAPI(t)
+	var alice JoinedPayload
+	if status := apiCall(t, handler, "POST", "/games", "", map[string]any{"numberOfPlayers": 3, "playerName": "Alice"}, &alice); status != http.StatusCreated {
+		t.Fatalf("creating a game answered %d", status)
+	}
+	t.Cleanup(func() {
+		if session, ok := manager.find(alice.SessionID); ok {
+			manager.remove(session)
+		}
+	})
+
+	draining.Store(true)
+	t.Cleanup(func() { draining.Store(false) })
+	if status := apiCall(t, handler, "POST", "/games", "", map[string]any{"numberOfPlayers": 2, "playerName": "Bob"}, nil); status != http.StatusServiceUnavailable {
+		t.Errorf("creating a game while draining answered %d", status)
+	}
+	if status := apiCall(t, handler, "POST", "/games/"+alice.RoomCode+"/players", "", map[string]any{"playerName": "Bob"}, nil); status != http.StatusServiceUnavailable {
+		t.Errorf("joining a game while draining answered %d", status)
+	}
+}
+
+func TestAPIRequestsAreRateLimited(t *testing.T) {
+	handler := useAPI(t)
+	config.MessageRate, config.MessageBurst = 0.001, 2
+
+	for i, want := range []int{http.StatusNotFound, http.StatusNotFound, http.StatusTooManyRequests} {
+		var errPayload ErrorPayload
+		if status := apiCall(t, handler, "POST", "/games/NO-SUCH-GAME/actions", "", ActionRequest{Type: "drawSalvo"}, &errPayload); status != want {
+			t.Errorf("request %d answered %d %q, want %d", i+1, status, errPayload.Code, want)
+		}
+	}
+
+	// Another IP has its own budget
+	req := httptest.NewRequest("POST", "/games/NO-SUCH-GAME/actions", bytes.NewReader([]byte(`{"type": "drawSalvo"}`)))
+	req.RemoteAddr = "198.51.100.7:4000"
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("a request from another IP answered %d", rec.Code)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Chat channels. Everyone at the table reads the players channel but only
+// players may write to it; the spectators channel is private to spectators so
+// they cannot coach the players.
+const (
+	ChatChannelPlayers    = "players"
+	ChatChannelSpectators = "spectators"
+)
+
+// Quick signals are canned messages the client renders itself
+var chatSignals = map[string]bool{
+	"gg":         true,
+	"goodLuck":   true,
+	"niceShot":   true,
+	"wellPlayed": true,
+	"oops":       true,
+	"thinking":   true,
+	"hurryUp":    true,
+}
+
+type ChatMessage struct {
+	ID      int       `json:"id"`
+	From    string    `json:"from"`
+	Name    string    `json:"name"`
+	Channel string    `json:"channel"`
+	Text    string    `json:"text,omitempty"`
+	Signal  string    `json:"signal,omitempty"`
+	SentAt  time.Time `json:"sentAt"`
+}
+
+// chatLog holds a session's recent chat history and the send times used for
+// rate limiting.
+type chatLog struct {
+	history []ChatMessage
+	nextID  int
+	recent  map[string][]time.Time
+}
+
+func (session *GameSession) handleChat(client *Client, msg ClientMessage) error {
+	var chatMsg ChatPayload
+	if err := decodePayload(msg, &chatMsg); err != nil {
+		return err
+	}
+
+	channel := ChatChannelPlayers
+	if client.spectator {
+		channel = ChatChannelSpectators
+	}
+	if chatMsg.Channel != "" && chatMsg.Channel != channel {
+		return newProtocolError(CodeForbidden, "cannot post in the %s channel", chatMsg.Channel)
+	}
+
+	text := strings.TrimSpace(chatMsg.Text)
+	if utf8.RuneCountInString(text) > config.ChatMaxLength {
+		return newProtocolError(CodeBadRequest, "chat messages are limited to %d characters", config.ChatMaxLength)
+	}
+	if chatMsg.Signal != "" && !chatSignals[chatMsg.Signal] {
+		return newProtocolError(CodeBadRequest, "unknown signal %q", chatMsg.Signal)
+	}
+	if text == "" && chatMsg.Signal == "" {
+		return newProtocolError(CodeBadRequest, "chat message is empty")
+	}
+
+	message, err := session.postChat(ChatMessage{
+		From:    client.playerID,
+		Name:    client.playerName,
+		Channel: channel,
+		Text:    text,
+		Signal:  chatMsg.Signal,
+	})
+	if err != nil {
+		return err
+	}
+	session.broadcastChat(message)
+	return nil
+}
+
+// postChat rate limits the sender to chatRateLimit messages per
+// chatRateWindow and records the message in the session's chat history,
+// which keeps the last chatHistorySize messages.
+func (session *GameSession) postChat(message ChatMessage) (ChatMessage, error) {
+	now := time.Now()
+	if session.chat.recent == nil {
+		session.chat.recent = make(map[string][]time.Time)
+	}
+	recent := session.chat.recent[message.From]
+	for len(recent) > 0 && now.Sub(recent[0]) > time.Duration(config.ChatRateWindow) {
+		recent = recent[1:]
+	}
+	if len(recent) >= config.ChatRateLimit {
+		session.chat.recent[message.From] = recent
+		return ChatMessage{}, newProtocolError(CodeRateLimited, "you are sending messages too quickly")
+	}
+	session.chat.recent[message.From] = append(recent, now)
+
+	session.chat.nextID++
+	message.ID = session.chat.nextID
+	message.SentAt = now
+	session.chat.history = append(session.chat.history, message)
+	if size := config.ChatHistorySize; len(session.chat.history) > size {
+		session.chat.history = session.chat.history[len(session.chat.history)-size:]
+	}
+	return message, nil
+}
+
+func (session *GameSession) broadcastChat(message ChatMessage) {
+	response := newServerMessage("chat", ChatMessagesPayload{Messages: []ChatMessage{message}})
+	if message.Channel == ChatChannelPlayers {
+		for _, client := range session.players() {
+			client.enqueue(response)
+		}
+	}
+	for _, spectator := range session.Spectators {
+		spectator.enqueue(response)
+	}
+}
+
+// sendChatHistory brings a newly joined client up to date with the messages
+// it is allowed to read.
+func (session *GameSession) sendChatHistory(client *Client) {
+	history := make([]ChatMessage, 0, len(session.chat.history))
+	for _, message := range session.chat.history {
+		if message.Channel == ChatChannelPlayers || client.spectator {
+			history = append(history, message)
+		}
+	}
+	if len(history) == 0 {
+		return
+	}
+
+	client.enqueue(newServerMessage("chat", ChatMessagesPayload{Messages: history}))
+}
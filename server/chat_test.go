@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useChatConfig gives the test its own copy of the config with small chat
+// limits.
+func useChatConfig(t *testing.T) *Config {
+	t.Helper()
+	oldConfig := config
+	cfg := *config
+	cfg.ChatMaxLength = 280
+	cfg.ChatHistorySize = 3
+	cfg.ChatRateLimit = 2
+	cfg.ChatRateWindow = Duration(10 * time.Second)
+	config = &cfg
+	t.Cleanup(func() { config = oldConfig })
+	return &cfg
+}
+
+// newChatSession registers a two player session for the test
+func newChatSession(t *testing.T) *GameSession {
+	t.Helper()
+	session := newGameSession(2)
+	registerSession(session)
+	t.Cleanup(func() { manager.remove(session) })
+	return session
+}
+
+// seatChatClient seats a player or, with spectator set, a spectator named
+// name in the session.
+func seatChatClient(session *GameSession, name string, spectator bool) *Client {
+	client := newClient()
+	session.call(func() error {
+		client.playerID, client.playerName, client.spectator = name, name, spectator
+		if spectator {
+			session.Spectators[name] = client
+		} else {
+			session.Clients[name] = client
+		}
+		return nil
+	})
+	return client
+}
+
+// say posts text in channel as client and returns the error code, or "" when
+// the message was accepted.
+func say(session *GameSession, client *Client, channel, text string) string {
+	payload, _ := json.Marshal(ChatPayload{Channel: channel, Text: text})
+	err := session.call(func() error {
+		return session.handleChat(client, ClientMessage{Type: "chat", Payload: payload})
+	})
+	var protocolErr *ProtocolError
+	if errors.As(err, &protocolErr) {
+		return protocolErr.Code
+	}
+	return ""
+}
+
+// chatTexts drains the client's queue and returns the text of every chat
+// message it was sent.
+func chatTexts(client *Client) []string {
+	var texts []string
+	for {
+		select {
+		case msg := <-client.send:
+			if payload, ok := msg.Payload.(ChatMessagesPayload); ok && msg.Type == "chat" {
+				for _, message := range payload.Messages {
+					texts = append(texts, message.Text)
+				}
+			}
+		default:
+			return texts
+		}
+	}
+}
+
+func TestChatLengthLimit(t *testing.T) {
+	useChatConfig(t)
+	session := newChatSession(t)
+	alice := seatChatClient(session, "alice", false)
+
+	if code := say(session, alice, "", strings.Repeat("é", 280)); code != "" {
+		t.Errorf("280 characters refused with %s", code)
+	}
+	if code := say(session, alice, "", strings.Repeat("é", 281)); code != CodeBadRequest {
+		t.Errorf("281 characters answered %q, want %s", code, CodeBadRequest)
+	}
+	if code := say(session, alice, "", "   "); code != CodeBadRequest {
+		t.Errorf("blank message answered %q, want %s", code, CodeBadRequest)
+	}
+}
+
+func TestChatRateLimit(t *testing.T) {
+	useChatConfig(t)
+	session := newChatSession(t)
+	alice := seatChatClient(session, "alice", false)
+	bob := seatChatClient(session, "bob", false)
+
+	for i := range 2 {
+		if code := say(session, alice, "", "hello"); code != "" {
+			t.Fatalf("message %d refused with %s", i+1, code)
+		}
+	}
+	if code := say(session, alice, "", "hello"); code != CodeRateLimited {
+		t.Fatalf("third message answered %q, want %s", code, CodeRateLimited)
+	}
+	if code := say(session, bob, "", "hi"); code != "" {
+		t.Errorf("Bob was limited for Alice's messages: %s", code)
+	}
+
+	// Once the window has passed Alice may post again
+	session.call(func() error {
+		for i := range session.chat.recent["alice"] {
+			session.chat.recent["alice"][i] = time.Now().Add(-11 * time.Second)
+		}
+		return nil
+	})
+	if code := say(session, alice, "", "hello again"); code != "" {
+		t.Errorf("message after the window refused with %s", code)
+	}
+}
+
+func TestChatChannels(t *testing.T) {
+	useChatConfig(t)
+	session := newChatSession(t)
+	alice := seatChatClient(session, "alice", false)
+	carol := seatChatClient(session, "carol", true)
+
+	if code := say(session, alice, ChatChannelSpectators, "psst"); code != CodeForbidden {
+		t.Errorf("player posting to spectators answered %q, want %s", code, CodeForbidden)
+	}
+	if code := say(session, carol, ChatChannelPlayers, "fire left"); code != CodeForbidden {
+		t.Errorf("spectator posting to players answered %q, want %s", code, CodeForbidden)
+	}
+
+	say(session, alice, "", "good luck")
+	say(session, carol, "", "Alice is winning")
+	if got := chatTexts(alice); len(got) != 1 || got[0] != "good luck" {
+		t.Errorf("Alice read %q, want only the players channel", got)
+	}
+	if got := chatTexts(carol); len(got) != 2 {
+		t.Errorf("Carol read %q, want both channels", got)
+	}
+}
+
+func TestChatHistory(t *testing.T) {
+	cfg := useChatConfig(t)
+	cfg.ChatRateLimit = 10
+	session := newChatSession(t)
+	alice := seatChatClient(session, "alice", false)
+	carol := seatChatClient(session, "carol", true)
+
+	for _, text := range []string{"one", "two", "three", "four"} {
+		say(session, alice, "", text)
+	}
+	say(session, carol, "", "five")
+	session.call(func() error {
+		if n := len(session.chat.history); n != 3 {
+			t.Errorf("history keeps %d messages, want 3", n)
+		}
+		if first := session.chat.history[0]; first.Text != "three" || first.ID != 3 {
+			t.Errorf("history starts with %+v, want message 3", first)
+		}
+		return nil
+	})
+
+	// A joining player is only replayed the players channel
+	bob := seatChatClient(session, "bob", false)
+	dave := seatChatClient(session, "dave", true)
+	session.call(func() error {
+		session.sendChatHistory(bob)
+		session.sendChatHistory(dave)
+		return nil
+	})
+	if got := chatTexts(bob); strings.Join(got, ",") != "three,four" {
+		t.Errorf("Bob was replayed %q, want three,four", got)
+	}
+	if got := chatTexts(dave); strings.Join(got, ",") != "three,four,five" {
+		t.Errorf("Dave was replayed %q, want three,four,five", got)
+	}
+}
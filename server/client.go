@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// pingPeriod is how often the server pings a client. It is shorter than the
+// pong timeout so a healthy peer always has a ping to answer before its read
+// deadline passes.
+func pingPeriod() time.Duration {
+	return time.Duration(config.PongTimeout) * 9 / 10
+}
+
+// Client is a connection's seat in a session. Messages for the client are
+// queued on send and written by the connection's write pump, which is the
+// only goroutine that writes to the connection.
+type Client struct {
+	send      chan ServerMessage
+	done      chan struct{}
+	closeOnce sync.Once
+	closeCode int
+	closeText string
+	log       *slog.Logger
+
+	// Owned by the session goroutine once the client has joined
+	playerID   string
+	playerName string
+	spectator  bool
+	deltas     *deltaStream // nil unless the client negotiated delta updates
+}
+
+func newClient() *Client {
+	return &Client{
+		send:      make(chan ServerMessage, config.SendQueueSize),
+		done:      make(chan struct{}),
+		closeCode: websocket.CloseNormalClosure,
+		log:       slog.Default(),
+	}
+}
+
+// enqueue queues msg for the write pump without blocking. A client whose
+// queue is full is disconnected rather than allowed to stall the session.
+func (c *Client) enqueue(msg ServerMessage) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+	select {
+	case c.send <- msg:
+		return true
+	default:
+		c.log.Warn("Client is not keeping up, disconnecting")
+		c.closeWith(websocket.CloseTryAgainLater, "too slow")
+		return false
+	}
+}
+
+// close asks the write pump to flush the queue and close the connection
+func (c *Client) close() {
+	c.closeWith(websocket.CloseNormalClosure, "")
+}
+
+func (c *Client) closeWith(code int, text string) {
+	c.closeOnce.Do(func() {
+		c.closeCode = code
+		c.closeText = text
+		close(c.done)
+	})
+}
+
+// stateMessage returns the message carrying state for this client: the full
+// state, or a snapshot or patch for delta clients. It reports false when a
+// delta client's view did not change.
+func (c *Client) stateMessage(state StatePayload) (ServerMessage, bool) {
+	if c.deltas == nil {
+		return newServerMessage("state", state), true
+	}
+	return c.deltas.next(state)
+}
+
+// writePump writes the client's queued messages to conn and pings the peer
+// periodically until the client is closed, then sends a close frame and
+// closes the connection.
+func writePump(conn *websocket.Conn, client *Client) {
+	ticker := time.NewTicker(pingPeriod())
+	defer func() {
+		ticker.Stop()
+		conn.Close()
+	}()
+	for {
+		select {
+		case msg := <-client.send:
+			conn.SetWriteDeadline(time.Now().Add(time.Duration(config.WriteTimeout)))
+			if err := writeServerMessage(conn, msg); err != nil {
+				client.log.Info("Write to client failed", "error", err)
+				client.close()
+				return
+			}
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Duration(config.WriteTimeout))); err != nil {
+				client.log.Info("Ping to client failed", "error", err)
+				client.close()
+				return
+			}
+		case <-client.done:
+			// Flush messages queued before the close, such as the error
+			// explaining it
+			conn.SetWriteDeadline(time.Now().Add(time.Duration(config.WriteTimeout)))
+			for len(client.send) > 0 {
+				if err := writeServerMessage(conn, <-client.send); err != nil {
+					return
+				}
+			}
+			closeMsg := websocket.FormatCloseMessage(client.closeCode, client.closeText)
+			conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second))
+			return
+		}
+	}
+}
+
+// clientSet holds every connection that has completed the handshake, for
+// messages addressed to the whole server.
+type clientSet struct {
+	mu      sync.Mutex
+	clients map[*Client]struct{}
+}
+
+var allClients = &clientSet{
+	clients: make(map[*Client]struct{}),
+}
+
+func (s *clientSet) add(client *Client) {
+	s.mu.Lock()
+	s.clients[client] = struct{}{}
+	s.mu.Unlock()
+}
+
+func (s *clientSet) remove(client *Client) {
+	s.mu.Lock()
+	delete(s.clients, client)
+	s.mu.Unlock()
+}
+
+// broadcast queues msg for every connection and returns how many accepted it
+func (s *clientSet) broadcast(msg ServerMessage) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sent := 0
+	for client := range s.clients {
+		if client.enqueue(msg) {
+			sent++
+		}
+	}
+	return sent
+}
+
+// closeAll disconnects every connection
+func (s *clientSet) closeAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for client := range s.clients {
+		client.close()
+	}
+}
+
+// newToken returns a random secret a player can rejoin their seat with
+func newToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Several instances of the server can share the games between them. Each
+// session is owned by the instance that created it, which claims it in the
+// session registry. A websocket connected to any instance can join any
+// session: when the owner is another instance, the connection's instance
+// relays its client messages to the owner over the broker, and the owner
+// relays the session's server messages back, so the connection plays as if
+// the session were local.
+//
+// The registry and broker below keep everything in memory. They serve a
+// single instance, and several instances in one process in tests; spreading
+// instances over machines needs implementations backed by a shared store
+// and message bus.
+
+// SessionRegistry records which instance owns each session. Sessions are
+// registered under both their ID and their room code.
+type SessionRegistry interface {
+	// Claim makes instance the owner of sessionID. It fails with
+	// errSessionClaimed when another instance owns the session.
+	Claim(sessionID, instance string) error
+	// Owner returns the instance that owns sessionID, or "" when none does
+	Owner(sessionID string) (string, error)
+	// Release gives up instance's claim on sessionID
+	Release(sessionID, instance string) error
+}
+
+// Broker delivers messages between instances
+type Broker interface {
+	// Publish sends data to every subscriber of topic
+	Publish(topic string, data []byte) error
+	// Subscribe calls handler with each message published to topic, one at
+	// a time and in order, until unsubscribe is called.
+	Subscribe(topic string, handler func(data []byte)) (unsubscribe func(), err error)
+}
+
+var (
+	registry SessionRegistry = newMemoryRegistry()
+	broker   Broker          = newLocalBroker()
+)
+
+var errSessionClaimed = errors.New("the session is owned by another instance")
+
+// defaultInstanceID names the instance after its host, which is unique
+// among instances that run one per machine or container.
+func defaultInstanceID() string {
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		return hostname
+	}
+	return "salvo"
+}
+
+type memoryRegistry struct {
+	mu     sync.Mutex
+	owners map[string]string // sessionID -> instance
+}
+
+func newMemoryRegistry() *memoryRegistry {
+	return &memoryRegistry{owners: make(map[string]string)}
+}
+
+func (r *memoryRegistry) Claim(sessionID, instance string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if owner, ok := r.owners[sessionID]; ok && owner != instance {
+		return errSessionClaimed
+	}
+	r.owners[sessionID] = instance
+	return nil
+}
+
+func (r *memoryRegistry) Owner(sessionID string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.owners[sessionID], nil
+}
+
+func (r *memoryRegistry) Release(sessionID, instance string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.owners[sessionID] == instance {
+		delete(r.owners, sessionID)
+	}
+	return nil
+}
+
+// localBroker delivers messages within the process. Each subscription has
+// a goroutine that runs its handler, so a handler may publish without
+// waiting for itself.
+type localBroker struct {
+	mu            sync.Mutex
+	subscriptions map[string][]*subscription
+}
+
+type subscription struct {
+	messages chan []byte
+	done     chan struct{}
+}
+
+func newLocalBroker() *localBroker {
+	return &localBroker{subscriptions: make(map[string][]*subscription)}
+}
+
+func (b *localBroker) Publish(topic string, data []byte) error {
+	b.mu.Lock()
+	subscriptions := b.subscriptions[topic]
+	b.mu.Unlock()
+	for _, sub := range subscriptions {
+		select {
+		case sub.messages <- data:
+		case <-sub.done:
+		}
+	}
+	return nil
+}
+
+func (b *localBroker) Subscribe(topic string, handler func(data []byte)) (func(), error) {
+	sub := &subscription{
+		messages: make(chan []byte, 1024),
+		done:     make(chan struct{}),
+	}
+	b.mu.Lock()
+	b.subscriptions[topic] = append(b.subscriptions[topic], sub)
+	b.mu.Unlock()
+
+	go func() {
+		for {
+			select {
+			case data := <-sub.messages:
+				handler(data)
+			case <-sub.done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			for i, other := range b.subscriptions[topic] {
+				if other == sub {
+					b.subscriptions[topic] = append(b.subscriptions[topic][:i:i], b.subscriptions[topic][i+1:]...)
+					break
+				}
+			}
+			close(sub.done)
+		})
+	}, nil
+}
+
+// Kinds of relay message. The instance holding a connection sends client
+// and disconnect; the session's owner sends joined, server and close.
+const (
+	relayClient     = "client"     // a client message from the connection
+	relayDisconnect = "disconnect" // the connection closed
+	relayJoined     = "joined"     // the answer to a relayed join, with Error set when it failed
+	relayServer     = "server"     // a server message for the connection
+	relayClose      = "close"      // the owner closed its end, for example to kick the player
+)
+
+// relayMessage is what instances tell each other about a relayed
+// connection. Each instance receives on its own topic.
+type relayMessage struct {
+	Kind      string          `json:"kind"`
+	From      string          `json:"from"`
+	ConnID    string          `json:"connId"` // unique on the instance holding the connection
+	Features  []string        `json:"features,omitempty"`
+	Client    *ClientMessage  `json:"client,omitempty"`
+	Server    json.RawMessage `json:"server,omitempty"`
+	Error     *ErrorPayload   `json:"error,omitempty"`
+	CloseCode int             `json:"closeCode,omitempty"`
+	CloseText string          `json:"closeText,omitempty"`
+}
+
+// relayTimeout is how long a connection waits for the owner of a session
+// to answer a join
+const relayTimeout = 10 * time.Second
+
+var errOwnerUnavailable = newProtocolError(CodeNotFound, "the server hosting the game session did not answer")
+
+func relayTopic(instance string) string {
+	return "salvo.instance." + instance
+}
+
+func publishRelay(instance string, msg relayMessage) error {
+	msg.From = config.InstanceID
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return broker.Publish(relayTopic(instance), data)
+}
+
+// relay links a local connection to the session it joined on another
+// instance
+type relay struct {
+	owner     string
+	connID    string
+	sessionID string
+	client    *Client
+	joined    chan *ErrorPayload
+}
+
+// relayedClient is a connection on another instance seated in a local
+// session
+type relayedClient struct {
+	client  *Client
+	session *GameSession
+}
+
+// relayTable holds both ends of the relays passing through this instance
+type relayTable struct {
+	mu       sync.Mutex
+	outgoing map[string]*relay         // connID -> local connection
+	incoming map[string]*relayedClient // instance/connID -> remote connection
+}
+
+var relays = &relayTable{
+	outgoing: make(map[string]*relay),
+	incoming: make(map[string]*relayedClient),
+}
+
+var lastConnID atomic.Uint64
+
+// sessionOwner returns the instance that owns the session a join message
+// names, or "" when it is this instance or unknown.
+func sessionOwner(msg ClientMessage) string {
+	if msg.Type == "createGame" {
+		return ""
+	}
+	var joinMsg JoinGamePayload
+	if decodePayload(msg, &joinMsg) != nil {
+		return ""
+	}
+	if _, local := manager.find(joinMsg.SessionID); local {
+		return ""
+	}
+	owner, err := registry.Owner(joinMsg.SessionID)
+	if err == nil && owner == "" {
+		owner, err = registry.Owner(normalizeRoomCode(joinMsg.SessionID))
+	}
+	if err != nil {
+		slog.Error("Session registry lookup failed", "session", joinMsg.SessionID, "error", err)
+		return ""
+	}
+	if owner == config.InstanceID {
+		return ""
+	}
+	return owner
+}
+
+// relayJoin sends a join message to the instance that owns the session and
+// waits for its answer. Once joined, the connection's messages go to the
+// owner.
+func relayJoin(ctx *SessionContext, owner string, msg ClientMessage) error {
+	var joinMsg JoinGamePayload
+	decodePayload(msg, &joinMsg)
+	link := &relay{
+		owner:     owner,
+		connID:    strconv.FormatUint(lastConnID.Add(1), 10),
+		sessionID: joinMsg.SessionID,
+		client:    ctx.Client,
+		joined:    make(chan *ErrorPayload, 1),
+	}
+	var features []string
+	if ctx.Features[FeatureDelta] {
+		features = append(features, FeatureDelta)
+	}
+	relays.mu.Lock()
+	relays.outgoing[link.connID] = link
+	relays.mu.Unlock()
+
+	err := publishRelay(owner, relayMessage{Kind: relayClient, ConnID: link.connID, Features: features, Client: &msg})
+	if err == nil {
+		select {
+		case result := <-link.joined:
+			if result != nil {
+				err = newProtocolError(result.Code, "%s", result.Message)
+			}
+		case <-time.After(relayTimeout):
+			err = errOwnerUnavailable
+		}
+	}
+	if err != nil {
+		link.disconnect()
+		return err
+	}
+	ctx.Relay = link
+	ctx.Log = ctx.Log.With("session", link.sessionID, "owner", owner)
+	ctx.Log.Info("Relaying connection to the session's instance")
+	return nil
+}
+
+// forward sends a client message to the session's owner
+func (link *relay) forward(msg ClientMessage) error {
+	return publishRelay(link.owner, relayMessage{Kind: relayClient, ConnID: link.connID, Client: &msg})
+}
+
+// disconnect tells the owner the connection has gone
+func (link *relay) disconnect() {
+	relays.mu.Lock()
+	delete(relays.outgoing, link.connID)
+	relays.mu.Unlock()
+	if err := publishRelay(link.owner, relayMessage{Kind: relayDisconnect, ConnID: link.connID}); err != nil {
+		link.client.log.Error("Relaying disconnect failed", "error", err)
+	}
+}
+
+// handleRelayMessage handles the messages other instances send this one
+func handleRelayMessage(data []byte) {
+	var msg relayMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		slog.Error("Invalid relay message", "error", err)
+		return
+	}
+	switch msg.Kind {
+	case relayClient:
+		handleRelayedClientMessage(msg)
+	case relayDisconnect:
+		key := msg.From + "/" + msg.ConnID
+		relays.mu.Lock()
+		remote, ok := relays.incoming[key]
+		delete(relays.incoming, key)
+		relays.mu.Unlock()
+		if ok {
+			remote.client.close()
+			remote.session.post(func() { remote.session.removeClient(remote.client) })
+		}
+	case relayJoined, relayServer, relayClose:
+		relays.mu.Lock()
+		link, ok := relays.outgoing[msg.ConnID]
+		relays.mu.Unlock()
+		if !ok {
+			return
+		}
+		switch msg.Kind {
+		case relayJoined:
+			link.joined <- msg.Error
+		case relayServer:
+			var server struct {
+				ServerMessage
+				Payload json.RawMessage `json:"payload,omitempty"`
+			}
+			if err := json.Unmarshal(msg.Server, &server); err != nil {
+				link.client.log.Error("Invalid relayed server message", "error", err)
+				return
+			}
+			server.ServerMessage.Payload = server.Payload
+			link.client.enqueue(server.ServerMessage)
+		case relayClose:
+			link.client.closeWith(msg.CloseCode, msg.CloseText)
+		}
+	default:
+		slog.Warn("Unknown relay message", "kind", msg.Kind, "from", msg.From)
+	}
+}
+
+// handleRelayedClientMessage applies a client message from a connection
+// on another instance. The first message is the join; it is answered with
+// joined, and the seat it takes is then played like a local connection's.
+func handleRelayedClientMessage(msg relayMessage) {
+	if msg.Client == nil {
+		return
+	}
+	key := msg.From + "/" + msg.ConnID
+	relays.mu.Lock()
+	remote, ok := relays.incoming[key]
+	relays.mu.Unlock()
+	if ok {
+		client, clientMsg := remote.client, *msg.Client
+		if !remote.session.post(func() {
+			if err := remote.session.handleClientMessage(client, clientMsg); err != nil {
+				sendError(client, clientMsg, err)
+			}
+		}) {
+			sendError(client, clientMsg, errSessionClosed)
+		}
+		return
+	}
+
+	reply := relayMessage{Kind: relayJoined, ConnID: msg.ConnID}
+	if err := seatRelayedClient(key, msg); err != nil {
+		protocolErr := asProtocolError(err)
+		reply.Error = &ErrorPayload{Code: protocolErr.Code, Message: protocolErr.Message}
+	}
+	if err := publishRelay(msg.From, reply); err != nil {
+		slog.Error("Relaying join result failed", "instance", msg.From, "error", err)
+	}
+}
+
+func seatRelayedClient(key string, msg relayMessage) error {
+	switch msg.Client.Type {
+	case "joinGame", "rejoinGame", "spectateGame":
+	default:
+		return newProtocolError(CodeBadRequest, "create or join a game before sending %s", msg.Client.Type)
+	}
+	session, err := findOrCreateSession(*msg.Client)
+	if err != nil {
+		return err
+	}
+	client := newClient()
+	client.log = slog.With("instance", msg.From, "transport", "relay")
+	for _, feature := range msg.Features {
+		if feature == FeatureDelta {
+			client.deltas = &deltaStream{}
+		}
+	}
+	if err := session.call(func() error { return session.handleClientMessage(client, *msg.Client) }); err != nil {
+		return err
+	}
+	relays.mu.Lock()
+	relays.incoming[key] = &relayedClient{client: client, session: session}
+	relays.mu.Unlock()
+	go relayPump(client, key, msg.From, msg.ConnID)
+	return nil
+}
+
+// relayPump sends a relayed client's queued messages to the instance
+// holding its connection until the client is closed. It is the relay
+// counterpart of writePump.
+func relayPump(client *Client, key, instance, connID string) {
+	send := func(msg ServerMessage) bool {
+		data, err := json.Marshal(msg)
+		if err == nil {
+			err = publishRelay(instance, relayMessage{Kind: relayServer, ConnID: connID, Server: data})
+		}
+		if err != nil {
+			client.log.Info("Relay to client failed", "error", err)
+			return false
+		}
+		return true
+	}
+	for {
+		select {
+		case msg := <-client.send:
+			if !send(msg) {
+				client.close()
+			}
+		case <-client.done:
+			for len(client.send) > 0 {
+				if !send(<-client.send) {
+					break
+				}
+			}
+			relays.mu.Lock()
+			delete(relays.incoming, key)
+			relays.mu.Unlock()
+			publishRelay(instance, relayMessage{Kind: relayClose, ConnID: connID, CloseCode: client.closeCode, CloseText: client.closeText})
+			return
+		}
+	}
+}
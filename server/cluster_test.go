@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// useCluster gives the test its own registry and broker, names this
+// instance "a" and subscribes it to its relay topic.
+func useCluster(t *testing.T) {
+	t.Helper()
+	oldRegistry, oldBroker, oldInstance := registry, broker, config.InstanceID
+	registry, broker, config.InstanceID = newMemoryRegistry(), newLocalBroker(), "a"
+	unsubscribe, err := broker.Subscribe(relayTopic("a"), handleRelayMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		unsubscribe()
+		registry, broker, config.InstanceID = oldRegistry, oldBroker, oldInstance
+	})
+}
+
+// fakeInstance subscribes to the relay topic of another instance and
+// returns the messages it receives.
+func fakeInstance(t *testing.T, name string) <-chan relayMessage {
+	t.Helper()
+	received := make(chan relayMessage, 64)
+	unsubscribe, err := broker.Subscribe(relayTopic(name), func(data []byte) {
+		var msg relayMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Errorf("invalid relay message: %v", err)
+			return
+		}
+		received <- msg
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(unsubscribe)
+	return received
+}
+
+func publishAs(t *testing.T, from, to string, msg relayMessage) {
+	t.Helper()
+	msg.From = from
+	data, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.Publish(relayTopic(to), data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func nextRelay(t *testing.T, received <-chan relayMessage, kind string) relayMessage {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case msg := <-received:
+			if msg.Kind == kind {
+				return msg
+			}
+		case <-timeout:
+			t.Fatalf("no %s relay message", kind)
+		}
+	}
+}
+
+func clientMessage(t *testing.T, msgType string, payload any) ClientMessage {
+	t.Helper()
+	msg := ClientMessage{Version: ProtocolVersion, ID: msgType, Type: msgType}
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg.Payload = data
+	}
+	return msg
+}
+
+func TestMemoryRegistry(t *testing.T) {
+	r := newMemoryRegistry()
+	if err := r.Claim("s1", "a"); err != nil {
+		t.Fatalf("Claim: %v", err)
+	}
+	if err := r.Claim("s1", "a"); err != nil {
+		t.Errorf("reclaiming an owned session: %v", err)
+	}
+	if err := r.Claim("s1", "b"); !errors.Is(err, errSessionClaimed) {
+		t.Errorf("claiming another instance's session: err = %v, want errSessionClaimed", err)
+	}
+	r.Release("s1", "b")
+	if owner, _ := r.Owner("s1"); owner != "a" {
+		t.Errorf("after another instance's release, owner = %q, want a", owner)
+	}
+	r.Release("s1", "a")
+	if owner, _ := r.Owner("s1"); owner != "" {
+		t.Errorf("after release, owner = %q, want none", owner)
+	}
+}
+
+func TestLocalBrokerDeliversInOrder(t *testing.T) {
+	b := newLocalBroker()
+	got := make(chan string, 3)
+	unsubscribe, _ := b.Subscribe("topic", func(data []byte) { got <- string(data) })
+	b.Subscribe("other", func(data []byte) { t.Errorf("message for another topic: %s", data) })
+	for _, msg := range []string{"1", "2", "3"} {
+		b.Publish("topic", []byte(msg))
+	}
+	for _, want := range []string{"1", "2", "3"} {
+		select {
+		case msg := <-got:
+			if msg != want {
+				t.Errorf("got %q, want %q", msg, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("message %q not delivered", want)
+		}
+	}
+
+	unsubscribe()
+	b.Publish("topic", []byte("4"))
+	select {
+	case msg := <-got:
+		t.Errorf("delivered %q after unsubscribe", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// A websocket joining a session owned by another instance is relayed there
+func TestJoinIsRelayedToOwner(t *testing.T) {
+	useCluster(t)
+	owner := fakeInstance(t, "b")
+	registry.Claim("remote-1", "b")
+
+	server := httptest.NewServer(http.HandlerFunc(handleWebSocket))
+	defer server.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	readType := func() string {
+		t.Helper()
+		var msg ServerMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		return msg.Type
+	}
+
+	conn.WriteJSON(clientMessage(t, "hello", HelloPayload{ProtocolVersion: ProtocolVersion}))
+	if got := readType(); got != "welcome" {
+		t.Fatalf("got %s, want welcome", got)
+	}
+	conn.WriteJSON(clientMessage(t, "joinGame", JoinGamePayload{SessionID: "remote-1", PlayerName: "Bob"}))
+
+	join := nextRelay(t, owner, relayClient)
+	if join.From != "a" || join.Client.Type != "joinGame" {
+		t.Fatalf("relayed %+v, want joinGame from a", join)
+	}
+	joined, _ := json.Marshal(newServerMessage("joined", JoinedPayload{SessionID: "remote-1", PlayerID: "2"}))
+	publishAs(t, "b", "a", relayMessage{Kind: relayServer, ConnID: join.ConnID, Server: joined})
+	publishAs(t, "b", "a", relayMessage{Kind: relayJoined, ConnID: join.ConnID})
+	if got := readType(); got != "joined" {
+		t.Fatalf("got %s, want the owner's joined", got)
+	}
+
+	conn.WriteJSON(clientMessage(t, "drawSalvo", nil))
+	if msg := nextRelay(t, owner, relayClient); msg.Client.Type != "drawSalvo" || msg.ConnID != join.ConnID {
+		t.Errorf("relayed %+v, want drawSalvo on the joined connection", msg)
+	}
+
+	publishAs(t, "b", "a", relayMessage{Kind: relayClose, ConnID: join.ConnID, CloseCode: websocket.ClosePolicyViolation, CloseText: "kicked"})
+	var msg ServerMessage
+	if err := conn.ReadJSON(&msg); !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Errorf("read after the owner closed: %v, want close 1008", err)
+	}
+	nextRelay(t, owner, relayDisconnect)
+}
+
+// The owner seats a relayed connection and sends it the session's messages
+func TestOwnerSeatsRelayedConnection(t *testing.T) {
+	useCluster(t)
+	origin := fakeInstance(t, "b")
+	session := createNewSession(2)
+	defer manager.remove(session)
+	if owner, _ := registry.Owner(session.ID); owner != "a" {
+		t.Fatalf("new session owned by %q, want a", owner)
+	}
+
+	publishAs(t, "b", "a", relayMessage{Kind: relayClient, ConnID: "7",
+		Client: ptr(clientMessage(t, "joinGame", JoinGamePayload{SessionID: session.ID, PlayerName: "Bob"}))})
+	// The session's messages may overtake the join result
+	var types []string
+	joined := false
+	for !joined || len(types) < 3 {
+		select {
+		case relayed := <-origin:
+			switch relayed.Kind {
+			case relayJoined:
+				if relayed.Error != nil {
+					t.Fatalf("join failed: %+v", relayed.Error)
+				}
+				joined = true
+			case relayServer:
+				var msg ServerMessage
+				json.Unmarshal(relayed.Server, &msg)
+				types = append(types, msg.Type)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("joined %v, relayed %v", joined, types)
+		}
+	}
+	if strings.Join(types, ",") != "joined,ack,state" {
+		t.Errorf("relayed %v, want joined, ack and state", types)
+	}
+
+	publishAs(t, "b", "a", relayMessage{Kind: relayDisconnect, ConnID: "7"})
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		connected := 0
+		session.call(func() error { connected = len(session.Clients); return nil })
+		if connected == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("relayed player still connected after disconnect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	publishAs(t, "b", "a", relayMessage{Kind: relayClient, ConnID: "8",
+		Client: ptr(clientMessage(t, "joinGame", JoinGamePayload{SessionID: "missing", PlayerName: "Eve"}))})
+	if result := nextRelay(t, origin, relayJoined); result.Error == nil || result.Error.Code != CodeNotFound {
+		t.Errorf("joining a missing session answered %+v, want not_found", result.Error)
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,231 @@
+// Command salvo-cli plays Salvo from a terminal. It connects to a game
+// server's websocket, redraws the table after every server message and
+// reads commands from standard input:
+//
+//	salvo-cli -name Alice -create 2
+//	salvo-cli -name Bob -join BLUE-DESTROYER-42
+//
+// Type help at the prompt for the list of commands.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// protocolVersion must match ProtocolVersion in the server
+const protocolVersion = 1
+
+// serverMessage is the envelope of every message from the server
+type serverMessage struct {
+	Version int             `json:"v"`
+	ID      string          `json:"id"`
+	ReplyTo string          `json:"replyTo,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+type clientMessage struct {
+	Version int    `json:"v"`
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Payload any    `json:"payload,omitempty"`
+}
+
+// client sends messages to the server. Only the main goroutine sends.
+type client struct {
+	conn   *websocket.Conn
+	lastID int
+}
+
+func (c *client) send(msgType string, payload any) error {
+	c.lastID++
+	return c.conn.WriteJSON(clientMessage{
+		Version: protocolVersion,
+		ID:      strconv.Itoa(c.lastID),
+		Type:    msgType,
+		Payload: payload,
+	})
+}
+
+func main() {
+	serverURL := flag.String("server", "ws://localhost:8080/ws", "websocket URL of the game server")
+	name := flag.String("name", os.Getenv("USER"), "player name")
+	create := flag.Int("create", 0, "create a game for this many players")
+	join := flag.String("join", "", "join the game with this room code or session ID")
+	spectate := flag.String("spectate", "", "watch the game with this room code or session ID")
+	flag.Parse()
+
+	conn, _, err := websocket.DefaultDialer.Dial(*serverURL, nil)
+	if err != nil {
+		log.Fatalf("Connecting to %s: %v", *serverURL, err)
+	}
+	defer conn.Close()
+
+	c := &client{conn: conn}
+	if err := c.send("hello", map[string]any{"protocolVersion": protocolVersion, "client": "salvo-cli"}); err != nil {
+		log.Fatalf("Handshake failed: %v", err)
+	}
+
+	messages := make(chan serverMessage)
+	go func() {
+		defer close(messages)
+		for {
+			var msg serverMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			messages <- msg
+		}
+	}()
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	v := &view{name: *name}
+	switch {
+	case *create > 0:
+		err = c.send("createGame", map[string]any{"numberOfPlayers": *create, "playerName": *name})
+	case *join != "":
+		err = c.send("joinGame", map[string]any{"sessionId": *join, "playerName": *name})
+	case *spectate != "":
+		err = c.send("spectateGame", map[string]any{"sessionId": *spectate, "playerName": *name})
+	default:
+		v.logf("Type create <players> or join <room code> to start")
+	}
+	if err != nil {
+		log.Fatalf("Sending: %v", err)
+	}
+	v.render(os.Stdout)
+
+	for {
+		select {
+		case msg, ok := <-messages:
+			if !ok {
+				v.logf("Disconnected from the server")
+				v.render(os.Stdout)
+				return
+			}
+			v.handle(msg)
+		case line, ok := <-lines:
+			if !ok {
+				return
+			}
+			quit, err := v.command(c, line)
+			if err != nil {
+				v.logf("%v", err)
+			}
+			if quit {
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				return
+			}
+		}
+		v.render(os.Stdout)
+	}
+}
+
+const help = `Commands:
+  create <players>       create a game and take the first seat
+  join <room code>       join a game
+  spectate <room code>   watch a game
+  start                  deal once every seat is taken
+  d                      draw a salvo
+  s                      draw a ship
+  f <hand> <target>      fire salvo H<hand> at ship T<target>
+  x <hand>               discard salvo H<hand>
+  say <text>             chat
+  help                   show this list
+  q                      quit`
+
+// command runs a line typed by the user. It reports true when the user
+// wants to quit.
+func (v *view) command(c *client, line string) (bool, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return false, nil
+	}
+	args := fields[1:]
+	switch fields[0] {
+	case "q", "quit":
+		return true, nil
+	case "help", "?":
+		v.logf("%s", help)
+		return false, nil
+	case "create":
+		players, err := intArg(args, 0)
+		if err != nil {
+			return false, err
+		}
+		return false, c.send("createGame", map[string]any{"numberOfPlayers": players, "playerName": v.name})
+	case "join", "spectate":
+		if len(args) != 1 {
+			return false, fmt.Errorf("usage: %s <room code>", fields[0])
+		}
+		msgType := "joinGame"
+		if fields[0] == "spectate" {
+			msgType = "spectateGame"
+		}
+		return false, c.send(msgType, map[string]any{"sessionId": args[0], "playerName": v.name})
+	case "start":
+		return false, c.send("startGame", nil)
+	case "d":
+		return false, c.send("drawSalvo", nil)
+	case "s":
+		return false, c.send("drawShip", nil)
+	case "f":
+		hand, err := intArg(args, 0)
+		if err != nil {
+			return false, err
+		}
+		target, err := intArg(args, 1)
+		if err != nil {
+			return false, err
+		}
+		salvo, err := v.handCard(hand)
+		if err != nil {
+			return false, err
+		}
+		ship, err := v.target(target)
+		if err != nil {
+			return false, err
+		}
+		return false, c.send("fireSalvo", map[string]any{"salvo": salvo, "target": ship.card})
+	case "x":
+		hand, err := intArg(args, 0)
+		if err != nil {
+			return false, err
+		}
+		salvo, err := v.handCard(hand)
+		if err != nil {
+			return false, err
+		}
+		return false, c.send("discardSalvo", map[string]any{"salvo": salvo})
+	case "say":
+		return false, c.send("chat", map[string]any{"text": strings.Join(args, " ")})
+	}
+	return false, fmt.Errorf("unknown command %q, type help for the list", fields[0])
+}
+
+func intArg(args []string, i int) (int, error) {
+	if i >= len(args) {
+		return 0, fmt.Errorf("missing argument, type help for usage")
+	}
+	n, err := strconv.Atoi(strings.TrimLeft(args[i], "HhTt"))
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number", args[i])
+	}
+	return n, nil
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"game-server/engine"
+)
+
+const logLines = 8
+
+// view is what the player knows about the game, rebuilt from server
+// messages.
+type view struct {
+	name      string
+	sessionID string
+	roomCode  string
+	playerID  string
+	spectator bool
+
+	state    *engine.State
+	shipDeck int
+	playDeck int
+	discards int
+
+	log []string // recent events, errors and chat
+}
+
+// target is a ship in an opponent's battle line that can be fired at
+type target struct {
+	owner engine.Player
+	card  engine.ShipCard
+}
+
+type statePayload struct {
+	SessionID     string       `json:"sessionId"`
+	GameState     engine.State `json:"gameState"`
+	ShipDeckCount int          `json:"shipDeckCount"`
+	PlayDeckCount int          `json:"playDeckCount"`
+	DiscardCount  int          `json:"discardCount"`
+}
+
+type joinedPayload struct {
+	SessionID string `json:"sessionId"`
+	RoomCode  string `json:"roomCode"`
+	PlayerID  string `json:"playerId"`
+	Spectator bool   `json:"spectator"`
+}
+
+type chatPayload struct {
+	Messages []struct {
+		Name    string `json:"name"`
+		Channel string `json:"channel"`
+		Text    string `json:"text"`
+		Signal  string `json:"signal"`
+	} `json:"messages"`
+}
+
+// handle updates the view from a server message
+func (v *view) handle(msg serverMessage) {
+	var payload map[string]any
+	switch msg.Type {
+	case "welcome", "ack":
+	case "joined":
+		var joined joinedPayload
+		json.Unmarshal(msg.Payload, &joined)
+		v.sessionID, v.roomCode, v.playerID, v.spectator = joined.SessionID, joined.RoomCode, joined.PlayerID, joined.Spectator
+		if joined.Spectator {
+			v.logf("Watching game %s", joined.RoomCode)
+		} else {
+			v.logf("Joined game %s as player %s; others join with %s", joined.RoomCode, joined.PlayerID, joined.RoomCode)
+		}
+	case "state":
+		var state statePayload
+		json.Unmarshal(msg.Payload, &state)
+		v.state = &state.GameState
+		v.shipDeck, v.playDeck, v.discards = state.ShipDeckCount, state.PlayDeckCount, state.DiscardCount
+		if state.GameState.Winner != "" {
+			v.logf("Game over: %s wins", v.playerName(state.GameState.Winner))
+		}
+	case "chat":
+		var chat chatPayload
+		json.Unmarshal(msg.Payload, &chat)
+		for _, message := range chat.Messages {
+			text := message.Text
+			if text == "" {
+				text = "[" + message.Signal + "]"
+			}
+			v.logf("%s: %s", message.Name, text)
+		}
+	default:
+		json.Unmarshal(msg.Payload, &payload)
+		switch msg.Type {
+		case "gameStarted":
+			v.logf("The game has started")
+		case "error":
+			v.logf("Error: %v", payload["message"])
+		case "playerDisconnected", "playerReconnected":
+			v.logf("%v %s", payload["name"], strings.ToLower(strings.TrimPrefix(msg.Type, "player")))
+		case "serverNotice":
+			v.logf("Server notice: %v", payload["message"])
+		case "serverShutdown":
+			v.logf("The server is shutting down; finish your turn before %v", payload["deadline"])
+		case "sessionEnded":
+			v.logf("The game was ended: %v", payload["reason"])
+		case "turnMissed":
+			v.logf("Player %v missed their turn deadline", payload["playerId"])
+		case "sessionExpiring":
+			v.logf("The game will be closed for inactivity at %v", payload["expiresAt"])
+		default:
+			v.logf("Unhandled %s message", msg.Type)
+		}
+	}
+}
+
+func (v *view) logf(format string, args ...any) {
+	v.log = append(v.log, fmt.Sprintf(format, args...))
+	if len(v.log) > logLines {
+		v.log = v.log[len(v.log)-logLines:]
+	}
+}
+
+func (v *view) me() *engine.Player {
+	if v.state == nil {
+		return nil
+	}
+	return v.state.Player(v.playerID)
+}
+
+func (v *view) playerName(id string) string {
+	if v.state != nil {
+		if player := v.state.Player(id); player != nil {
+			return player.Name
+		}
+	}
+	return id
+}
+
+// handCard returns salvo H<n> from the player's hand
+func (v *view) handCard(n int) (engine.SalvoCard, error) {
+	me := v.me()
+	if me == nil || n < 1 || n > len(me.Hand) {
+		return engine.SalvoCard{}, fmt.Errorf("there is no salvo H%d in your hand", n)
+	}
+	return me.Hand[n-1], nil
+}
+
+// targets lists the ships in the opponents' battle lines in the order they
+// are numbered on screen
+func (v *view) targets() []target {
+	if v.state == nil {
+		return nil
+	}
+	var targets []target
+	for _, player := range v.state.Players {
+		if player.ID == v.playerID {
+			continue
+		}
+		for _, ship := range player.PlayedShips {
+			targets = append(targets, target{owner: player, card: ship})
+		}
+	}
+	return targets
+}
+
+func (v *view) target(n int) (target, error) {
+	targets := v.targets()
+	if n < 1 || n > len(targets) {
+		return target{}, fmt.Errorf("there is no target T%d", n)
+	}
+	return targets[n-1], nil
+}
+
+// render clears the terminal and draws the table
+func (v *view) render(w io.Writer) {
+	var b strings.Builder
+	b.WriteString("\033[H\033[2J")
+	fmt.Fprintf(&b, "SALVO  game %s  you are %s\n\n", orDash(v.roomCode), orDash(v.name))
+
+	if v.state != nil {
+		switch {
+		case v.state.Winner != "":
+			fmt.Fprintf(&b, "Game over, %s wins\n", v.playerName(v.state.Winner))
+		case v.state.GameStarted:
+			turn := v.playerName(v.state.CurrentPlayerId)
+			if v.state.CurrentPlayerId == v.playerID {
+				turn = "your turn"
+			}
+			fmt.Fprintf(&b, "Turn: %s\n", turn)
+		default:
+			fmt.Fprintf(&b, "Waiting for players: %d seated\n", len(v.state.Players))
+		}
+		fmt.Fprintf(&b, "Ship deck %d  Play deck %d  Discards %d\n\n", v.shipDeck, v.playDeck, v.discards)
+
+		number := 0
+		for _, player := range v.state.Players {
+			marker := ""
+			switch {
+			case player.Eliminated:
+				marker = " eliminated"
+			case player.ID == v.state.CurrentPlayerId && v.state.GameStarted:
+				marker = " *"
+			}
+			fmt.Fprintf(&b, "%s (%s)%s\n", player.Name, player.ID, marker)
+			b.WriteString("  Battle line: ")
+			if len(player.PlayedShips) == 0 {
+				b.WriteString("none")
+			}
+			for _, ship := range player.PlayedShips {
+				label := ""
+				if player.ID != v.playerID {
+					number++
+					label = fmt.Sprintf("T%d ", number)
+				}
+				fmt.Fprintf(&b, "[%s%s %g\" %dhp] ", label, ship.Name, ship.GunSize, ship.HitPoints)
+			}
+			b.WriteString("\n")
+			fmt.Fprintf(&b, "  Deep six: %d", len(player.DeepSixPile))
+			for _, ship := range player.DeepSixPile {
+				fmt.Fprintf(&b, " [%s]", ship.Name)
+			}
+			b.WriteString("\n")
+		}
+
+		if me := v.me(); me != nil {
+			b.WriteString("\nYour hand: ")
+			if len(me.Hand) == 0 {
+				b.WriteString("empty")
+			}
+			for i, salvo := range me.Hand {
+				fmt.Fprintf(&b, "[H%d %g\" %d dmg] ", i+1, salvo.GunSize, salvo.Damage)
+			}
+			b.WriteString("\nYour ships: ")
+			if len(me.Ships) == 0 {
+				b.WriteString("none")
+			}
+			for _, ship := range me.Ships {
+				fmt.Fprintf(&b, "[%s %g\" %dhp] ", ship.Name, ship.GunSize, ship.HitPoints)
+			}
+			b.WriteString("\n")
+		}
+	}
+
+	b.WriteString("\n")
+	for _, line := range v.log {
+		b.WriteString(line + "\n")
+	}
+	b.WriteString("\nd draw salvo  s draw ship  f <hand> <target> fire  x <hand> discard  help  q quit\n> ")
+	io.WriteString(w, b.String())
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
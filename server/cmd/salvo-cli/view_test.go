@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+// Three players: Alice, who is playing, holds two salvos and an unplayed
+// ship; Bob is to move; Carol has been eliminated.
+var renderMessages = []string{
+	`{"v": 1, "id": "1", "type": "joined", "payload": {"sessionId": "s1", "roomCode": "ABCD", "playerId": "p1"}}`,
+	`{"v": 1, "id": "2", "type": "gameStarted", "payload": {}}`,
+	`{"v": 1, "id": "3", "type": "state", "payload": {
+		"sessionId": "s1", "shipDeckCount": 12, "playDeckCount": 30, "discardCount": 4,
+		"gameState": {"currentPlayerId": "p2", "gameStarted": true, "players": [
+			{"id": "p1", "name": "Alice",
+				"ships": [{"name": "Destroyer", "gunSize": 5, "hitPoints": 3}],
+				"hand": [{"gunSize": 5, "damage": 2}, {"gunSize": 14, "damage": 4}],
+				"playedShips": [{"name": "Cruiser", "gunSize": 8, "hitPoints": 5}]},
+			{"id": "p2", "name": "Bob",
+				"playedShips": [{"name": "Battleship", "gunSize": 14, "hitPoints": 9}, {"name": "Frigate", "gunSize": 3, "hitPoints": 2}],
+				"deepSixPile": [{"name": "Submarine", "gunSize": 3, "hitPoints": 1}]},
+			{"id": "p3", "name": "Carol", "eliminated": true,
+				"deepSixPile": [{"name": "Carrier", "gunSize": 5, "hitPoints": 10}]}
+		]}}}`,
+	`{"v": 1, "id": "4", "type": "chat", "payload": {"messages": [{"name": "Bob", "channel": "players", "signal": "goodLuck"}]}}`,
+}
+
+func TestRender(t *testing.T) {
+	v := &view{name: "Alice"}
+	for _, data := range renderMessages {
+		var msg serverMessage
+		if err := json.Unmarshal([]byte(data), &msg); err != nil {
+			t.Fatal(err)
+		}
+		v.handle(msg)
+	}
+	var got bytes.Buffer
+	v.render(&got)
+
+	golden := filepath.Join("testdata", "render.golden")
+	if *update {
+		if err := os.WriteFile(golden, got.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), want) {
+		t.Errorf("render differs from %s, rerun with -update if the change is intended:\n%s", golden, got.Bytes())
+	}
+}
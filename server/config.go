@@ -0,0 +1,377 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"game-server/engine"
+)
+
+// Config holds the server settings. Each setting comes from, in increasing
+// order of precedence, its default, the JSON config file, a SALVO_*
+// environment variable and a command line flag.
+type Config struct {
+	ListenAddr                string   `json:"listenAddr"`
+	IdleTimeout               Duration `json:"idleTimeout"`
+	LobbyIdleTimeout          Duration `json:"lobbyIdleTimeout"`
+	FinishedIdleTimeout       Duration `json:"finishedIdleTimeout"`
+	IdleWarning               Duration `json:"idleWarning"`
+	CorrespondenceIdleTimeout Duration `json:"correspondenceIdleTimeout"`
+	MatchStartTimeout         Duration `json:"matchStartTimeout"`
+	TurnDeadline              Duration `json:"turnDeadline"`
+	CleanupInterval           Duration `json:"cleanupInterval"`
+	PongTimeout               Duration `json:"pongTimeout"`
+	WriteTimeout              Duration `json:"writeTimeout"`
+	ShutdownTimeout           Duration `json:"shutdownTimeout"`
+	ShutdownGrace             Duration `json:"shutdownGrace"`
+	ReadBufferSize            int      `json:"readBufferSize"`
+	WriteBufferSize           int      `json:"writeBufferSize"`
+	SendQueueSize             int      `json:"sendQueueSize"`
+	MaxMessageSize            int64    `json:"maxMessageSize"`
+	AllowedOrigins            []string `json:"allowedOrigins"`
+	TLSCertFile               string   `json:"tlsCertFile"`
+	TLSKeyFile                string   `json:"tlsKeyFile"`
+	MaxSessions               int      `json:"maxSessions"`
+	MaxConnectionsPerIP       int      `json:"maxConnectionsPerIP"`
+	MessageRate               float64  `json:"messageRate"`
+	MessageBurst              int      `json:"messageBurst"`
+	MaxRateLimited            int      `json:"maxRateLimited"`
+	ChatMaxLength             int      `json:"chatMaxLength"`
+	ChatHistorySize           int      `json:"chatHistorySize"`
+	ChatRateLimit             int      `json:"chatRateLimit"`
+	ChatRateWindow            Duration `json:"chatRateWindow"`
+	RulesetPath               string   `json:"rulesetPath"`
+	LogLevel                  string   `json:"logLevel"`
+	AdminToken                string   `json:"adminToken"`
+	StoreDir                  string   `json:"storeDir"`
+	ServeFrontend             bool     `json:"serveFrontend"`
+	InstanceID                string   `json:"instanceId"`
+	ArchiveDir                string   `json:"archiveDir"`
+	WebhookURLs               []string `json:"webhookUrls"`
+	WebhookSecret             string   `json:"webhookSecret"`
+	WebhookAllowedHosts       []string `json:"webhookAllowedHosts"`
+	WebhookRetries            int      `json:"webhookRetries"`
+	WebhookBackoff            Duration `json:"webhookBackoff"`
+}
+
+// Duration is a time.Duration written as a string such as "5m" in the
+// config file.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"5m\"")
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		ListenAddr:                ":8080",
+		IdleTimeout:               Duration(5 * time.Minute),
+		LobbyIdleTimeout:          Duration(5 * time.Minute),
+		FinishedIdleTimeout:       Duration(30 * time.Minute),
+		IdleWarning:               Duration(1 * time.Minute),
+		CorrespondenceIdleTimeout: Duration(7 * 24 * time.Hour),
+		MatchStartTimeout:         Duration(1 * time.Hour),
+		TurnDeadline:              Duration(24 * time.Hour),
+		CleanupInterval:           Duration(1 * time.Minute),
+		PongTimeout:               Duration(60 * time.Second),
+		WriteTimeout:              Duration(10 * time.Second),
+		ShutdownTimeout:           Duration(5 * time.Second),
+		ShutdownGrace:             Duration(30 * time.Second),
+		ReadBufferSize:            1024,
+		WriteBufferSize:           1024,
+		SendQueueSize:             64,
+		MaxMessageSize:            8 * 1024,
+		MaxSessions:               1000,
+		MaxConnectionsPerIP:       20,
+		MessageRate:               10,
+		MessageBurst:              20,
+		MaxRateLimited:            50,
+		ChatMaxLength:             280,
+		ChatHistorySize:           100,
+		ChatRateLimit:             5,
+		ChatRateWindow:            Duration(10 * time.Second),
+		LogLevel:                  "info",
+		InstanceID:                defaultInstanceID(),
+		WebhookRetries:            5,
+		WebhookBackoff:            Duration(1 * time.Second),
+	}
+}
+
+// config and ruleset are loaded once at startup
+var (
+	config  = defaultConfig()
+	ruleset = engine.DefaultRuleset()
+)
+
+// loadConfig builds the configuration from the command line arguments, the
+// environment and the config file named by -config or SALVO_CONFIG.
+func loadConfig(args []string, getenv func(string) string) (*Config, error) {
+	cfg := defaultConfig()
+	fs := flag.NewFlagSet("game-server", flag.ContinueOnError)
+	configPath := fs.String("config", getenv("SALVO_CONFIG"), "path of a JSON config file")
+	fs.StringVar(&cfg.ListenAddr, "listen", cfg.ListenAddr, "address to listen on")
+	fs.DurationVar((*time.Duration)(&cfg.IdleTimeout), "idle-timeout", time.Duration(cfg.IdleTimeout), "remove games in progress without activity for this long")
+	fs.DurationVar((*time.Duration)(&cfg.LobbyIdleTimeout), "lobby-idle-timeout", time.Duration(cfg.LobbyIdleTimeout), "remove games waiting to be dealt without activity for this long")
+	fs.DurationVar((*time.Duration)(&cfg.FinishedIdleTimeout), "finished-idle-timeout", time.Duration(cfg.FinishedIdleTimeout), "remove or archive finished games without activity for this long")
+	fs.DurationVar((*time.Duration)(&cfg.IdleWarning), "idle-warning", time.Duration(cfg.IdleWarning), "warn clients this long before their session expires, 0 for no warning")
+	fs.DurationVar((*time.Duration)(&cfg.CorrespondenceIdleTimeout), "correspondence-idle-timeout", time.Duration(cfg.CorrespondenceIdleTimeout), "remove unfinished correspondence games without activity for this long")
+	fs.DurationVar((*time.Duration)(&cfg.MatchStartTimeout), "match-start-timeout", time.Duration(cfg.MatchStartTimeout), "forfeit tournament matches whose game is not dealt without activity for this long")
+	fs.DurationVar((*time.Duration)(&cfg.TurnDeadline), "turn-deadline", time.Duration(cfg.TurnDeadline), "time correspondence players have for their turn unless the game sets its own")
+	fs.DurationVar((*time.Duration)(&cfg.CleanupInterval), "cleanup-interval", time.Duration(cfg.CleanupInterval), "how often to look for idle sessions")
+	fs.DurationVar((*time.Duration)(&cfg.PongTimeout), "pong-timeout", time.Duration(cfg.PongTimeout), "drop connections that do not answer a ping within this time")
+	fs.DurationVar((*time.Duration)(&cfg.WriteTimeout), "write-timeout", time.Duration(cfg.WriteTimeout), "drop connections when a write takes longer than this")
+	fs.DurationVar((*time.Duration)(&cfg.ShutdownTimeout), "shutdown-timeout", time.Duration(cfg.ShutdownTimeout), "time allowed for a graceful shutdown")
+	fs.DurationVar((*time.Duration)(&cfg.ShutdownGrace), "shutdown-grace", time.Duration(cfg.ShutdownGrace), "time games in progress get to finish their turn before a shutdown saves them")
+	fs.IntVar(&cfg.ReadBufferSize, "read-buffer-size", cfg.ReadBufferSize, "websocket read buffer size in bytes")
+	fs.IntVar(&cfg.WriteBufferSize, "write-buffer-size", cfg.WriteBufferSize, "websocket write buffer size in bytes")
+	fs.IntVar(&cfg.SendQueueSize, "send-queue-size", cfg.SendQueueSize, "outbound messages queued per client before it is dropped as too slow")
+	fs.Int64Var(&cfg.MaxMessageSize, "max-message-size", cfg.MaxMessageSize, "largest client message in bytes")
+	fs.Var((*stringList)(&cfg.AllowedOrigins), "allowed-origins", "comma separated origins besides the server's own allowed to open websockets, or * for any")
+	fs.StringVar(&cfg.TLSCertFile, "tls-cert", cfg.TLSCertFile, "PEM certificate file; serves HTTPS when set with -tls-key")
+	fs.StringVar(&cfg.TLSKeyFile, "tls-key", cfg.TLSKeyFile, "PEM private key file for -tls-cert")
+	fs.IntVar(&cfg.MaxSessions, "max-sessions", cfg.MaxSessions, "maximum number of concurrent game sessions, 0 for no limit")
+	fs.IntVar(&cfg.MaxConnectionsPerIP, "max-connections-per-ip", cfg.MaxConnectionsPerIP, "maximum open websockets per client IP, 0 for no limit")
+	fs.Float64Var(&cfg.MessageRate, "message-rate", cfg.MessageRate, "messages per second each connection may send on average")
+	fs.IntVar(&cfg.MessageBurst, "message-burst", cfg.MessageBurst, "messages a connection may send in a burst above the message rate")
+	fs.IntVar(&cfg.MaxRateLimited, "max-rate-limited", cfg.MaxRateLimited, "messages over the rate a connection may send in a row before it is closed, 0 to never close it")
+	fs.IntVar(&cfg.ChatMaxLength, "chat-max-length", cfg.ChatMaxLength, "longest chat message in characters")
+	fs.IntVar(&cfg.ChatHistorySize, "chat-history-size", cfg.ChatHistorySize, "chat messages each session keeps and replays to joining clients")
+	fs.IntVar(&cfg.ChatRateLimit, "chat-rate-limit", cfg.ChatRateLimit, "chat messages a sender may post within the chat rate window")
+	fs.DurationVar((*time.Duration)(&cfg.ChatRateWindow), "chat-rate-window", time.Duration(cfg.ChatRateWindow), "window the chat rate limit counts messages over")
+	fs.StringVar(&cfg.RulesetPath, "ruleset", cfg.RulesetPath, "path of a JSON ruleset replacing the standard decks")
+	fs.StringVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "debug, info, warn or error")
+	fs.StringVar(&cfg.AdminToken, "admin-token", cfg.AdminToken, "bearer token for the /admin API, which is disabled when empty")
+	fs.StringVar(&cfg.StoreDir, "store-dir", cfg.StoreDir, "directory where sessions are saved on shutdown and restored from on startup")
+	fs.BoolVar(&cfg.ServeFrontend, "serve-frontend", cfg.ServeFrontend, "serve the embedded frontend build, with the API also under /api")
+	fs.StringVar(&cfg.InstanceID, "instance-id", cfg.InstanceID, "name of this instance, unique among the instances sharing games")
+	fs.StringVar(&cfg.ArchiveDir, "archive-dir", cfg.ArchiveDir, "directory where finished games are archived; without it they are kept in memory")
+	fs.Var((*stringList)(&cfg.WebhookURLs), "webhook-urls", "comma separated URLs every game's turn and game events are posted to")
+	fs.StringVar(&cfg.WebhookSecret, "webhook-secret", cfg.WebhookSecret, "secret the events posted to -webhook-urls are signed with")
+	fs.Var((*stringList)(&cfg.WebhookAllowedHosts), "webhook-allowed-hosts", "comma separated hosts games may send their own webhooks to, or * for any")
+	fs.IntVar(&cfg.WebhookRetries, "webhook-retries", cfg.WebhookRetries, "times a failed webhook delivery is retried")
+	fs.DurationVar((*time.Duration)(&cfg.WebhookBackoff), "webhook-backoff", time.Duration(cfg.WebhookBackoff), "wait before the first webhook retry, doubled for each one after")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// Flags were parsed into cfg to find -config; start over and apply the
+	// sources from lowest to highest precedence.
+	var explicit []*flag.Flag
+	fs.Visit(func(f *flag.Flag) { explicit = append(explicit, f) })
+	flagValues := make(map[string]string, len(explicit))
+	for _, f := range explicit {
+		flagValues[f.Name] = f.Value.String()
+	}
+	*cfg = *defaultConfig()
+
+	if *configPath != "" {
+		if err := readConfigFile(*configPath, cfg); err != nil {
+			return nil, err
+		}
+	}
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		env := "SALVO_" + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		if value := getenv(env); value != "" && f.Name != "config" && err == nil {
+			if setErr := fs.Set(f.Name, value); setErr != nil {
+				err = fmt.Errorf("%s: %w", env, setErr)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range flagValues {
+		if err := fs.Set(name, value); err != nil {
+			return nil, fmt.Errorf("-%s: %w", name, err)
+		}
+	}
+
+	return cfg, cfg.validate()
+}
+
+func readConfigFile(path string, cfg *Config) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(cfg); err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+func (c *Config) validate() error {
+	var errs []error
+	if c.ListenAddr == "" {
+		errs = append(errs, errors.New("listenAddr must not be empty"))
+	}
+	if c.InstanceID == "" {
+		errs = append(errs, errors.New("instanceId must not be empty"))
+	}
+	positive := []struct {
+		name  string
+		value int64
+	}{
+		{"idleTimeout", int64(c.IdleTimeout)},
+		{"lobbyIdleTimeout", int64(c.LobbyIdleTimeout)},
+		{"finishedIdleTimeout", int64(c.FinishedIdleTimeout)},
+		{"correspondenceIdleTimeout", int64(c.CorrespondenceIdleTimeout)},
+		{"matchStartTimeout", int64(c.MatchStartTimeout)},
+		{"turnDeadline", int64(c.TurnDeadline)},
+		{"cleanupInterval", int64(c.CleanupInterval)},
+		{"pongTimeout", int64(c.PongTimeout)},
+		{"writeTimeout", int64(c.WriteTimeout)},
+		{"shutdownTimeout", int64(c.ShutdownTimeout)},
+		{"readBufferSize", int64(c.ReadBufferSize)},
+		{"writeBufferSize", int64(c.WriteBufferSize)},
+		{"sendQueueSize", int64(c.SendQueueSize)},
+		{"maxMessageSize", c.MaxMessageSize},
+		{"chatMaxLength", int64(c.ChatMaxLength)},
+		{"chatHistorySize", int64(c.ChatHistorySize)},
+		{"chatRateLimit", int64(c.ChatRateLimit)},
+		{"chatRateWindow", int64(c.ChatRateWindow)},
+	}
+	for _, setting := range positive {
+		if setting.value <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive", setting.name))
+		}
+	}
+	if c.ShutdownGrace < 0 {
+		errs = append(errs, errors.New("shutdownGrace must not be negative"))
+	}
+	if c.IdleWarning < 0 {
+		errs = append(errs, errors.New("idleWarning must not be negative"))
+	}
+	if c.TurnDeadline > c.CorrespondenceIdleTimeout {
+		errs = append(errs, errors.New("turnDeadline must not be longer than correspondenceIdleTimeout"))
+	}
+	if c.ArchiveDir != "" && c.StoreDir != "" && filepath.Clean(c.ArchiveDir) == filepath.Clean(c.StoreDir) {
+		errs = append(errs, errors.New("archiveDir and storeDir must be different directories"))
+	}
+	if c.MaxSessions < 0 || c.MaxConnectionsPerIP < 0 || c.MaxRateLimited < 0 {
+		errs = append(errs, errors.New("maxSessions, maxConnectionsPerIP and maxRateLimited must not be negative"))
+	}
+	if c.MessageRate <= 0 || c.MessageBurst < 1 {
+		errs = append(errs, errors.New("messageRate and messageBurst must be positive"))
+	}
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" || strings.Contains(strings.TrimPrefix(u.Host, "*."), "*") {
+			errs = append(errs, fmt.Errorf("allowed origin %q must be * or a scheme and host such as https://example.com or https://*.example.com", origin))
+		}
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		errs = append(errs, errors.New("tlsCertFile and tlsKeyFile must be set together"))
+	}
+	switch c.LogLevel {
+	case "debug", "info", "warn", "error":
+	default:
+		errs = append(errs, fmt.Errorf("logLevel %q must be debug, info, warn or error", c.LogLevel))
+	}
+	if c.AdminToken != "" && len(c.AdminToken) < 16 {
+		errs = append(errs, errors.New("adminToken must be at least 16 characters"))
+	}
+	for _, webhook := range c.WebhookURLs {
+		if u, err := url.Parse(webhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("webhook URL %q must be an http or https URL", redactURL(webhook)))
+		}
+	}
+	if len(c.WebhookURLs) > 0 && len(c.WebhookSecret) < 16 {
+		errs = append(errs, errors.New("webhookSecret must be at least 16 characters when webhookUrls are set"))
+	}
+	if c.WebhookRetries < 0 || c.WebhookBackoff <= 0 {
+		errs = append(errs, errors.New("webhookRetries must not be negative and webhookBackoff must be positive"))
+	}
+	return errors.Join(errs...)
+}
+
+// loadRuleset reads the ruleset at path, or returns the standard rules when
+// path is empty.
+func loadRuleset(path string) (engine.Ruleset, error) {
+	if path == "" {
+		return engine.DefaultRuleset(), nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return engine.Ruleset{}, err
+	}
+	var rules engine.Ruleset
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return engine.Ruleset{}, fmt.Errorf("ruleset %s: %w", path, err)
+	}
+	if err := rules.Validate(4); err != nil {
+		return engine.Ruleset{}, fmt.Errorf("ruleset %s: %w", path, err)
+	}
+	return rules, nil
+}
+
+// String returns the configuration as indented JSON, without secrets
+func (c *Config) String() string {
+	data, _ := json.MarshalIndent(c.redacted(), "", "  ")
+	return string(data)
+}
+
+// LogValue logs the configuration without secrets
+func (c *Config) LogValue() slog.Value {
+	return slog.AnyValue(c.redacted())
+}
+
+func (c *Config) redacted() Config {
+	redacted := *c
+	if redacted.AdminToken != "" {
+		redacted.AdminToken = "[redacted]"
+	}
+	if redacted.WebhookSecret != "" {
+		redacted.WebhookSecret = "[redacted]"
+	}
+	redacted.WebhookURLs = make([]string, len(c.WebhookURLs))
+	for i, webhook := range c.WebhookURLs {
+		redacted.WebhookURLs[i] = redactURL(webhook)
+	}
+	return redacted
+}
+
+// stringList is a comma separated flag value. Setting it replaces the list.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = nil
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "salvo.json")
+	file := `{"idleTimeout": "1m", "maxSessions": 5, "logLevel": "warn", "allowedOrigins": ["https://file.example.com"]}`
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{
+		"SALVO_CONFIG":          path,
+		"SALVO_IDLE_TIMEOUT":    "2m",
+		"SALVO_MAX_SESSIONS":    "6",
+		"SALVO_ALLOWED_ORIGINS": "https://env.example.com",
+	}
+
+	cfg, err := loadConfig([]string{"-idle-timeout", "3m"}, func(name string) string { return env[name] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ListenAddr != ":8080" {
+		t.Errorf("listenAddr %q, want the default", cfg.ListenAddr)
+	}
+	if cfg.LogLevel != "warn" {
+		t.Errorf("logLevel %q, want the file's warn", cfg.LogLevel)
+	}
+	if cfg.MaxSessions != 6 || len(cfg.AllowedOrigins) != 1 || cfg.AllowedOrigins[0] != "https://env.example.com" {
+		t.Errorf("maxSessions %d and allowedOrigins %v, want the environment's", cfg.MaxSessions, cfg.AllowedOrigins)
+	}
+	if time.Duration(cfg.IdleTimeout) != 3*time.Minute {
+		t.Errorf("idleTimeout %v, want the flag's 3m", time.Duration(cfg.IdleTimeout))
+	}
+}
+
+func TestConfigRejectsBadSources(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "salvo.json")
+	if err := os.WriteFile(path, []byte(`{"idleTimeot": "1m"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	noEnv := func(string) string { return "" }
+	if _, err := loadConfig([]string{"-config", path}, noEnv); err == nil {
+		t.Error("a misspelt setting in the config file was accepted")
+	}
+	if _, err := loadConfig(nil, func(name string) string {
+		if name == "SALVO_MAX_SESSIONS" {
+			return "many"
+		}
+		return ""
+	}); err == nil || !strings.Contains(err.Error(), "SALVO_MAX_SESSIONS") {
+		t.Errorf("a malformed environment variable: %v, want an error naming it", err)
+	}
+	if _, err := loadConfig([]string{"-log-level", "loud"}, noEnv); err == nil {
+		t.Error("an invalid flag value passed validation")
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	if err := defaultConfig().validate(); err != nil {
+		t.Fatalf("the default config is invalid: %v", err)
+	}
+	for _, test := range []struct {
+		name   string
+		change func(c *Config)
+		want   string
+	}{
+		{"empty listen address", func(c *Config) { c.ListenAddr = "" }, "listenAddr"},
+		{"zero timeout", func(c *Config) { c.IdleTimeout = 0 }, "idleTimeout must be positive"},
+		{"negative grace", func(c *Config) { c.ShutdownGrace = -1 }, "shutdownGrace"},
+		{"no match start window", func(c *Config) { c.MatchStartTimeout = 0 }, "matchStartTimeout"},
+		{"no chat rate window", func(c *Config) { c.ChatRateWindow = 0 }, "chatRateWindow"},
+		{"deadline past expiry", func(c *Config) { c.TurnDeadline = c.CorrespondenceIdleTimeout + 1 }, "turnDeadline"},
+		{"archive in the store", func(c *Config) { c.ArchiveDir, c.StoreDir = "data/", "data" }, "different directories"},
+		{"no message rate", func(c *Config) { c.MessageRate = 0 }, "messageRate"},
+		{"origin with a path", func(c *Config) { c.AllowedOrigins = []string{"https://example.com/app"} }, "allowed origin"},
+		{"origin with an inner wildcard", func(c *Config) { c.AllowedOrigins = []string{"https://a.*.example.com"} }, "allowed origin"},
+		{"certificate without key", func(c *Config) { c.TLSCertFile = "cert.pem" }, "tlsCertFile"},
+		{"unknown log level", func(c *Config) { c.LogLevel = "loud" }, "logLevel"},
+		{"short admin token", func(c *Config) { c.AdminToken = "secret" }, "adminToken"},
+		{"webhook without a secret", func(c *Config) { c.WebhookURLs = []string{"https://hooks.example.com"} }, "webhookSecret"},
+		{"webhook to a file", func(c *Config) {
+			c.WebhookURLs, c.WebhookSecret = []string{"file:///etc/passwd"}, "0123456789abcdef"
+		}, "webhook URL"},
+	} {
+		cfg := defaultConfig()
+		test.change(cfg)
+		if err := cfg.validate(); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: %v, want an error about %s", test.name, err, test.want)
+		}
+	}
+
+	cfg := defaultConfig()
+	cfg.AllowedOrigins = []string{"*", "https://example.com", "https://*.example.com"}
+	if err := cfg.validate(); err != nil {
+		t.Errorf("valid origins rejected: %v", err)
+	}
+}
+
+func TestOriginMatches(t *testing.T) {
+	for _, test := range []struct {
+		pattern, origin string
+		want            bool
+	}{
+		{"*", "https://anywhere.example", true},
+		{"https://example.com", "https://example.com", true},
+		{"https://example.com", "HTTPS://EXAMPLE.COM", true},
+		{"https://example.com", "http://example.com", false},
+		{"https://example.com", "https://example.com.evil.test", false},
+		{"https://*.example.com", "https://app.example.com", true},
+		{"https://*.example.com", "https://a.b.example.com", true},
+		{"https://*.example.com", "https://example.com", false},
+		{"https://*.example.com", "https://evilexample.com", false},
+		{"https://*.example.com", "http://app.example.com", false},
+	} {
+		if got := originMatches(test.pattern, test.origin); got != test.want {
+			t.Errorf("originMatches(%q, %q) = %v, want %v", test.pattern, test.origin, got, test.want)
+		}
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	oldConfig := config
+	t.Cleanup(func() { config = oldConfig })
+	config = defaultConfig()
+
+	request := func(origin string) bool {
+		r := httptest.NewRequest("GET", "http://salvo.example.com/ws", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		return checkOrigin(r)
+	}
+	if !request("") || !request("http://salvo.example.com") {
+		t.Error("a request without an origin or from the server's own was refused")
+	}
+	if request("https://other.example.com") {
+		t.Error("another origin was accepted by default")
+	}
+	config.AllowedOrigins = []string{"https://*.example.com"}
+	if !request("https://other.example.com") {
+		t.Error("an allowed origin was refused")
+	}
+	config.AllowedOrigins = []string{"*"}
+	if !request("https://anywhere.test") {
+		t.Error("* did not allow any origin")
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"game-server/internal/harness"
+	"github.com/gorilla/websocket"
+)
+
+func TestConnectionDroppedWithoutPong(t *testing.T) {
+	srv := startServer(t)
+	config.PongTimeout = Duration(200 * time.Millisecond)
+
+	// A peer only answers pings while it reads, so this one never does
+	silent, _, err := websocket.DefaultDialer.Dial(srv.WebSocketURL(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	hello := map[string]any{"v": ProtocolVersion, "id": "1", "type": "hello", "payload": map[string]any{"protocolVersion": ProtocolVersion}}
+	if err := silent.WriteJSON(hello); err != nil {
+		t.Fatal(err)
+	}
+	alice := srv.Connect("Alice")
+
+	time.Sleep(500 * time.Millisecond)
+	silent.SetReadDeadline(time.Now().Add(harness.DefaultTimeout))
+	for {
+		if _, _, err := silent.ReadMessage(); err != nil {
+			if netErr, ok := err.(interface{ Timeout() bool }); ok && netErr.Timeout() {
+				t.Fatal("the silent connection is still open after the pong timeout")
+			}
+			break
+		}
+	}
+
+	// Alice answered every ping and is still connected
+	alice.Create(2)
+	t.Cleanup(func() {
+		if session, ok := manager.find(alice.SessionID); ok {
+			manager.remove(session)
+		}
+	})
+}
+
+func TestOversizedFrameClosesConnection(t *testing.T) {
+	srv := startServer(t)
+	config.MaxMessageSize = 1024
+	alice := srv.Connect("Alice")
+
+	alice.SendRaw([]byte(`{"v": 1, "id": "1", "type": "chat", "payload": {"text": "` + strings.Repeat("a", 2048) + `"}}`))
+	if code := alice.ExpectClosed(); code != websocket.CloseMessageTooBig {
+		t.Errorf("closed with %d, want %d", code, websocket.CloseMessageTooBig)
+	}
+}
+
+func TestSlowClientIsDisconnected(t *testing.T) {
+	oldConfig := config
+	cfg := *config
+	cfg.SendQueueSize = 2
+	config = &cfg
+	t.Cleanup(func() { config = oldConfig })
+
+	// Nothing drains the queue, as with a client that stopped reading
+	client := newClient()
+	for i := range 2 {
+		if !client.enqueue(newServerMessage("ack", AckPayload{Type: "chat"})) {
+			t.Fatalf("message %d was not queued", i+1)
+		}
+	}
+	if client.enqueue(newServerMessage("ack", AckPayload{Type: "chat"})) {
+		t.Fatal("a message was queued past the queue size")
+	}
+	select {
+	case <-client.done:
+	default:
+		t.Fatal("the slow client was not closed")
+	}
+	if client.closeCode != websocket.CloseTryAgainLater {
+		t.Errorf("closed with %d, want %d", client.closeCode, websocket.CloseTryAgainLater)
+	}
+	if client.enqueue(newServerMessage("ack", AckPayload{Type: "chat"})) {
+		t.Error("a message was queued for a closed client")
+	}
+}
+
+func TestDisconnectIsBroadcast(t *testing.T) {
+	srv := startServer(t)
+	alice, bob := seatTwoPlayers(t, srv)
+	carol := srv.Connect("Carol")
+	carol.Spectate(alice.RoomCode)
+
+	bob.Close()
+	for _, client := range []*harness.Client{alice, carol} {
+		var presence PresencePayload
+		client.Decode(client.Expect("playerDisconnected"), &presence)
+		if presence.PlayerID != bob.PlayerID || presence.Name != "Bob" {
+			t.Errorf("%s was told %+v disconnected, want Bob", client.Name, presence)
+		}
+	}
+
+	// A spectator leaving is not announced
+	carol.Close()
+	alice.ExpectNone("playerDisconnected", 100*time.Millisecond)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"game-server/engine"
+)
+
+// Correspondence games are played over days. Each player has a turn
+// deadline measured in hours; a player who lets it pass loses the turn to
+// the next player. The game waits correspondenceIdleTimeout for activity
+// before it is removed, so players can come and go, and take their turn
+// over whichever connection they have at the time: a rejoinGame on a new
+// websocket, the HTTP API or the event stream.
+
+// PendingTurn is a correspondence game waiting for a player's move
+type PendingTurn struct {
+	SessionID   string    `json:"sessionId"`
+	RoomCode    string    `json:"roomCode"`
+	PlayerID    string    `json:"playerId"`
+	TurnStarted time.Time `json:"turnStarted"`
+	Deadline    time.Time `json:"deadline"`
+}
+
+// correspondenceTurnDeadline returns the turn deadline requested by a
+// createGame message.
+func correspondenceTurnDeadline(createMsg CreateGamePayload) (time.Duration, error) {
+	if !createMsg.Correspondence {
+		return 0, newProtocolError(CodeBadRequest, "turnHours is only for correspondence games")
+	}
+	if createMsg.TurnHours == 0 {
+		return time.Duration(config.TurnDeadline), nil
+	}
+	maxHours := int(time.Duration(config.CorrespondenceIdleTimeout) / time.Hour)
+	if createMsg.TurnHours < 1 || createMsg.TurnHours > maxHours {
+		return 0, newProtocolError(CodeBadRequest, "turnHours must be between 1 and %d", maxHours)
+	}
+	return time.Duration(createMsg.TurnHours) * time.Hour, nil
+}
+
+// currentTurnDeadline returns when the current player's turn passes, or nil
+// unless a correspondence game is in progress.
+func (session *GameSession) currentTurnDeadline() *time.Time {
+	if session.turnDeadline == 0 || session.lifecycleState() != SessionInProgress {
+		return nil
+	}
+	deadline := session.turnStarted.Add(session.turnDeadline)
+	return &deadline
+}
+
+// expireTurns passes the turn of every correspondence player whose
+// deadline has gone by. A missed turn is not activity, so a game nobody
+// plays still expires.
+func expireTurns(now time.Time) {
+	for _, session := range manager.list() {
+		session.call(func() error {
+			deadline := session.currentTurnDeadline()
+			if deadline == nil || now.Before(*deadline) {
+				return nil
+			}
+			playerID := session.GameState.CurrentPlayerId
+			if err := applyAction(session, engine.SkipTurn{PlayerID: playerID}); err != nil {
+				session.log.Error("Passing a missed turn failed", "player", playerID, "error", err)
+				return nil
+			}
+			session.log.Info("Turn deadline missed, turn passed", "player", playerID, "deadline", *deadline)
+			session.broadcast(newServerMessage("turnMissed", TurnMissedPayload{
+				SessionID: session.ID,
+				PlayerID:  playerID,
+				Deadline:  *deadline,
+			}))
+			session.broadcastGameState()
+			return nil
+		})
+	}
+}
+
+// playerKeyRegistry remembers the player keys issued to correspondence
+// players. A key belongs to the name it was issued to, and lists that
+// player's pending turns in every game they joined with it.
+type playerKeyRegistry struct {
+	mu    sync.Mutex
+	names map[string]string // key -> player name
+}
+
+var playerKeys = &playerKeyRegistry{
+	names: make(map[string]string),
+}
+
+// issue returns key when it was issued to name, and a new key for name
+// otherwise.
+func (r *playerKeyRegistry) issue(name, key string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if key != "" && r.names[key] == name {
+		return key
+	}
+	key = newToken()
+	r.names[key] = name
+	return key
+}
+
+// remember records a key restored with a session
+func (r *playerKeyRegistry) remember(name, key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.names[key] = name
+}
+
+// owns reports whether key was issued to name
+func (r *playerKeyRegistry) owns(name, key string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	owner, ok := r.names[key]
+	return ok && owner == name
+}
+
+// issuePlayerKey gives a player seated in a correspondence game their
+// player key, the one they sent if it is theirs. It runs on the session
+// goroutine.
+func (session *GameSession) issuePlayerKey(player Player, key string) {
+	if session.turnDeadline > 0 {
+		session.playerKeys[player.ID] = playerKeys.issue(player.Name, key)
+	}
+}
+
+// handlePendingTurns lists the correspondence games on this instance in
+// which the named player is on turn, most urgent first. The request carries
+// the player key the player was given when they joined a correspondence
+// game as a bearer token, and lists the games they joined with that key.
+// It reveals no more than the spectators' view of each game.
+func handlePendingTurns(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	key, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !playerKeys.owns(name, key) {
+		writeAPIError(w, newProtocolError(CodeForbidden, "the player key of %s is required", name))
+		return
+	}
+	turns := []PendingTurn{}
+	for _, session := range manager.list() {
+		session.call(func() error {
+			deadline := session.currentTurnDeadline()
+			if deadline == nil {
+				return nil
+			}
+			player := session.GameState.Player(session.GameState.CurrentPlayerId)
+			if player == nil || player.Name != name || subtle.ConstantTimeCompare([]byte(session.playerKeys[player.ID]), []byte(key)) != 1 {
+				return nil
+			}
+			turns = append(turns, PendingTurn{
+				SessionID:   session.ID,
+				RoomCode:    session.RoomCode,
+				PlayerID:    player.ID,
+				TurnStarted: session.turnStarted,
+				Deadline:    *deadline,
+			})
+			return nil
+		})
+	}
+	slices.SortFunc(turns, func(a, b PendingTurn) int { return a.Deadline.Compare(b.Deadline) })
+	writeJSON(w, http.StatusOK, map[string][]PendingTurn{"turns": turns})
+}
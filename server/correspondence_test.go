@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// startCorrespondenceGame deals a two player correspondence game between
+// Alice and Bob. They join with the player keys in keys, which is updated
+// with the keys they are given, or with none when keys is nil.
+func startCorrespondenceGame(t *testing.T, turnHours int, keys map[string]string) *GameSession {
+	t.Helper()
+	msg := clientMessage(t, "createGame", CreateGamePayload{NumberOfPlayers: 2, PlayerName: "Alice", Correspondence: true, TurnHours: turnHours})
+	session, err := findOrCreateSession(msg)
+	if err != nil {
+		t.Fatalf("createGame: %v", err)
+	}
+	t.Cleanup(func() { manager.remove(session) })
+	err = session.call(func() error {
+		for _, name := range []string{"Alice", "Bob"} {
+			player, err := session.seat(name, "")
+			if err != nil {
+				return err
+			}
+			session.issuePlayerKey(player, keys[name])
+			if keys != nil {
+				keys[name] = session.playerKeys[player.ID]
+			}
+		}
+		return handleMessage(session, "", clientMessage(t, "startGame", nil))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return session
+}
+
+// pendingTurns asks for the named player's pending turns with key and
+// returns the turns, or fails the test unless the answer has status.
+func pendingTurns(t *testing.T, name, key string, status int) []PendingTurn {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/players/"+name+"/turns", nil)
+	r.SetPathValue("name", name)
+	if key != "" {
+		r.Header.Set("Authorization", "Bearer "+key)
+	}
+	w := httptest.NewRecorder()
+	handlePendingTurns(w, r)
+	if w.Code != status {
+		t.Fatalf("GET /players/%s/turns answered %d %s, want %d", name, w.Code, w.Body, status)
+	}
+	if status != http.StatusOK {
+		return nil
+	}
+	var body map[string][]PendingTurn
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	return body["turns"]
+}
+
+func TestCorrespondenceTurnDeadline(t *testing.T) {
+	useLifecycleConfig(t)
+	for _, payload := range []CreateGamePayload{
+		{NumberOfPlayers: 2, TurnHours: 12},
+		{NumberOfPlayers: 2, Correspondence: true, TurnHours: -1},
+		{NumberOfPlayers: 2, Correspondence: true, TurnHours: 24*7 + 1},
+	} {
+		if _, err := findOrCreateSession(clientMessage(t, "createGame", payload)); asProtocolError(err).Code != CodeBadRequest {
+			t.Errorf("createGame %+v: err = %v, want bad_request", payload, err)
+		}
+	}
+
+	session := startCorrespondenceGame(t, 0, nil)
+	session.call(func() error {
+		if session.turnDeadline != 24*time.Hour {
+			t.Errorf("default turn deadline %v, want the server's 24h", session.turnDeadline)
+		}
+		if timeout := session.idleTimeout(); timeout != 7*24*time.Hour {
+			t.Errorf("idle timeout %v, want the correspondence idle timeout", timeout)
+		}
+		return nil
+	})
+}
+
+func TestMissedTurnPassesToNextPlayer(t *testing.T) {
+	useLifecycleConfig(t)
+	session := startCorrespondenceGame(t, 6, nil)
+	client := watchSession(session, time.Now())
+	var first string
+	var deadline time.Time
+	session.call(func() error {
+		first = session.GameState.CurrentPlayerId
+		deadline = *createStatePayload(session, "").TurnDeadline
+		return nil
+	})
+	if time.Until(deadline) < 5*time.Hour || time.Until(deadline) > 6*time.Hour {
+		t.Fatalf("turn deadline %v, want in 6 hours", deadline)
+	}
+
+	expireTurns(deadline.Add(-time.Minute))
+	if n := received(client, "turnMissed"); n != 0 {
+		t.Fatalf("turn passed before the deadline")
+	}
+	expireTurns(deadline.Add(time.Minute))
+	if n := received(client, "turnMissed"); n != 1 {
+		t.Fatalf("sent %d turnMissed messages after the deadline, want 1", n)
+	}
+	session.call(func() error {
+		if session.GameState.CurrentPlayerId == first {
+			t.Error("the turn did not pass after the deadline")
+		}
+		if !session.lastActivity.Before(deadline) {
+			t.Error("a missed turn counted as activity")
+		}
+		return nil
+	})
+}
+
+func TestPendingTurnsListsPlayersGames(t *testing.T) {
+	useLifecycleConfig(t)
+	keys := make(map[string]string)
+	later := startCorrespondenceGame(t, 48, keys)
+	aliceKey := keys["Alice"]
+	sooner := startCorrespondenceGame(t, 2, keys)
+	if keys["Alice"] != aliceKey || aliceKey == "" || keys["Bob"] == aliceKey {
+		t.Fatalf("player keys %v after a second game, want Alice to keep %q", keys, aliceKey)
+	}
+	// A game Alice joins without the key is not listed under it
+	other := startCorrespondenceGame(t, 1, nil)
+	live := createNewSession(2)
+	defer manager.remove(live)
+
+	// Put Alice on turn in every correspondence game
+	for _, session := range []*GameSession{later, sooner, other} {
+		session.call(func() error {
+			session.GameState.CurrentPlayerId = session.GameState.Players[0].ID
+			return nil
+		})
+	}
+	turns := pendingTurns(t, "Alice", aliceKey, http.StatusOK)
+	if len(turns) != 2 || turns[0].SessionID != sooner.ID || turns[1].SessionID != later.ID {
+		t.Fatalf("pending turns %+v, want %s then %s", turns, sooner.ID, later.ID)
+	}
+	if turns[0].RoomCode != sooner.RoomCode || turns[0].PlayerID == "" || !turns[0].Deadline.Equal(turns[0].TurnStarted.Add(2*time.Hour)) {
+		t.Errorf("pending turn %+v, want the room code, seat and a 2h deadline", turns[0])
+	}
+	if turns := pendingTurns(t, "Bob", keys["Bob"], http.StatusOK); len(turns) != 0 {
+		t.Errorf("Bob has pending turns %+v while waiting for Alice", turns)
+	}
+
+	// Nobody lists Alice's games without Alice's key
+	pendingTurns(t, "Alice", keys["Bob"], http.StatusForbidden)
+	pendingTurns(t, "Alice", "", http.StatusForbidden)
+
+	// A key sent with another name is not reused
+	var joined JoinedPayload
+	session := createNewSession(2)
+	defer manager.remove(session)
+	session.call(func() error {
+		session.turnDeadline = time.Hour
+		player, err := session.seat("Mallory", "")
+		if err == nil {
+			session.issuePlayerKey(player, aliceKey)
+			joined.PlayerKey = session.playerKeys[player.ID]
+		}
+		return err
+	})
+	if joined.PlayerKey == "" || joined.PlayerKey == aliceKey {
+		t.Errorf("Mallory was given key %q, want a new key rather than Alice's", joined.PlayerKey)
+	}
+}
+
+func TestCorrespondencePlayersKeepTheirPlayerKey(t *testing.T) {
+	handler := useAPI(t)
+	create := func(body map[string]any) JoinedPayload {
+		var joined JoinedPayload
+		if status := apiCall(t, handler, "POST", "/games", "", body, &joined); status != http.StatusCreated {
+			t.Fatalf("creating %v answered %d", body, status)
+		}
+		t.Cleanup(func() {
+			if session, ok := manager.find(joined.SessionID); ok {
+				manager.remove(session)
+			}
+		})
+		return joined
+	}
+
+	if live := create(map[string]any{"numberOfPlayers": 2, "playerName": "Alice"}); live.PlayerKey != "" {
+		t.Errorf("a live game gave Alice player key %q", live.PlayerKey)
+	}
+	first := create(map[string]any{"numberOfPlayers": 2, "playerName": "Alice", "correspondence": true})
+	if first.PlayerKey == "" {
+		t.Fatal("a correspondence game gave Alice no player key")
+	}
+	second := create(map[string]any{"numberOfPlayers": 2, "playerName": "Alice", "correspondence": true, "playerKey": first.PlayerKey})
+	if second.PlayerKey != first.PlayerKey {
+		t.Errorf("Alice's second game gave key %q, want the key Alice sent", second.PlayerKey)
+	}
+	if fresh := create(map[string]any{"numberOfPlayers": 2, "playerName": "Alice", "correspondence": true, "playerKey": "made-up"}); fresh.PlayerKey == "made-up" {
+		t.Error("a key the server never issued was accepted")
+	}
+}
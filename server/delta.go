@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// FeatureDelta is negotiated in the hello message by clients that want
+// sequence-numbered patches instead of a full state after every action.
+const FeatureDelta = "delta"
+
+// snapshotInterval is the number of patches sent between full snapshots
+const snapshotInterval = 25
+
+type SnapshotPayload struct {
+	Seq   uint64       `json:"seq"`
+	State StatePayload `json:"state"`
+}
+
+type PatchPayload struct {
+	Seq uint64    `json:"seq"`
+	Ops []PatchOp `json:"ops"`
+}
+
+// PatchOp is a JSON Patch (RFC 6902) operation
+type PatchOp struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value any    `json:"value,omitempty"`
+}
+
+// MarshalJSON keeps the value of add and replace operations even when it is
+// null, which omitempty would drop.
+func (op PatchOp) MarshalJSON() ([]byte, error) {
+	if op.Op == "remove" {
+		return json.Marshal(struct {
+			Op   string `json:"op"`
+			Path string `json:"path"`
+		}{op.Op, op.Path})
+	}
+	return json.Marshal(struct {
+		Op    string `json:"op"`
+		Path  string `json:"path"`
+		Value any    `json:"value"`
+	}{op.Op, op.Path, op.Value})
+}
+
+// deltaStream tracks the last view sent to one client so later views can be
+// sent as patches against it. Each message gets the next sequence number; a
+// client that sees a gap sends resync to get a fresh snapshot.
+type deltaStream struct {
+	seq           uint64
+	last          any
+	sinceSnapshot int
+}
+
+// next returns the message bringing the client from its last view to state,
+// or false when nothing changed. A view that cannot be compared is sent as a
+// full state, and the stream starts again from a snapshot.
+func (d *deltaStream) next(state StatePayload) (ServerMessage, bool) {
+	view, err := toJSONValue(state)
+	if err != nil {
+		slog.Error("Encoding state for a delta failed, sending the full state", "error", err)
+		d.resync()
+		return newServerMessage("state", state), true
+	}
+
+	if d.last == nil || d.sinceSnapshot >= snapshotInterval {
+		d.seq++
+		d.last = view
+		d.sinceSnapshot = 0
+		return newServerMessage("snapshot", SnapshotPayload{Seq: d.seq, State: state}), true
+	}
+
+	ops := diffJSON("", d.last, view, nil)
+	if len(ops) == 0 {
+		return ServerMessage{}, false
+	}
+	d.seq++
+	d.last = view
+	d.sinceSnapshot++
+	return newServerMessage("patch", PatchPayload{Seq: d.seq, Ops: ops}), true
+}
+
+// resync forces the next message to be a full snapshot
+func (d *deltaStream) resync() {
+	d.last = nil
+}
+
+// toJSONValue converts v to the generic form produced by json.Unmarshal so
+// it can be compared field by field.
+func toJSONValue(v any) (any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var value any
+	err = json.Unmarshal(data, &value)
+	return value, err
+}
+
+// diffJSON appends the operations turning from into to. Both must be
+// generic JSON values.
+func diffJSON(path string, from, to any, ops []PatchOp) []PatchOp {
+	switch fromValue := from.(type) {
+	case map[string]any:
+		toValue, ok := to.(map[string]any)
+		if !ok {
+			break
+		}
+		for _, key := range sortedKeys(fromValue) {
+			if _, exists := toValue[key]; !exists {
+				ops = append(ops, PatchOp{Op: "remove", Path: path + "/" + escapePointer(key)})
+			}
+		}
+		for _, key := range sortedKeys(toValue) {
+			child := path + "/" + escapePointer(key)
+			if old, exists := fromValue[key]; exists {
+				ops = diffJSON(child, old, toValue[key], ops)
+			} else {
+				ops = append(ops, PatchOp{Op: "add", Path: child, Value: toValue[key]})
+			}
+		}
+		return ops
+	case []any:
+		toValue, ok := to.([]any)
+		if !ok {
+			break
+		}
+		common := min(len(fromValue), len(toValue))
+		for i := 0; i < common; i++ {
+			ops = diffJSON(path+"/"+strconv.Itoa(i), fromValue[i], toValue[i], ops)
+		}
+		// Remove from the end so earlier indexes stay valid
+		for i := len(fromValue) - 1; i >= common; i-- {
+			ops = append(ops, PatchOp{Op: "remove", Path: path + "/" + strconv.Itoa(i)})
+		}
+		for i := common; i < len(toValue); i++ {
+			ops = append(ops, PatchOp{Op: "add", Path: path + "/" + strconv.Itoa(i), Value: toValue[i]})
+		}
+		return ops
+	}
+
+	if !reflect.DeepEqual(from, to) {
+		ops = append(ops, PatchOp{Op: "replace", Path: path, Value: to})
+	}
+	return ops
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func escapePointer(key string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// jsonValue parses data as a generic JSON value
+func jsonValue(t *testing.T, data string) any {
+	t.Helper()
+	var value any
+	if err := json.Unmarshal([]byte(data), &value); err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+func TestDiffJSON(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		from, to string
+		want     []PatchOp
+	}{
+		{"unchanged", `{"a": [1, {"b": 2}]}`, `{"a": [1, {"b": 2}]}`, nil},
+		{"replace a field", `{"a": 1, "b": "x"}`, `{"a": 2, "b": "x"}`, []PatchOp{{Op: "replace", Path: "/a", Value: 2.0}}},
+		{"add and remove fields", `{"a": 1, "b": 2}`, `{"b": 2, "c": null}`, []PatchOp{
+			{Op: "remove", Path: "/a"},
+			{Op: "add", Path: "/c", Value: nil},
+		}},
+		{"nested object", `{"p": {"q": {"r": 1}}}`, `{"p": {"q": {"r": 2}}}`, []PatchOp{{Op: "replace", Path: "/p/q/r", Value: 2.0}}},
+		{"escaped keys", `{"a/b": 1, "c~d": 1}`, `{"a/b": 2, "c~d": 2}`, []PatchOp{
+			{Op: "replace", Path: "/a~1b", Value: 2.0},
+			{Op: "replace", Path: "/c~0d", Value: 2.0},
+		}},
+		{"change an element", `{"a": [1, 2, 3]}`, `{"a": [1, 5, 3]}`, []PatchOp{{Op: "replace", Path: "/a/1", Value: 5.0}}},
+		{"append elements", `{"a": [1]}`, `{"a": [1, 2, 3]}`, []PatchOp{
+			{Op: "add", Path: "/a/1", Value: 2.0},
+			{Op: "add", Path: "/a/2", Value: 3.0},
+		}},
+		{"remove elements from the end", `{"a": [1, 2, 3]}`, `{"a": [1]}`, []PatchOp{
+			{Op: "remove", Path: "/a/2"},
+			{Op: "remove", Path: "/a/1"},
+		}},
+		{"change type", `{"a": [1]}`, `{"a": {"b": 1}}`, []PatchOp{{Op: "replace", Path: "/a", Value: map[string]any{"b": 1.0}}}},
+	} {
+		got := diffJSON("", jsonValue(t, test.from), jsonValue(t, test.to), nil)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestPatchOpKeepsNullValues(t *testing.T) {
+	for _, test := range []struct {
+		op   PatchOp
+		want string
+	}{
+		{PatchOp{Op: "add", Path: "/a", Value: nil}, `{"op":"add","path":"/a","value":null}`},
+		{PatchOp{Op: "remove", Path: "/a"}, `{"op":"remove","path":"/a"}`},
+	} {
+		data, err := json.Marshal(test.op)
+		if err != nil || string(data) != test.want {
+			t.Errorf("%+v encoded as %s, %v; want %s", test.op, data, err, test.want)
+		}
+	}
+}
+
+func TestDeltaStreamSequence(t *testing.T) {
+	var d deltaStream
+	state := StatePayload{SessionID: "s1", GameState: &GameState{Players: []Player{}}}
+	seq := func(msg ServerMessage) uint64 {
+		switch payload := msg.Payload.(type) {
+		case SnapshotPayload:
+			return payload.Seq
+		case PatchPayload:
+			return payload.Seq
+		}
+		t.Fatalf("%s message has no seq", msg.Type)
+		return 0
+	}
+
+	msg, ok := d.next(state)
+	if !ok || msg.Type != "snapshot" || seq(msg) != 1 {
+		t.Fatalf("first message is %s %v, want snapshot 1", msg.Type, msg.Payload)
+	}
+	if _, ok := d.next(state); ok {
+		t.Error("an unchanged view sent a message")
+	}
+
+	for i := 1; i <= snapshotInterval; i++ {
+		state.DiscardCount = i
+		msg, ok := d.next(state)
+		if !ok || msg.Type != "patch" || seq(msg) != uint64(i+1) {
+			t.Fatalf("change %d sent %s seq %d, want patch %d", i, msg.Type, seq(msg), i+1)
+		}
+		if ops := msg.Payload.(PatchPayload).Ops; len(ops) != 1 || ops[0].Path != "/discardCount" {
+			t.Fatalf("change %d sent %+v", i, ops)
+		}
+	}
+	state.DiscardCount = 0
+	if msg, _ := d.next(state); msg.Type != "snapshot" || seq(msg) != snapshotInterval+2 {
+		t.Errorf("after %d patches sent %s seq %d, want a snapshot", snapshotInterval, msg.Type, seq(msg))
+	}
+
+	d.resync()
+	if msg, _ := d.next(state); msg.Type != "snapshot" || seq(msg) != snapshotInterval+3 {
+		t.Errorf("after resync sent %s seq %d, want a snapshot", msg.Type, seq(msg))
+	}
+}
+
+func TestDeltaStreamFallsBackToFullState(t *testing.T) {
+	var d deltaStream
+	state := StatePayload{SessionID: "s1", GameState: &GameState{Players: []Player{}}}
+	d.next(state)
+
+	// NaN cannot be encoded, so the view cannot be compared
+	broken := state
+	broken.GameState = &GameState{Players: []Player{{PlayedShips: []ShipCard{{GunSize: math.NaN()}}}}}
+	msg, ok := d.next(broken)
+	if !ok || msg.Type != "state" {
+		t.Fatalf("sent %s %v, want the full state", msg.Type, ok)
+	}
+	if msg, _ := d.next(state); msg.Type != "snapshot" || msg.Payload.(SnapshotPayload).Seq != 2 {
+		t.Errorf("after the full state sent %s %+v, want snapshot 2", msg.Type, msg.Payload)
+	}
+}
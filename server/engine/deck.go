@@ -0,0 +1,170 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+)
+
+type ShipCard struct {
+	GunSize   float64 `json:"gunSize"`
+	HitPoints int     `json:"hitPoints"`
+	Name      string  `json:"name"`
+	Type      string  `json:"type"`
+}
+
+type SalvoCard struct {
+	GunSize float64 `json:"gunSize"`
+	Damage  int     `json:"damage"`
+}
+
+// Ruleset describes the cards a game is played with and how many of them
+// are dealt. A game keeps a reference to its ruleset, so a ruleset must not
+// be modified once a game uses it.
+type Ruleset struct {
+	Ships          []ShipKind  `json:"ships"`
+	Salvos         []SalvoKind `json:"salvos"`
+	BattleLineSize int         `json:"battleLineSize"`
+	HandSize       int         `json:"handSize"`
+}
+
+// ShipKind is Count identical ship cards
+type ShipKind struct {
+	Count     int     `json:"count"`
+	GunSize   float64 `json:"gunSize"`
+	HitPoints int     `json:"hitPoints"`
+	Name      string  `json:"name"`
+	Type      string  `json:"type"`
+}
+
+// SalvoKind is Count salvo cards whose damage is rolled between MinDamage
+// and MaxDamage when the deck is built.
+type SalvoKind struct {
+	Count     int     `json:"count"`
+	GunSize   float64 `json:"gunSize"`
+	MinDamage int     `json:"minDamage"`
+	MaxDamage int     `json:"maxDamage"`
+}
+
+// DefaultRuleset returns the standard Salvo decks
+func DefaultRuleset() Ruleset {
+	return Ruleset{
+		Ships: []ShipKind{
+			{2, 14, 8, "Aircraft Carrier", "carrier"},
+			{10, 11, 3, "Light Cruiser", "normal"},
+			{10, 12.6, 4, "Heavy Cruiser", "normal"},
+			{12, 14, 5, "Battlecruiser", "normal"},
+			{8, 15, 6, "Battleship", "normal"},
+			{8, 16, 7, "Super Battleship", "normal"},
+			{6, 18, 9, "Super Dreadnought", "normal"},
+		},
+		Salvos: []SalvoKind{
+			{24, 11, 1, 2},   // 11-inch salvos
+			{20, 12.6, 1, 2}, // 12.6-inch salvos
+			{24, 14, 1, 3},   // 14-inch salvos
+			{16, 15, 2, 4},   // 15-inch salvos
+			{16, 16, 2, 4},   // 16-inch salvos
+			{8, 18, 3, 4},    // 18-inch salvos
+		},
+		BattleLineSize: 5,
+		HandSize:       5,
+	}
+}
+
+// Validate checks that r can deal a game for up to maxPlayers players
+func (r Ruleset) Validate(maxPlayers int) error {
+	if r.BattleLineSize < 1 || r.HandSize < 0 {
+		return errors.New("battleLineSize must be positive and handSize must not be negative")
+	}
+	ships := 0
+	for _, kind := range r.Ships {
+		if kind.Count < 0 || kind.HitPoints < 1 || kind.GunSize <= 0 {
+			return fmt.Errorf("invalid ship %q: count, gunSize and hitPoints must be positive", kind.Name)
+		}
+		ships += kind.Count
+	}
+	salvos := 0
+	for _, kind := range r.Salvos {
+		if kind.Count < 0 || kind.GunSize <= 0 || kind.MinDamage < 1 || kind.MaxDamage < kind.MinDamage {
+			return fmt.Errorf("invalid %v-inch salvo: count, gunSize and damage must be positive with minDamage <= maxDamage", kind.GunSize)
+		}
+		salvos += kind.Count
+	}
+	if ships < r.BattleLineSize*maxPlayers {
+		return fmt.Errorf("%d ships cannot fill %d battle lines of %d", ships, maxPlayers, r.BattleLineSize)
+	}
+	if salvos < r.HandSize*maxPlayers {
+		return fmt.Errorf("%d salvos cannot deal %d hands of %d", salvos, maxPlayers, r.HandSize)
+	}
+	return nil
+}
+
+// ShipDeck builds the ship deck and shuffles it with rng
+func (r Ruleset) ShipDeck(rng *rand.Rand) []ShipCard {
+	ships := []ShipCard{}
+	for _, kind := range r.Ships {
+		for i := 0; i < kind.Count; i++ {
+			ships = append(ships, ShipCard{
+				GunSize:   kind.GunSize,
+				HitPoints: kind.HitPoints,
+				Name:      kind.Name,
+				Type:      kind.Type,
+			})
+		}
+	}
+	return Shuffle(rng, ships)
+}
+
+// PlayDeck builds the salvo deck, rolling each card's damage and shuffling
+// it with rng.
+func (r Ruleset) PlayDeck(rng *rand.Rand) []SalvoCard {
+	salvos := []SalvoCard{}
+	for _, kind := range r.Salvos {
+		for i := 0; i < kind.Count; i++ {
+			damage := rng.Intn(kind.MaxDamage-kind.MinDamage+1) + kind.MinDamage
+			salvos = append(salvos, SalvoCard{
+				GunSize: kind.GunSize,
+				Damage:  damage,
+			})
+		}
+	}
+	return Shuffle(rng, salvos)
+}
+
+// Shuffle returns a shuffled copy of deck
+func Shuffle[T any](rng *rand.Rand, deck []T) []T {
+	shuffled := make([]T, len(deck))
+	copy(shuffled, deck)
+	rng.Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+	return shuffled
+}
+
+// deal gives every player their opening battle line and hand
+func (s *State) deal(shipDeck []ShipCard, playDeck []SalvoCard) {
+	// Deal ships to each player's battle line
+	for i := 0; i < s.Rules.BattleLineSize; i++ {
+		for j := range s.Players {
+			if len(shipDeck) > 0 {
+				ship := shipDeck[len(shipDeck)-1]
+				s.Players[j].PlayedShips = append(s.Players[j].PlayedShips, ship)
+				shipDeck = shipDeck[:len(shipDeck)-1]
+			}
+		}
+	}
+
+	// Deal salvo cards to each player
+	for i := 0; i < s.Rules.HandSize; i++ {
+		for j := range s.Players {
+			if len(playDeck) > 0 {
+				salvo := playDeck[len(playDeck)-1]
+				s.Players[j].Hand = append(s.Players[j].Hand, salvo)
+				playDeck = playDeck[:len(playDeck)-1]
+			}
+		}
+	}
+
+	s.ShipDeck = shipDeck
+	s.PlayDeck = playDeck
+}
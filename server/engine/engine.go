@@ -0,0 +1,477 @@
+// Package engine implements the rules of Salvo. It has no networking or
+// concurrency of its own: a game is a State value, and Apply returns the
+// state that results from an action together with the events describing
+// what happened. Servers, bots and simulators all drive the same rules
+// through Apply.
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"slices"
+)
+
+// Player is a seat at the table. A player whose battle line has been sunk
+// is eliminated and sits out the rest of the game.
+type Player struct {
+	ID              string      `json:"id"`
+	Name            string      `json:"name"`
+	Ships           []ShipCard  `json:"ships"`
+	Hand            []SalvoCard `json:"hand"`
+	PlayedShips     []ShipCard  `json:"playedShips"`
+	DiscardedSalvos []SalvoCard `json:"discardedSalvos"`
+	DeepSixPile     []ShipCard  `json:"deepSixPile"`
+	Eliminated      bool        `json:"eliminated,omitempty"`
+}
+
+// State is a complete game. The decks are hidden from players and are not
+// part of its JSON form.
+type State struct {
+	Players         []Player    `json:"players"`
+	ShipDeck        []ShipCard  `json:"-"`
+	PlayDeck        []SalvoCard `json:"-"`
+	DiscardPile     []SalvoCard `json:"-"`
+	CurrentPlayerId string      `json:"currentPlayerId"`
+	GameStarted     bool        `json:"gameStarted"`
+	Winner          string      `json:"winner,omitempty"`
+	NumberOfPlayers int         `json:"-"`
+	Rules           *Ruleset    `json:"-"`
+}
+
+// NewGame returns an empty table for numberOfPlayers players who will play
+// with rules.
+func NewGame(numberOfPlayers int, rules *Ruleset) State {
+	return State{NumberOfPlayers: numberOfPlayers, Rules: rules}
+}
+
+// Errors returned by Apply when an action breaks the rules
+var (
+	ErrGameFull           = errors.New("game is full")
+	ErrWaitingForPlayers  = errors.New("waiting for all players to join")
+	ErrGameAlreadyStarted = errors.New("the game has already started")
+	ErrGameNotStarted     = errors.New("the game has not started")
+	ErrNotYourTurn        = errors.New("it is not your turn")
+	ErrDeckEmpty          = errors.New("there are no cards left to draw")
+	ErrCardNotInHand      = errors.New("that salvo is not in your hand")
+	ErrNoMatchingShip     = errors.New("none of your ships can fire that salvo")
+	ErrTargetNotFound     = errors.New("target ship not found")
+)
+
+// Action is a move submitted to Apply
+type Action interface {
+	isAction()
+}
+
+// Join seats a new player. Players are numbered in the order they join.
+type Join struct {
+	Name string
+}
+
+// Start deals a new game once every seat is taken. The decks are shuffled
+// from Seed, so the same seed always deals the same game.
+type Start struct {
+	Seed int64
+}
+
+type DrawSalvo struct {
+	PlayerID string
+}
+
+type DrawShip struct {
+	PlayerID string
+}
+
+// FireSalvo fires a salvo from the player's hand at a ship in an opponent's
+// battle line. One of the player's own ships must carry the salvo's gun.
+type FireSalvo struct {
+	PlayerID string
+	Salvo    SalvoCard
+	Target   ShipCard
+}
+
+type DiscardSalvo struct {
+	PlayerID string
+	Salvo    SalvoCard
+}
+
+// SkipTurn passes the player's turn without a move, as when they let a
+// turn deadline go by.
+type SkipTurn struct {
+	PlayerID string
+}
+
+func (Join) isAction()         {}
+func (Start) isAction()        {}
+func (DrawSalvo) isAction()    {}
+func (DrawShip) isAction()     {}
+func (FireSalvo) isAction()    {}
+func (DiscardSalvo) isAction() {}
+func (SkipTurn) isAction()     {}
+
+// Event reports something that happened while applying an action
+type Event interface {
+	isEvent()
+}
+
+type PlayerJoined struct {
+	PlayerID string
+	Name     string
+}
+
+type GameStarted struct{}
+
+type SalvoDrawn struct {
+	PlayerID string
+	Salvo    SalvoCard
+}
+
+// DiscardsReshuffled is reported when the play deck ran out and the discard
+// pile became the new play deck.
+type DiscardsReshuffled struct{}
+
+type ShipDrawn struct {
+	PlayerID string
+	Ship     ShipCard
+}
+
+type SalvoFired struct {
+	PlayerID       string
+	TargetPlayerID string
+	Salvo          SalvoCard
+	Target         ShipCard
+	Sunk           bool
+}
+
+type SalvoDiscarded struct {
+	PlayerID string
+	Salvo    SalvoCard
+}
+
+// PlayerEliminated is reported when a player's last ship is sunk
+type PlayerEliminated struct {
+	PlayerID string
+}
+
+type TurnPassed struct {
+	PlayerID string
+}
+
+type GameWon struct {
+	PlayerID string
+}
+
+func (PlayerJoined) isEvent()       {}
+func (GameStarted) isEvent()        {}
+func (SalvoDrawn) isEvent()         {}
+func (DiscardsReshuffled) isEvent() {}
+func (ShipDrawn) isEvent()          {}
+func (SalvoFired) isEvent()         {}
+func (SalvoDiscarded) isEvent()     {}
+func (PlayerEliminated) isEvent()   {}
+func (TurnPassed) isEvent()         {}
+func (GameWon) isEvent()            {}
+
+// Apply returns the state after action and the events it caused. The state
+// passed in is never modified; when the action is rejected Apply returns it
+// unchanged along with the error.
+func Apply(state State, action Action) (State, []Event, error) {
+	next := state.Clone()
+	var events []Event
+	var err error
+
+	switch action := action.(type) {
+	case Join:
+		events, err = next.join(action)
+	case Start:
+		events, err = next.start(action)
+	case DrawSalvo:
+		events, err = next.drawSalvo(action)
+	case DrawShip:
+		events, err = next.drawShip(action)
+	case FireSalvo:
+		events, err = next.fireSalvo(action)
+	case DiscardSalvo:
+		events, err = next.discardSalvo(action)
+	case SkipTurn:
+		events, err = next.skipTurn(action)
+	default:
+		err = fmt.Errorf("unknown action %T", action)
+	}
+	if err != nil {
+		return state, nil, err
+	}
+	return next, events, nil
+}
+
+// Clone returns a deep copy of s
+func (s State) Clone() State {
+	s.Players = slices.Clone(s.Players)
+	for i := range s.Players {
+		s.Players[i] = s.Players[i].Clone()
+	}
+	s.ShipDeck = slices.Clone(s.ShipDeck)
+	s.PlayDeck = slices.Clone(s.PlayDeck)
+	s.DiscardPile = slices.Clone(s.DiscardPile)
+	return s
+}
+
+// Clone returns a deep copy of p
+func (p Player) Clone() Player {
+	p.Ships = slices.Clone(p.Ships)
+	p.Hand = slices.Clone(p.Hand)
+	p.PlayedShips = slices.Clone(p.PlayedShips)
+	p.DiscardedSalvos = slices.Clone(p.DiscardedSalvos)
+	p.DeepSixPile = slices.Clone(p.DeepSixPile)
+	return p
+}
+
+// Player returns the player with the given ID, or nil
+func (s *State) Player(id string) *Player {
+	for i := range s.Players {
+		if s.Players[i].ID == id {
+			return &s.Players[i]
+		}
+	}
+	return nil
+}
+
+func newPlayer(id, name string) Player {
+	return Player{
+		ID:              id,
+		Name:            name,
+		Ships:           []ShipCard{},
+		Hand:            []SalvoCard{},
+		PlayedShips:     []ShipCard{},
+		DiscardedSalvos: []SalvoCard{},
+		DeepSixPile:     []ShipCard{},
+	}
+}
+
+func (s *State) join(action Join) ([]Event, error) {
+	if len(s.Players) >= s.NumberOfPlayers {
+		return nil, ErrGameFull
+	}
+	player := newPlayer(fmt.Sprintf("%d", len(s.Players)+1), action.Name)
+	s.Players = append(s.Players, player)
+	return []Event{PlayerJoined{PlayerID: player.ID, Name: player.Name}}, nil
+}
+
+func (s *State) start(action Start) ([]Event, error) {
+	// Only allow starting the game if all players have joined
+	if len(s.Players) < s.NumberOfPlayers {
+		return nil, ErrWaitingForPlayers
+	}
+	if s.GameStarted {
+		return nil, ErrGameAlreadyStarted
+	}
+
+	// Clear the table of any previous game while keeping the seating order
+	for i, player := range s.Players {
+		s.Players[i] = newPlayer(player.ID, player.Name)
+	}
+	rng := rand.New(rand.NewSource(action.Seed))
+	s.deal(s.Rules.ShipDeck(rng), s.Rules.PlayDeck(rng))
+	s.DiscardPile = make([]SalvoCard, 0)
+	s.CurrentPlayerId = s.Players[0].ID
+	s.GameStarted = true
+	s.Winner = ""
+	return []Event{GameStarted{}}, nil
+}
+
+// currentPlayer checks that the game is running and playerID is on turn
+func (s *State) currentPlayer(playerID string) (*Player, error) {
+	if !s.GameStarted {
+		return nil, ErrGameNotStarted
+	}
+	if playerID != s.CurrentPlayerId {
+		return nil, ErrNotYourTurn
+	}
+	player := s.Player(playerID)
+	if player == nil {
+		return nil, ErrNotYourTurn
+	}
+	return player, nil
+}
+
+func (s *State) drawSalvo(action DrawSalvo) ([]Event, error) {
+	player, err := s.currentPlayer(action.PlayerID)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	if len(s.PlayDeck) == 0 {
+		if len(s.DiscardPile) == 0 {
+			return nil, ErrDeckEmpty
+		}
+		// Shuffle discard pile back into play deck
+		s.PlayDeck = s.DiscardPile
+		s.DiscardPile = nil
+		events = append(events, DiscardsReshuffled{})
+	}
+
+	// Draw a card
+	card := s.PlayDeck[len(s.PlayDeck)-1]
+	s.PlayDeck = s.PlayDeck[:len(s.PlayDeck)-1]
+	player.Hand = append(player.Hand, card)
+	return append(events, SalvoDrawn{PlayerID: player.ID, Salvo: card}), nil
+}
+
+func (s *State) drawShip(action DrawShip) ([]Event, error) {
+	player, err := s.currentPlayer(action.PlayerID)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.ShipDeck) == 0 {
+		return nil, ErrDeckEmpty
+	}
+	ship := s.ShipDeck[len(s.ShipDeck)-1]
+	s.ShipDeck = s.ShipDeck[:len(s.ShipDeck)-1]
+	player.Ships = append(player.Ships, ship)
+	return []Event{ShipDrawn{PlayerID: player.ID, Ship: ship}}, nil
+}
+
+func (s *State) fireSalvo(action FireSalvo) ([]Event, error) {
+	currentPlayer, err := s.currentPlayer(action.PlayerID)
+	if err != nil {
+		return nil, err
+	}
+	salvo, target := action.Salvo, action.Target
+
+	// Check if current player has a matching ship
+	hasMatchingShip := false
+	for _, ship := range currentPlayer.PlayedShips {
+		if ship.GunSize == salvo.GunSize {
+			hasMatchingShip = true
+			break
+		}
+	}
+	if !hasMatchingShip {
+		return nil, ErrNoMatchingShip
+	}
+
+	handIndex := findSalvo(currentPlayer.Hand, salvo)
+	if handIndex < 0 {
+		return nil, ErrCardNotInHand
+	}
+
+	// Find the targeted ship in an opponent's battle line
+	var targetPlayer *Player
+	targetIndex := -1
+	for i := range s.Players {
+		if s.Players[i].ID == currentPlayer.ID {
+			continue
+		}
+		for j, ship := range s.Players[i].PlayedShips {
+			if ship.GunSize == target.GunSize && ship.HitPoints == target.HitPoints {
+				targetPlayer, targetIndex = &s.Players[i], j
+				break
+			}
+		}
+		if targetPlayer != nil {
+			break
+		}
+	}
+	if targetPlayer == nil {
+		return nil, ErrTargetNotFound
+	}
+
+	// Remove salvo from current player's hand and add it to the discard pile
+	currentPlayer.Hand = slices.Delete(currentPlayer.Hand, handIndex, handIndex+1)
+	s.DiscardPile = append(s.DiscardPile, salvo)
+
+	// Update target ship
+	ship := targetPlayer.PlayedShips[targetIndex]
+	ship.HitPoints -= salvo.Damage
+	fired := SalvoFired{PlayerID: currentPlayer.ID, TargetPlayerID: targetPlayer.ID, Salvo: salvo, Target: ship}
+	if ship.HitPoints <= 0 {
+		// Remove destroyed ship and add to deep six pile
+		targetPlayer.PlayedShips = slices.Delete(targetPlayer.PlayedShips, targetIndex, targetIndex+1)
+		currentPlayer.DeepSixPile = append(currentPlayer.DeepSixPile, ship)
+		fired.Sunk = true
+	} else {
+		// Update damaged ship
+		targetPlayer.PlayedShips[targetIndex] = ship
+	}
+	events := []Event{fired}
+
+	// A player with no ships left is out, and the game is over once only
+	// one player remains
+	if len(targetPlayer.PlayedShips) == 0 {
+		targetPlayer.Eliminated = true
+		events = append(events, PlayerEliminated{PlayerID: targetPlayer.ID})
+		if s.remainingPlayers() == 1 {
+			s.GameStarted = false
+			s.Winner = currentPlayer.ID
+			return append(events, GameWon{PlayerID: currentPlayer.ID}), nil
+		}
+	}
+	return append(events, s.passTurn()), nil
+}
+
+func (s *State) discardSalvo(action DiscardSalvo) ([]Event, error) {
+	currentPlayer, err := s.currentPlayer(action.PlayerID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Remove salvo from current player's hand and add it to the discard pile
+	handIndex := findSalvo(currentPlayer.Hand, action.Salvo)
+	if handIndex < 0 {
+		return nil, ErrCardNotInHand
+	}
+	currentPlayer.Hand = slices.Delete(currentPlayer.Hand, handIndex, handIndex+1)
+	s.DiscardPile = append(s.DiscardPile, action.Salvo)
+
+	return []Event{
+		SalvoDiscarded{PlayerID: currentPlayer.ID, Salvo: action.Salvo},
+		s.passTurn(),
+	}, nil
+}
+
+func (s *State) skipTurn(action SkipTurn) ([]Event, error) {
+	if _, err := s.currentPlayer(action.PlayerID); err != nil {
+		return nil, err
+	}
+	return []Event{s.passTurn()}, nil
+}
+
+// passTurn moves play to the next player in seating order who has not
+// been eliminated
+func (s *State) passTurn() Event {
+	for i, player := range s.Players {
+		if player.ID != s.CurrentPlayerId {
+			continue
+		}
+		for step := 1; step < len(s.Players); step++ {
+			next := s.Players[(i+step)%len(s.Players)]
+			if !next.Eliminated {
+				s.CurrentPlayerId = next.ID
+				break
+			}
+		}
+		break
+	}
+	return TurnPassed{PlayerID: s.CurrentPlayerId}
+}
+
+// remainingPlayers counts the players who have not been eliminated
+func (s *State) remainingPlayers() int {
+	remaining := 0
+	for _, player := range s.Players {
+		if !player.Eliminated {
+			remaining++
+		}
+	}
+	return remaining
+}
+
+func findSalvo(hand []SalvoCard, salvo SalvoCard) int {
+	for i, card := range hand {
+		if card.GunSize == salvo.GunSize && card.Damage == salvo.Damage {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,301 @@
+package engine
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+var (
+	cruiser    = ShipCard{GunSize: 11, HitPoints: 3, Name: "Light Cruiser", Type: "normal"}
+	battleship = ShipCard{GunSize: 15, HitPoints: 6, Name: "Battleship", Type: "normal"}
+	light      = SalvoCard{GunSize: 11, Damage: 2}
+	heavy      = SalvoCard{GunSize: 11, Damage: 3}
+)
+
+// seat returns player id with line as their battle line and a hand of one
+// light and one heavy 11-inch salvo.
+func seat(id string, line ...ShipCard) Player {
+	player := newPlayer(id, "Player "+id)
+	player.PlayedShips = line
+	player.Hand = []SalvoCard{light, heavy}
+	return player
+}
+
+// table returns a started game between players in which player 1 is on
+// turn.
+func table(players ...Player) State {
+	rules := DefaultRuleset()
+	return State{
+		Players:         players,
+		ShipDeck:        []ShipCard{battleship},
+		PlayDeck:        []SalvoCard{heavy, light},
+		DiscardPile:     []SalvoCard{},
+		CurrentPlayerId: "1",
+		GameStarted:     true,
+		NumberOfPlayers: len(players),
+		Rules:           &rules,
+	}
+}
+
+func TestApply(t *testing.T) {
+	duel := table(seat("1", cruiser), seat("2", battleship, cruiser))
+	lastShip := table(seat("1", cruiser), seat("2", cruiser))
+	threeWay := table(seat("1", cruiser), seat("2", cruiser), seat("3", battleship))
+	exhausted := table(seat("1", cruiser), seat("2", cruiser))
+	exhausted.PlayDeck = []SalvoCard{}
+	exhausted.DiscardPile = []SalvoCard{light, heavy}
+	oneEliminated := table(seat("1", cruiser), seat("2"), seat("3", cruiser))
+	oneEliminated.Players[1].Eliminated = true
+	notStarted := duel.Clone()
+	notStarted.GameStarted = false
+
+	for _, test := range []struct {
+		name   string
+		state  State
+		action Action
+		err    error
+		events []Event
+		// want makes the changes the action should make to the state
+		want func(s *State)
+	}{
+		{
+			name:   "game not started",
+			state:  notStarted,
+			action: DrawSalvo{PlayerID: "1"},
+			err:    ErrGameNotStarted,
+		},
+		{
+			name:   "draw out of turn",
+			state:  duel,
+			action: DrawSalvo{PlayerID: "2"},
+			err:    ErrNotYourTurn,
+		},
+		{
+			name:   "fire out of turn",
+			state:  duel,
+			action: FireSalvo{PlayerID: "2", Salvo: light, Target: cruiser},
+			err:    ErrNotYourTurn,
+		},
+		{
+			name:   "draw salvo",
+			state:  duel,
+			action: DrawSalvo{PlayerID: "1"},
+			events: []Event{SalvoDrawn{PlayerID: "1", Salvo: light}},
+			want: func(s *State) {
+				s.PlayDeck = []SalvoCard{heavy}
+				s.Players[0].Hand = append(s.Players[0].Hand, light)
+			},
+		},
+		{
+			name:   "draw reshuffles the discards",
+			state:  exhausted,
+			action: DrawSalvo{PlayerID: "1"},
+			events: []Event{DiscardsReshuffled{}, SalvoDrawn{PlayerID: "1", Salvo: heavy}},
+			want: func(s *State) {
+				s.PlayDeck = []SalvoCard{light}
+				s.DiscardPile = nil
+				s.Players[0].Hand = append(s.Players[0].Hand, heavy)
+			},
+		},
+		{
+			name: "draw with no cards left",
+			state: func() State {
+				s := exhausted.Clone()
+				s.DiscardPile = []SalvoCard{}
+				return s
+			}(),
+			action: DrawSalvo{PlayerID: "1"},
+			err:    ErrDeckEmpty,
+		},
+		{
+			name:   "draw ship",
+			state:  duel,
+			action: DrawShip{PlayerID: "1"},
+			events: []Event{ShipDrawn{PlayerID: "1", Ship: battleship}},
+			want: func(s *State) {
+				s.ShipDeck = []ShipCard{}
+				s.Players[0].Ships = append(s.Players[0].Ships, battleship)
+			},
+		},
+		{
+			name:   "fire damages a ship",
+			state:  duel,
+			action: FireSalvo{PlayerID: "1", Salvo: light, Target: battleship},
+			events: []Event{
+				SalvoFired{PlayerID: "1", TargetPlayerID: "2", Salvo: light, Target: ShipCard{GunSize: 15, HitPoints: 4, Name: "Battleship", Type: "normal"}},
+				TurnPassed{PlayerID: "2"},
+			},
+			want: func(s *State) {
+				s.Players[0].Hand = []SalvoCard{heavy}
+				s.Players[1].PlayedShips[0].HitPoints = 4
+				s.DiscardPile = []SalvoCard{light}
+				s.CurrentPlayerId = "2"
+			},
+		},
+		{
+			name:   "fire sinks a ship",
+			state:  duel,
+			action: FireSalvo{PlayerID: "1", Salvo: heavy, Target: cruiser},
+			events: []Event{
+				SalvoFired{PlayerID: "1", TargetPlayerID: "2", Salvo: heavy, Target: ShipCard{GunSize: 11, HitPoints: 0, Name: "Light Cruiser", Type: "normal"}, Sunk: true},
+				TurnPassed{PlayerID: "2"},
+			},
+			want: func(s *State) {
+				s.Players[0].Hand = []SalvoCard{light}
+				s.Players[0].DeepSixPile = []ShipCard{{GunSize: 11, HitPoints: 0, Name: "Light Cruiser", Type: "normal"}}
+				s.Players[1].PlayedShips = []ShipCard{battleship}
+				s.DiscardPile = []SalvoCard{heavy}
+				s.CurrentPlayerId = "2"
+			},
+		},
+		{
+			name:   "sinking the last opponent's last ship wins",
+			state:  lastShip,
+			action: FireSalvo{PlayerID: "1", Salvo: heavy, Target: cruiser},
+			events: []Event{
+				SalvoFired{PlayerID: "1", TargetPlayerID: "2", Salvo: heavy, Target: ShipCard{GunSize: 11, HitPoints: 0, Name: "Light Cruiser", Type: "normal"}, Sunk: true},
+				PlayerEliminated{PlayerID: "2"},
+				GameWon{PlayerID: "1"},
+			},
+			want: func(s *State) {
+				s.Players[0].Hand = []SalvoCard{light}
+				s.Players[0].DeepSixPile = []ShipCard{{GunSize: 11, HitPoints: 0, Name: "Light Cruiser", Type: "normal"}}
+				s.Players[1].PlayedShips = []ShipCard{}
+				s.Players[1].Eliminated = true
+				s.DiscardPile = []SalvoCard{heavy}
+				s.GameStarted = false
+				s.Winner = "1"
+			},
+		},
+		{
+			name:   "the game goes on while two players have ships",
+			state:  threeWay,
+			action: FireSalvo{PlayerID: "1", Salvo: heavy, Target: cruiser},
+			events: []Event{
+				SalvoFired{PlayerID: "1", TargetPlayerID: "2", Salvo: heavy, Target: ShipCard{GunSize: 11, HitPoints: 0, Name: "Light Cruiser", Type: "normal"}, Sunk: true},
+				PlayerEliminated{PlayerID: "2"},
+				TurnPassed{PlayerID: "3"},
+			},
+			want: func(s *State) {
+				s.Players[0].Hand = []SalvoCard{light}
+				s.Players[0].DeepSixPile = []ShipCard{{GunSize: 11, HitPoints: 0, Name: "Light Cruiser", Type: "normal"}}
+				s.Players[1].PlayedShips = []ShipCard{}
+				s.Players[1].Eliminated = true
+				s.DiscardPile = []SalvoCard{heavy}
+				s.CurrentPlayerId = "3"
+			},
+		},
+		{
+			name:   "eliminated players are skipped",
+			state:  oneEliminated,
+			action: SkipTurn{PlayerID: "1"},
+			events: []Event{TurnPassed{PlayerID: "3"}},
+			want:   func(s *State) { s.CurrentPlayerId = "3" },
+		},
+		{
+			name:   "discard passes the turn",
+			state:  duel,
+			action: DiscardSalvo{PlayerID: "1", Salvo: heavy},
+			events: []Event{SalvoDiscarded{PlayerID: "1", Salvo: heavy}, TurnPassed{PlayerID: "2"}},
+			want: func(s *State) {
+				s.Players[0].Hand = []SalvoCard{light}
+				s.DiscardPile = []SalvoCard{heavy}
+				s.CurrentPlayerId = "2"
+			},
+		},
+		{
+			name:   "fire without a matching gun",
+			state:  duel,
+			action: FireSalvo{PlayerID: "1", Salvo: SalvoCard{GunSize: 18, Damage: 4}, Target: cruiser},
+			err:    ErrNoMatchingShip,
+		},
+		{
+			name:   "fire a salvo not in hand",
+			state:  duel,
+			action: FireSalvo{PlayerID: "1", Salvo: SalvoCard{GunSize: 11, Damage: 1}, Target: cruiser},
+			err:    ErrCardNotInHand,
+		},
+		{
+			name:   "fire at a ship no opponent has",
+			state:  lastShip,
+			action: FireSalvo{PlayerID: "1", Salvo: light, Target: battleship},
+			err:    ErrTargetNotFound,
+		},
+		{
+			name:   "discard a salvo not in hand",
+			state:  duel,
+			action: DiscardSalvo{PlayerID: "1", Salvo: SalvoCard{GunSize: 16, Damage: 2}},
+			err:    ErrCardNotInHand,
+		},
+		{
+			name:   "join a full table",
+			state:  duel,
+			action: Join{Name: "Eve"},
+			err:    ErrGameFull,
+		},
+		{
+			name:   "start a started game",
+			state:  duel,
+			action: Start{Seed: 1},
+			err:    ErrGameAlreadyStarted,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			before := test.state.Clone()
+			next, events, err := Apply(test.state, test.action)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("error %v, want %v", err, test.err)
+			}
+			if !reflect.DeepEqual(test.state, before) {
+				t.Error("Apply modified the state passed in")
+			}
+			if !reflect.DeepEqual(events, test.events) {
+				t.Errorf("events %#v, want %#v", events, test.events)
+			}
+
+			want := before.Clone()
+			if test.want != nil {
+				test.want(&want)
+			}
+			if !reflect.DeepEqual(next, want) {
+				t.Errorf("state\n%+v, want\n%+v", next, want)
+			}
+		})
+	}
+}
+
+func TestStartDealsFromTheSeed(t *testing.T) {
+	rules := DefaultRuleset()
+	game := NewGame(2, &rules)
+	if _, _, err := Apply(game, Start{Seed: 1}); !errors.Is(err, ErrWaitingForPlayers) {
+		t.Fatalf("starting an empty table: %v, want %v", err, ErrWaitingForPlayers)
+	}
+	for _, name := range []string{"Ann", "Ben"} {
+		var err error
+		if game, _, err = Apply(game, Join{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	first, events, err := Apply(game, Start{Seed: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(events, []Event{GameStarted{}}) {
+		t.Errorf("events %#v, want GameStarted", events)
+	}
+	for _, player := range first.Players {
+		if len(player.PlayedShips) != rules.BattleLineSize || len(player.Hand) != rules.HandSize {
+			t.Errorf("player %s was dealt %d ships and %d salvos", player.ID, len(player.PlayedShips), len(player.Hand))
+		}
+	}
+	if first.CurrentPlayerId != "1" || !first.GameStarted {
+		t.Errorf("player %q is on turn in a game started %v, want player 1", first.CurrentPlayerId, first.GameStarted)
+	}
+
+	again, _, _ := Apply(game, Start{Seed: 7})
+	if !reflect.DeepEqual(first, again) {
+		t.Error("the same seed dealt different games")
+	}
+}
@@ -0,0 +1,44 @@
+package engine
+
+import "log/slog"
+
+// The cards in a player's hand and ships, the decks and the cards a player
+// draws are hidden information. These LogValue methods keep them out of
+// logs: states and players log card counts, and draw events log only who
+// drew.
+
+func (p Player) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("id", p.ID),
+		slog.String("name", p.Name),
+		slog.Int("handSize", len(p.Hand)),
+		slog.Int("shipCount", len(p.Ships)),
+		slog.Int("playedShips", len(p.PlayedShips)),
+		slog.Int("deepSix", len(p.DeepSixPile)),
+	)
+}
+
+func (s State) LogValue() slog.Value {
+	players := make([]slog.Attr, len(s.Players))
+	for i, player := range s.Players {
+		players[i] = slog.Any(player.ID, player)
+	}
+	return slog.GroupValue(
+		slog.Int("numberOfPlayers", s.NumberOfPlayers),
+		slog.Bool("gameStarted", s.GameStarted),
+		slog.String("currentPlayerId", s.CurrentPlayerId),
+		slog.String("winner", s.Winner),
+		slog.Int("shipDeck", len(s.ShipDeck)),
+		slog.Int("playDeck", len(s.PlayDeck)),
+		slog.Int("discardPile", len(s.DiscardPile)),
+		slog.Attr{Key: "players", Value: slog.GroupValue(players...)},
+	)
+}
+
+func (e SalvoDrawn) LogValue() slog.Value {
+	return slog.GroupValue(slog.String("playerId", e.PlayerID))
+}
+
+func (e ShipDrawn) LogValue() slog.Value {
+	return slog.GroupValue(slog.String("playerId", e.PlayerID))
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// frontendFiles holds the production build of the React frontend, which
+// npm run build:server writes to frontend/dist. A binary built without it
+// embeds only the placeholder and refuses -serve-frontend.
+//
+//go:embed all:frontend
+var frontendFiles embed.FS
+
+// frontendRoot is the file system the frontend is served from. It is
+// frontendFiles except in tests, which serve a build of their own.
+var frontendRoot fs.FS = frontendFiles
+
+// immutableAssets is the directory where Vite writes files whose names carry
+// a hash of their content, so they never change and can be cached forever.
+const immutableAssets = "assets/"
+
+// newFrontendHandler serves the embedded frontend. Paths that are not files
+// are client side routes such as /joingame/{sessionId}, and get index.html
+// for the router to render.
+func newFrontendHandler() (http.Handler, error) {
+	dist, err := fs.Sub(frontendRoot, "frontend/dist")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fs.Stat(dist, "index.html"); err != nil {
+		return nil, errors.New("the frontend is not built into this binary, run npm run build:server and rebuild the server")
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+		if info, err := fs.Stat(dist, name); err != nil || info.IsDir() {
+			// A missing script or image is an error, not a page
+			if path.Ext(name) != "" {
+				http.NotFound(w, r)
+				return
+			}
+			name = "index.html"
+		}
+
+		if strings.HasPrefix(name, immutableAssets) {
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		} else {
+			// index.html names the current assets, so browsers must check
+			// for a new one on every visit
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+		http.ServeFileFS(w, r, dist, name)
+	}), nil
+}
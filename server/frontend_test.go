@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+// useFrontend serves a stand-in frontend build and returns the server's
+// routes with the frontend enabled.
+func useFrontend(t *testing.T) http.Handler {
+	t.Helper()
+	oldRoot := frontendRoot
+	frontendRoot = fstest.MapFS{
+		"frontend/dist/index.html":           {Data: []byte("<title>Salvo</title>")},
+		"frontend/dist/favicon.svg":          {Data: []byte("<svg/>")},
+		"frontend/dist/assets/index-1a2b.js": {Data: []byte("console.log('salvo')")},
+	}
+	t.Cleanup(func() { frontendRoot = oldRoot })
+	useAPI(t)
+	config.ServeFrontend = true
+	mux, err := newServeMux()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mux
+}
+
+func TestFrontendRoutes(t *testing.T) {
+	handler := useFrontend(t)
+	for _, test := range []struct {
+		method, path string
+		status       int
+		body, cache  string
+	}{
+		{"GET", "/", http.StatusOK, "<title>Salvo</title>", "no-cache"},
+		{"GET", "/joingame/PEARL-TENDER-25", http.StatusOK, "<title>Salvo</title>", "no-cache"},
+		{"GET", "/assets/", http.StatusOK, "<title>Salvo</title>", "no-cache"},
+		{"GET", "/favicon.svg", http.StatusOK, "<svg/>", "no-cache"},
+		{"GET", "/assets/index-1a2b.js", http.StatusOK, "console.log('salvo')", "public, max-age=31536000, immutable"},
+		{"GET", "/assets/index-old.js", http.StatusNotFound, "", ""},
+		{"POST", "/", http.StatusMethodNotAllowed, "", ""},
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(test.method, test.path, nil))
+		if rec.Code != test.status {
+			t.Errorf("%s %s answered %d, want %d", test.method, test.path, rec.Code, test.status)
+			continue
+		}
+		if test.body != "" && rec.Body.String() != test.body {
+			t.Errorf("%s %s served %q, want %q", test.method, test.path, rec.Body.String(), test.body)
+		}
+		if cache := rec.Header().Get("Cache-Control"); test.cache != "" && cache != test.cache {
+			t.Errorf("%s %s has Cache-Control %q, want %q", test.method, test.path, cache, test.cache)
+		}
+	}
+
+	// The API answers under /api as well as at its own paths
+	for _, path := range []string{"/healthz", "/api/healthz"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != http.StatusOK || rec.Header().Get("Cache-Control") != "" {
+			t.Errorf("GET %s answered %d with Cache-Control %q, want the health check", path, rec.Code, rec.Header().Get("Cache-Control"))
+		}
+	}
+	var errPayload ErrorPayload
+	if status := apiCall(t, handler, "GET", "/api/games/NO-SUCH-GAME", "", nil, &errPayload); status != http.StatusNotFound || errPayload.Code != CodeNotFound {
+		t.Errorf("an unknown game under /api answered %d %q, want the API's 404", status, errPayload.Code)
+	}
+}
+
+func TestFrontendRequiresABuild(t *testing.T) {
+	oldRoot := frontendRoot
+	frontendRoot = fstest.MapFS{"frontend/README.md": {Data: []byte("placeholder")}}
+	t.Cleanup(func() { frontendRoot = oldRoot })
+	if _, err := newFrontendHandler(); err == nil {
+		t.Error("the frontend handler started without a build")
+	}
+}
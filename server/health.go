@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// ready reports whether the server accepts new work. It is set once the
+// server is listening and cleared when a graceful shutdown begins, so load
+// balancers stop sending traffic while connections drain.
+var ready atomic.Bool
+
+// handleHealthz answers as long as the process is serving HTTP
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if !ready.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("shutting down\n"))
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
@@ -0,0 +1,421 @@
+package harness
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"game-server/engine"
+	"github.com/gorilla/websocket"
+)
+
+// Client is a scripted player or spectator. Every message the server sends
+// is queued until the test reads it; reading a joined or state message
+// updates the client's seat and State. A Client is used from the test's
+// goroutine only.
+type Client struct {
+	Name string
+
+	// Set by the joined message
+	SessionID string
+	RoomCode  string
+	PlayerID  string
+	Token     string
+	Spectator bool
+
+	// State is the latest state message read, or nil before the first
+	State *State
+
+	// Timeout is how long the client waits for a message, DefaultTimeout
+	// unless the test changes it.
+	Timeout time.Duration
+
+	t      testing.TB
+	conn   *websocket.Conn
+	lastID int
+
+	mu       sync.Mutex
+	queue    []Message
+	closed   bool
+	closeErr error         // why the connection closed
+	arrived  chan struct{} // signalled when a message is queued or the connection closes
+}
+
+// Connect opens a websocket for a client called name and completes the
+// handshake, asking for features.
+func (s *Server) Connect(name string, features ...string) *Client {
+	s.t.Helper()
+	c := s.Dial(name)
+	welcome := c.Request("hello", map[string]any{"protocolVersion": ProtocolVersion, "client": "harness", "features": features})
+	if welcome.Type != "welcome" {
+		s.t.Fatalf("%s: hello answered with %s %s", name, welcome.Type, welcome.Payload)
+	}
+	return c
+}
+
+// Dial opens a websocket for a client called name without the handshake,
+// for tests of the handshake itself.
+func (s *Server) Dial(name string) *Client {
+	s.t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(s.WebSocketURL(), nil)
+	if err != nil {
+		s.t.Fatalf("%s: connect: %v", name, err)
+	}
+	c := &Client{
+		Name:    name,
+		Timeout: DefaultTimeout,
+		t:       s.t,
+		conn:    conn,
+		arrived: make(chan struct{}, 1),
+	}
+	s.t.Cleanup(c.Close)
+	go c.readPump()
+	return c
+}
+
+// Close closes the client's connection
+func (c *Client) Close() {
+	c.conn.Close()
+}
+
+// readPump queues the server's messages until the connection closes
+func (c *Client) readPump() {
+	for {
+		var msg Message
+		err := c.conn.ReadJSON(&msg)
+		c.mu.Lock()
+		if err != nil {
+			c.closed, c.closeErr = true, err
+		} else {
+			c.queue = append(c.queue, msg)
+		}
+		c.mu.Unlock()
+		select {
+		case c.arrived <- struct{}{}:
+		default:
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Next returns the next message from the server, failing the test when
+// none arrives within the timeout.
+func (c *Client) Next() Message {
+	c.t.Helper()
+	timeout := time.After(c.Timeout)
+	for {
+		c.mu.Lock()
+		if len(c.queue) > 0 {
+			msg := c.queue[0]
+			c.queue = c.queue[1:]
+			c.mu.Unlock()
+			c.track(msg)
+			return msg
+		}
+		closed := c.closed
+		c.mu.Unlock()
+		if closed {
+			c.t.Fatalf("%s: connection closed while waiting for a message", c.Name)
+		}
+		select {
+		case <-c.arrived:
+		case <-timeout:
+			c.t.Fatalf("%s: no message from the server within %v", c.Name, c.Timeout)
+		}
+	}
+}
+
+// track updates the client's seat and state from msg
+func (c *Client) track(msg Message) {
+	switch msg.Type {
+	case "joined":
+		var joined struct {
+			SessionID string `json:"sessionId"`
+			RoomCode  string `json:"roomCode"`
+			PlayerID  string `json:"playerId"`
+			Spectator bool   `json:"spectator"`
+			Token     string `json:"token"`
+		}
+		c.Decode(msg, &joined)
+		c.SessionID, c.RoomCode, c.PlayerID = joined.SessionID, joined.RoomCode, joined.PlayerID
+		c.Spectator, c.Token = joined.Spectator, joined.Token
+	case "state":
+		var state State
+		c.Decode(msg, &state)
+		c.State = &state
+	}
+}
+
+// Decode unmarshals msg's payload into v, failing the test when it does not
+// fit.
+func (c *Client) Decode(msg Message, v any) {
+	c.t.Helper()
+	if err := json.Unmarshal(msg.Payload, v); err != nil {
+		c.t.Fatalf("%s: invalid %s payload %s: %v", c.Name, msg.Type, msg.Payload, err)
+	}
+}
+
+// Send sends a message and returns its ID without waiting for the answer
+func (c *Client) Send(msgType string, payload any) string {
+	c.t.Helper()
+	c.lastID++
+	id := strconv.Itoa(c.lastID)
+	msg := map[string]any{"v": ProtocolVersion, "id": id, "type": msgType}
+	if payload != nil {
+		msg["payload"] = payload
+	}
+	if err := c.conn.WriteJSON(msg); err != nil {
+		c.t.Fatalf("%s: send %s: %v", c.Name, msgType, err)
+	}
+	return id
+}
+
+// SendRaw sends data as a text frame as it is, for tests of what the
+// server does with frames no well-behaved client sends.
+func (c *Client) SendRaw(data []byte) {
+	c.t.Helper()
+	if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		c.t.Fatalf("%s: send: %v", c.Name, err)
+	}
+}
+
+// Request sends a message and returns the server's reply to it. The
+// messages that arrive before the reply are read and tracked.
+func (c *Client) Request(msgType string, payload any) Message {
+	c.t.Helper()
+	id := c.Send(msgType, payload)
+	for {
+		if msg := c.Next(); msg.ReplyTo == id {
+			return msg
+		}
+	}
+}
+
+// Do sends a message and fails the test unless the server accepts it
+func (c *Client) Do(msgType string, payload any) Message {
+	c.t.Helper()
+	reply := c.Request(msgType, payload)
+	if reply.Type == "error" {
+		var e ErrorPayload
+		c.Decode(reply, &e)
+		c.t.Fatalf("%s: %s refused with %s: %s", c.Name, msgType, e.Code, e.Message)
+	}
+	return reply
+}
+
+// Reject sends a message and fails the test unless the server refuses it
+// with the error code.
+func (c *Client) Reject(msgType string, payload any, code string) ErrorPayload {
+	c.t.Helper()
+	reply := c.Request(msgType, payload)
+	var e ErrorPayload
+	if reply.Type == "error" {
+		c.Decode(reply, &e)
+	}
+	if e.Code != code {
+		c.t.Fatalf("%s: %s answered with %s %s, want error %s", c.Name, msgType, reply.Type, reply.Payload, code)
+	}
+	return e
+}
+
+// Expect reads messages until one of msgType arrives and returns it. The
+// messages before it are tracked and dropped.
+func (c *Client) Expect(msgType string) Message {
+	c.t.Helper()
+	for {
+		if msg := c.Next(); msg.Type == msgType {
+			return msg
+		}
+	}
+}
+
+// ExpectNone fails the test if a message of msgType arrives within wait.
+// Messages of other types are tracked and dropped.
+func (c *Client) ExpectNone(msgType string, wait time.Duration) {
+	c.t.Helper()
+	timeout := time.Now().Add(wait)
+	for time.Now().Before(timeout) {
+		c.mu.Lock()
+		pending := len(c.queue) > 0
+		c.mu.Unlock()
+		if !pending {
+			select {
+			case <-c.arrived:
+			case <-time.After(time.Until(timeout)):
+			}
+			continue
+		}
+		if msg := c.Next(); msg.Type == msgType {
+			c.t.Fatalf("%s: unexpected %s %s", c.Name, msg.Type, msg.Payload)
+		}
+	}
+}
+
+// ExpectClosed reads and drops messages until the server closes the
+// connection and returns the close code, failing the test when the
+// connection stays open for the timeout.
+func (c *Client) ExpectClosed() int {
+	c.t.Helper()
+	timeout := time.After(c.Timeout)
+	for {
+		c.mu.Lock()
+		c.queue = nil
+		closed, err := c.closed, c.closeErr
+		c.mu.Unlock()
+		if closed {
+			if closeErr, ok := err.(*websocket.CloseError); ok {
+				return closeErr.Code
+			}
+			return websocket.CloseAbnormalClosure
+		}
+		select {
+		case <-c.arrived:
+		case <-timeout:
+			c.t.Fatalf("%s: the connection is still open after %v", c.Name, c.Timeout)
+		}
+	}
+}
+
+// WaitState reads messages until the client's state satisfies done and
+// returns it.
+func (c *Client) WaitState(done func(*State) bool) *State {
+	c.t.Helper()
+	for c.State == nil || !done(c.State) {
+		c.Next()
+	}
+	return c.State
+}
+
+// Me returns the client's player in its latest state
+func (c *Client) Me() *engine.Player {
+	c.t.Helper()
+	if c.State == nil {
+		c.t.Fatalf("%s: no game state yet", c.Name)
+	}
+	player := c.State.Player(c.PlayerID)
+	if player == nil {
+		c.t.Fatalf("%s: player %s is not in the game", c.Name, c.PlayerID)
+	}
+	return player
+}
+
+// Scripted actions. Each fails the test unless the server accepts it.
+
+// Create creates a game for players and takes its first seat
+func (c *Client) Create(players int) {
+	c.t.Helper()
+	c.Do("createGame", map[string]any{"numberOfPlayers": players, "playerName": c.Name})
+}
+
+// Join takes a seat in the game with the session ID or room code
+func (c *Client) Join(sessionID string) {
+	c.t.Helper()
+	c.Do("joinGame", map[string]any{"sessionId": sessionID, "playerName": c.Name})
+}
+
+// Spectate watches the game with the session ID or room code
+func (c *Client) Spectate(sessionID string) {
+	c.t.Helper()
+	c.Do("spectateGame", map[string]any{"sessionId": sessionID, "playerName": c.Name})
+}
+
+// Rejoin takes back the seat of a player whose connection dropped
+func (c *Client) Rejoin(sessionID, playerID, token string) {
+	c.t.Helper()
+	c.Do("rejoinGame", map[string]any{"sessionId": sessionID, "playerId": playerID, "token": token})
+}
+
+func (c *Client) Start() {
+	c.t.Helper()
+	c.Do("startGame", nil)
+}
+
+func (c *Client) DrawSalvo() {
+	c.t.Helper()
+	c.Do("drawSalvo", nil)
+}
+
+func (c *Client) DrawShip() {
+	c.t.Helper()
+	c.Do("drawShip", nil)
+}
+
+func (c *Client) Fire(salvo engine.SalvoCard, target engine.ShipCard) {
+	c.t.Helper()
+	c.Do("fireSalvo", map[string]any{"salvo": salvo, "target": target})
+}
+
+func (c *Client) Discard(salvo engine.SalvoCard) {
+	c.t.Helper()
+	c.Do("discardSalvo", map[string]any{"salvo": salvo})
+}
+
+func (c *Client) Say(text string) {
+	c.t.Helper()
+	c.Do("chat", map[string]any{"text": text})
+}
+
+// PlayTurn waits for the client's turn and plays it: it draws a salvo when
+// the deck allows, then fires the first salvo one of its ships can fire at
+// the opponent's weakest ship, or discards a salvo when it can fire none.
+// It returns once the client's state shows the turn is over.
+func (c *Client) PlayTurn() {
+	c.t.Helper()
+	c.WaitState(func(s *State) bool { return s.GameState.CurrentPlayerId == c.PlayerID })
+
+	handSize := len(c.Me().Hand)
+	reply := c.Request("drawSalvo", nil)
+	if reply.Type == "error" {
+		var e ErrorPayload
+		c.Decode(reply, &e)
+		if e.Code != "deck_empty" {
+			c.t.Fatalf("%s: drawSalvo refused with %s: %s", c.Name, e.Code, e.Message)
+		}
+	} else {
+		c.WaitState(func(*State) bool { return len(c.Me().Hand) > handSize })
+	}
+
+	me := c.Me()
+	if len(me.Hand) == 0 {
+		c.t.Fatalf("%s: no salvo to play", c.Name)
+	}
+	var target *engine.ShipCard
+	for _, player := range c.State.GameState.Players {
+		if player.ID == me.ID {
+			continue
+		}
+		for i, ship := range player.PlayedShips {
+			if target == nil || ship.HitPoints < target.HitPoints {
+				target = &player.PlayedShips[i]
+			}
+		}
+	}
+	fired := false
+	for _, salvo := range me.Hand {
+		if target != nil && canFire(me, salvo) {
+			c.Fire(salvo, *target)
+			fired = true
+			break
+		}
+	}
+	if !fired {
+		c.Discard(me.Hand[0])
+	}
+	c.WaitState(func(s *State) bool {
+		return s.GameState.CurrentPlayerId != c.PlayerID || s.GameState.Winner != ""
+	})
+}
+
+// canFire reports whether one of player's ships carries the salvo's gun
+func canFire(player *engine.Player, salvo engine.SalvoCard) bool {
+	for _, ship := range player.PlayedShips {
+		if ship.GunSize == salvo.GunSize {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+// Package harness drives a Salvo game server from tests. It serves the
+// server's handler with httptest and connects scripted websocket clients
+// that speak the protocol, keep track of their seat and view of the game,
+// and fail the test when the server does not answer as expected:
+//
+//	srv := harness.New(t, mux)
+//	alice, bob := srv.Connect("Alice"), srv.Connect("Bob")
+//	alice.Create(2)
+//	bob.Join(alice.RoomCode)
+//	alice.Start()
+//	bob.Expect("gameStarted")
+//
+// The package has its own copy of the message envelope, as any client
+// would, so it tests the protocol rather than the server's types.
+package harness
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"game-server/engine"
+)
+
+// ProtocolVersion is the protocol version the clients speak
+const ProtocolVersion = 1
+
+// DefaultTimeout is how long a client waits for a message from the server
+// before it fails the test.
+const DefaultTimeout = 2 * time.Second
+
+// Message is a message received from the server
+type Message struct {
+	Version int             `json:"v"`
+	ID      string          `json:"id"`
+	ReplyTo string          `json:"replyTo,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// ErrorPayload is the payload of an error message
+type ErrorPayload struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// State is the payload of a state message: a player's view of the game
+type State struct {
+	SessionID     string       `json:"sessionId"`
+	GameState     engine.State `json:"gameState"`
+	ShipDeckCount int          `json:"shipDeckCount"`
+	PlayDeckCount int          `json:"playDeckCount"`
+	DiscardCount  int          `json:"discardCount"`
+	TurnDeadline  *time.Time   `json:"turnDeadline,omitempty"`
+}
+
+// Player returns the player with id, or nil
+func (s *State) Player(id string) *engine.Player {
+	return s.GameState.Player(id)
+}
+
+// Server is a game server under test
+type Server struct {
+	*httptest.Server
+	t testing.TB
+}
+
+// New serves handler, which must route /ws to the server's websocket
+// handler, until the test ends.
+func New(t testing.TB, handler http.Handler) *Server {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return &Server{Server: srv, t: t}
+}
+
+// WebSocketURL returns the URL of the server's websocket
+func (s *Server) WebSocketURL() string {
+	return "ws" + strings.TrimPrefix(s.URL, "http") + "/ws"
+}
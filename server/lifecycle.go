@@ -0,0 +1,252 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Session lifecycle states. A session waits in the lobby until the game is
+// dealt, is in progress until someone wins or an administrator ends it, and
+// is then finished. Each state has its own idle timeout, after which the
+// session is removed; finished games are kept in the archive.
+const (
+	SessionLobby      = "lobby"
+	SessionInProgress = "inProgress"
+	SessionFinished   = "finished"
+	SessionArchived   = "archived"
+)
+
+// lifecycleState returns the session's state. Archived sessions are no
+// longer hosted, so a live session is never archived.
+func (session *GameSession) lifecycleState() string {
+	state := session.GameState
+	switch {
+	case state.Winner != "" || session.ended:
+		return SessionFinished
+	case state.GameStarted:
+		return SessionInProgress
+	default:
+		return SessionLobby
+	}
+}
+
+// idleTimeout returns how long the session may go without activity in its
+// current state. Correspondence games wait days for a move rather than
+// minutes until they are finished, and tournament matches give their
+// entrants the match start timeout to turn up before they are forfeited.
+func (session *GameSession) idleTimeout() time.Duration {
+	state := session.lifecycleState()
+	if session.turnDeadline > 0 && state != SessionFinished {
+		return time.Duration(config.CorrespondenceIdleTimeout)
+	}
+	switch {
+	case state == SessionLobby && session.tournamentID != "":
+		return time.Duration(config.MatchStartTimeout)
+	case state == SessionLobby:
+		return time.Duration(config.LobbyIdleTimeout)
+	case state == SessionFinished:
+		return time.Duration(config.FinishedIdleTimeout)
+	default:
+		return time.Duration(config.IdleTimeout)
+	}
+}
+
+// expireSessions warns the clients of sessions that are about to expire and
+// removes the sessions that have, archiving the finished games and
+// forfeiting the tournament matches that were never won.
+func expireSessions(now time.Time) {
+	removed, archived := 0, 0
+	for _, session := range manager.list() {
+		expired := false
+		var game *ArchivedGame
+		session.call(func() error {
+			state := session.lifecycleState()
+			timeout := session.idleTimeout()
+			idle := now.Sub(session.lastActivity)
+			warning := time.Duration(config.IdleWarning)
+			switch {
+			case idle > timeout:
+				session.log.Info("Session inactive, cleaning up", "state", state, "idle", idle.String())
+				if state == SessionFinished {
+					archivedGame := session.archivedGame(now)
+					game = &archivedGame
+				}
+				reportForfeit(session)
+				session.closeClients()
+				expired = true
+			case warning > 0 && idle > timeout-warning && !session.warnedAt.Equal(session.lastActivity):
+				// Warn once per quiet spell; any activity starts a new one
+				session.warnedAt = session.lastActivity
+				expiresAt := session.lastActivity.Add(timeout)
+				session.broadcast(newServerMessage("sessionExpiring", SessionExpiringPayload{
+					SessionID: session.ID,
+					State:     state,
+					ExpiresAt: expiresAt,
+				}))
+				session.log.Debug("Warned of session expiry", "state", state, "expiresAt", expiresAt)
+			}
+			return nil
+		})
+		if !expired {
+			continue
+		}
+
+		manager.remove(session)
+		removed++
+		if game == nil {
+			session.log.Info("Removed inactive session")
+			continue
+		}
+		if err := archive.Save(*game); err != nil {
+			session.log.Error("Archiving session failed", "error", err)
+			continue
+		}
+		session.log.Info("Archived finished session")
+		archived++
+	}
+	if removed > 0 {
+		slog.Info("Cleaned up inactive sessions", "removed", removed, "archived", archived)
+	}
+}
+
+// ArchivedGame is the record of a finished game kept after its session is
+// removed. It holds what spectators could see, so it is safe to publish.
+type ArchivedGame struct {
+	ID           string        `json:"id"`
+	RoomCode     string        `json:"roomCode"`
+	State        string        `json:"state"`
+	Final        StatePayload  `json:"final"`
+	Chat         []ChatMessage `json:"chat"`
+	TournamentID string        `json:"tournamentId,omitempty"`
+	MatchID      string        `json:"matchId,omitempty"`
+	ArchivedAt   time.Time     `json:"archivedAt"`
+}
+
+// archivedGame returns the record of the finished game. It runs on the
+// session goroutine.
+func (session *GameSession) archivedGame(now time.Time) ArchivedGame {
+	return ArchivedGame{
+		ID:           session.ID,
+		RoomCode:     session.RoomCode,
+		State:        SessionArchived,
+		Final:        createStatePayload(session, ""),
+		Chat:         slices.Clone(session.chat.history),
+		TournamentID: session.tournamentID,
+		MatchID:      session.matchID,
+		ArchivedAt:   now,
+	}
+}
+
+// GameArchive keeps the records of finished games
+type GameArchive interface {
+	Save(game ArchivedGame) error
+	Load(id string) (ArchivedGame, error)
+}
+
+// archive keeps finished games once their sessions are removed: in
+// archiveDir when it is configured, and otherwise in memory until the
+// server stops.
+var archive GameArchive = newMemoryArchive()
+
+var errNotArchived = errors.New("game not archived")
+
+// memoryArchiveSize is how many games the memory archive keeps before it
+// forgets the oldest
+const memoryArchiveSize = 1000
+
+// memoryArchive keeps the most recently archived games in memory
+type memoryArchive struct {
+	mu    sync.Mutex
+	games map[string]ArchivedGame
+	order []string // IDs, oldest first
+}
+
+func newMemoryArchive() *memoryArchive {
+	return &memoryArchive{games: make(map[string]ArchivedGame)}
+}
+
+func (a *memoryArchive) Save(game ArchivedGame) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, exists := a.games[game.ID]; !exists {
+		a.order = append(a.order, game.ID)
+	}
+	a.games[game.ID] = game
+	if len(a.order) > memoryArchiveSize {
+		delete(a.games, a.order[0])
+		a.order = a.order[1:]
+	}
+	return nil
+}
+
+func (a *memoryArchive) Load(id string) (ArchivedGame, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	game, ok := a.games[id]
+	if !ok {
+		return game, errNotArchived
+	}
+	return game, nil
+}
+
+// fileArchive saves each game as a JSON file in dir
+type fileArchive struct {
+	dir string
+}
+
+func newFileArchive(dir string) (*fileArchive, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &fileArchive{dir: dir}, nil
+}
+
+func (a *fileArchive) Save(game ArchivedGame) error {
+	return writeJSONFile(a.dir, "game-"+game.ID+".json", game)
+}
+
+func (a *fileArchive) Load(id string) (ArchivedGame, error) {
+	var game ArchivedGame
+	if !validFileID(id) {
+		return game, errNotArchived
+	}
+	err := readJSONFile(filepath.Join(a.dir, "game-"+id+".json"), &game)
+	if errors.Is(err, os.ErrNotExist) {
+		return game, errNotArchived
+	}
+	return game, err
+}
+
+// handleGetArchivedGame returns the record of a finished game
+func handleGetArchivedGame(w http.ResponseWriter, r *http.Request) {
+	game, err := archive.Load(r.PathValue("id"))
+	if errors.Is(err, errNotArchived) {
+		writeAPIError(w, newProtocolError(CodeNotFound, "archived game not found"))
+		return
+	}
+	if err != nil {
+		slog.Error("Loading archived game failed", "error", err)
+		http.Error(w, "archive unavailable", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, game)
+}
+
+// validFileID reports whether id is safe to use in a file name
+func validFileID(id string) bool {
+	if id == "" {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= '0' && r <= '9' || r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
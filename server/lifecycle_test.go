@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// useLifecycleConfig gives the test its own copy of the config and archive
+func useLifecycleConfig(t *testing.T) {
+	t.Helper()
+	oldConfig, oldArchive := config, archive
+	cfg := *config
+	cfg.LobbyIdleTimeout = Duration(5 * time.Minute)
+	cfg.IdleTimeout = Duration(10 * time.Minute)
+	cfg.FinishedIdleTimeout = Duration(30 * time.Minute)
+	cfg.IdleWarning = Duration(time.Minute)
+	cfg.MatchStartTimeout = Duration(30 * time.Minute)
+	config, archive = &cfg, newMemoryArchive()
+	t.Cleanup(func() { config, archive = oldConfig, oldArchive })
+}
+
+// watchSession seats a spectator in the session and marks the session idle
+// since lastActivity.
+func watchSession(session *GameSession, lastActivity time.Time) *Client {
+	client := newClient()
+	session.call(func() error {
+		client.playerID, client.spectator = "watcher", true
+		session.Spectators[client.playerID] = client
+		session.lastActivity = lastActivity
+		return nil
+	})
+	return client
+}
+
+func received(client *Client, msgType string) int {
+	count := 0
+	for {
+		select {
+		case msg := <-client.send:
+			if msg.Type == msgType {
+				count++
+			}
+		default:
+			return count
+		}
+	}
+}
+
+func TestLifecycleState(t *testing.T) {
+	useLifecycleConfig(t)
+	session := newGameSession(2)
+	if got := session.lifecycleState(); got != SessionLobby {
+		t.Errorf("new session is %s, want lobby", got)
+	}
+	session.GameState.GameStarted = true
+	if got, timeout := session.lifecycleState(), session.idleTimeout(); got != SessionInProgress || timeout != 10*time.Minute {
+		t.Errorf("started session is %s with timeout %v, want inProgress and 10m", got, timeout)
+	}
+	session.ended = true
+	if got, timeout := session.lifecycleState(), session.idleTimeout(); got != SessionFinished || timeout != 30*time.Minute {
+		t.Errorf("ended session is %s with timeout %v, want finished and 30m", got, timeout)
+	}
+}
+
+func TestIdleSessionIsWarnedThenRemoved(t *testing.T) {
+	useLifecycleConfig(t)
+	session := createNewSession(2)
+	defer manager.remove(session)
+	now := time.Now()
+	client := watchSession(session, now.Add(-4*time.Minute-30*time.Second))
+
+	expireSessions(now)
+	var msg ServerMessage
+	select {
+	case msg = <-client.send:
+	default:
+		t.Fatal("no warning before the lobby timeout")
+	}
+	warning, _ := msg.Payload.(SessionExpiringPayload)
+	if msg.Type != "sessionExpiring" || warning.State != SessionLobby || !warning.ExpiresAt.Equal(now.Add(30*time.Second)) {
+		t.Errorf("sent %s %+v, want sessionExpiring for the lobby in 30s", msg.Type, warning)
+	}
+	expireSessions(now.Add(10 * time.Second))
+	if n := received(client, "sessionExpiring"); n != 0 {
+		t.Errorf("warned %d more times in the same quiet spell", n)
+	}
+
+	expireSessions(now.Add(time.Minute))
+	if _, ok := manager.find(session.ID); ok {
+		t.Error("idle lobby still hosted after its timeout")
+	}
+	select {
+	case <-client.done:
+	default:
+		t.Error("client still connected to the removed session")
+	}
+}
+
+func TestFinishedSessionIsArchived(t *testing.T) {
+	useLifecycleConfig(t)
+	fa, err := newFileArchive(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive = fa
+	session := createNewSession(2)
+	defer manager.remove(session)
+	now := time.Now()
+	watchSession(session, now.Add(-10*time.Minute))
+	session.call(func() error { session.ended = true; return nil })
+
+	expireSessions(now)
+	if _, ok := manager.find(session.ID); !ok {
+		t.Fatal("finished session removed before its own timeout")
+	}
+	expireSessions(now.Add(30 * time.Minute))
+	if _, ok := manager.find(session.ID); ok {
+		t.Fatal("finished session still hosted after its timeout")
+	}
+
+	game, err := archive.Load(session.ID)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if game.RoomCode != session.RoomCode || game.State != SessionArchived || game.Final.SessionID != session.ID {
+		t.Errorf("archived %+v, want the session's final state", game)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/archive/"+session.ID, nil)
+	r.SetPathValue("id", session.ID)
+	w := httptest.NewRecorder()
+	handleGetArchivedGame(w, r)
+	var served ArchivedGame
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &served) != nil || served.ID != session.ID {
+		t.Errorf("GET /archive/%s answered %d %s", session.ID, w.Code, w.Body)
+	}
+	if _, err := archive.Load("../" + session.ID); err != errNotArchived {
+		t.Errorf("loading a path outside the archive: %v, want errNotArchived", err)
+	}
+}
+
+func TestMemoryArchiveKeepsTheMostRecentGames(t *testing.T) {
+	a := newMemoryArchive()
+	for i := range memoryArchiveSize + 1 {
+		if err := a.Save(ArchivedGame{ID: strconv.Itoa(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := a.Load("0"); err != errNotArchived {
+		t.Errorf("loading the oldest game: %v, want errNotArchived", err)
+	}
+	if game, err := a.Load(strconv.Itoa(memoryArchiveSize)); err != nil || game.ID != strconv.Itoa(memoryArchiveSize) {
+		t.Errorf("loading the newest game: %+v, %v", game, err)
+	}
+}
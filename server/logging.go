@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// redactedKeys are attributes whose values are secret wherever they appear
+var redactedKeys = map[string]bool{
+	"token": true,
+	"hand":  true,
+	"ships": true,
+}
+
+// newLogger returns a logger writing JSON lines to w. Records below level,
+// one of debug, info, warn or error, are discarded.
+func newLogger(w io.Writer, level string) *slog.Logger {
+	var minLevel slog.Level
+	if err := minLevel.UnmarshalText([]byte(level)); err != nil {
+		minLevel = slog.LevelInfo
+	}
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level:       minLevel,
+		ReplaceAttr: redactAttr,
+	}))
+}
+
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+	if redactedKeys[a.Key] {
+		return slog.String(a.Key, "[redacted]")
+	}
+	return a
+}
+
+// fatal logs err and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// LogValue logs a client message's envelope. Payloads can hold cards from
+// the sender's hand or a rejoin token, so only their size is logged.
+func (m ClientMessage) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("id", m.ID),
+		slog.String("type", m.Type),
+		slog.Int("payloadBytes", len(m.Payload)),
+	)
+}
+
+// LogValue logs a server message's envelope. Payloads can hold a player's
+// private view of the game and are never logged.
+func (m ServerMessage) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("id", m.ID),
+		slog.String("replyTo", m.ReplyTo),
+		slog.String("type", m.Type),
+	)
+}
+
+// eventName returns the name of an engine event type, such as SalvoFired
+func eventName(event any) string {
+	name := fmt.Sprintf("%T", event)
+	return name[strings.LastIndex(name, ".")+1:]
+}
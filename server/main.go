@@ -2,12 +2,14 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
-	"log"
+	"errors"
+	"flag"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -16,23 +18,104 @@ import (
 )
 
 var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true // Allow all origins in development
-	},
+	CheckOrigin: checkOrigin,
+}
+
+// checkOrigin accepts websocket requests from the server's own origin, such
+// as the embedded frontend, and from the configured origins. Requests
+// without an Origin header do not come from a browser and are accepted too.
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range config.AllowedOrigins {
+		if originMatches(allowed, origin) {
+			return true
+		}
+	}
+	slog.Warn("Rejected websocket origin", "origin", origin, "remote", remoteIP(r))
+	return false
+}
+
+// originMatches reports whether origin is allowed by pattern. A pattern is
+// *, an exact origin, or an origin whose host starts with *. to allow any
+// subdomain.
+func originMatches(pattern, origin string) bool {
+	if pattern == "*" || strings.EqualFold(pattern, origin) {
+		return true
+	}
+	scheme, host, ok := strings.Cut(pattern, "://*.")
+	if !ok {
+		return false
+	}
+	originScheme, originHost, ok := strings.Cut(origin, "://")
+	return ok && strings.EqualFold(scheme, originScheme) &&
+		len(originHost) > len(host)+1 &&
+		strings.HasSuffix(strings.ToLower(originHost), "."+strings.ToLower(host))
 }
 
 type SessionManager struct {
 	sessions   map[string]*GameSession
+	codes      map[string]*GameSession // by room code
 	sessionsMu sync.RWMutex
 }
 
 var manager = &SessionManager{
 	sessions: make(map[string]*GameSession),
+	codes:    make(map[string]*GameSession),
 }
 
 func main() {
+	slog.SetDefault(newLogger(os.Stderr, "info"))
+	if len(os.Args) > 1 && os.Args[1] == "schema" {
+		if err := writeSchema(os.Stdout); err != nil {
+			fatal("Schema error", err)
+		}
+		return
+	}
+
+	cfg, err := loadConfig(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fatal("Configuration error", err)
+	}
+	config = cfg
+	slog.SetDefault(newLogger(os.Stderr, config.LogLevel))
+	if ruleset, err = loadRuleset(config.RulesetPath); err != nil {
+		fatal("Configuration error", err)
+	}
+	upgrader.ReadBufferSize = config.ReadBufferSize
+	upgrader.WriteBufferSize = config.WriteBufferSize
+	slog.Info("Effective configuration", "config", config)
+	unsubscribe, err := broker.Subscribe(relayTopic(config.InstanceID), handleRelayMessage)
+	if err != nil {
+		fatal("Broker error", err)
+	}
+	defer unsubscribe()
+	if config.ArchiveDir != "" {
+		fileArchive, err := newFileArchive(config.ArchiveDir)
+		if err != nil {
+			fatal("Archive error", err)
+		}
+		archive = fileArchive
+	}
+	if config.StoreDir != "" {
+		fileStore, err := newFileStore(config.StoreDir)
+		if err != nil {
+			fatal("Store error", err)
+		}
+		store = fileStore
+		if err := restoreSessions(); err != nil {
+			fatal("Store error", err)
+		}
+	}
+
 	// Set up cancellable context
 	ctx, cancel := context.WithCancel(context.Background())
 
@@ -40,14 +123,23 @@ func main() {
 	defer cancel()
 
 	// Create HTTP server with handlers
-	mux := http.NewServeMux()
-	mux.HandleFunc("/ws", handleWebSocket)
-	mux.HandleFunc("/sessions", handleListSessions)
+	mux, err := newServeMux()
+	if err != nil {
+		fatal("Frontend error", err)
+	}
 
 	server := &http.Server{
-		Addr:    ":8080",
+		Addr:    config.ListenAddr,
 		Handler: mux,
 	}
+	if config.TLSCertFile != "" {
+		certs, err := newCertReloader(config.TLSCertFile, config.TLSKeyFile)
+		if err != nil {
+			fatal("TLS error", err)
+		}
+		certs.reloadOnHangup()
+		server.TLSConfig = certs.tlsConfig()
+	}
 
 	// Start the session cleanup goroutine
 	cleanupInactiveSessions(ctx)
@@ -57,228 +149,295 @@ func main() {
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
 	// Goroutine to handle graceful shutdown
+	shutdownDone := make(chan struct{})
 	go func() {
+		defer close(shutdownDone)
 		<-sigChan
-		log.Println("Received shutdown signal, shutting down...")
+		slog.Info("Received shutdown signal, shutting down")
 
 		// Cancel context for cleanup goroutines
 		cancel()
 
-		// Create timeout context for server shutdown
-		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer shutdownCancel()
-
-		if err := server.Shutdown(shutdownCtx); err != nil {
-			log.Printf("HTTP server Shutdown error: %v", err)
-		}
+		drain(server)
 	}()
 
-	log.Println("Server starting on :8080")
-	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		log.Fatalf("Server error: %v", err)
+	ready.Store(true)
+	if server.TLSConfig != nil {
+		slog.Info("Server starting", "addr", config.ListenAddr, "tls", true)
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		slog.Info("Server starting", "addr", config.ListenAddr, "tls", false)
+		err = server.ListenAndServe()
 	}
+	if err != nil && err != http.ErrServerClosed {
+		fatal("Server error", err)
+	}
+	<-shutdownDone
+}
+
+// newServeMux returns the server's routes. It fails only when the frontend
+// is to be served and the build is missing.
+func newServeMux() (*http.ServeMux, error) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", handleWebSocket)
+	mux.HandleFunc("/sessions", handleListSessions)
+	mux.HandleFunc("GET /protocol/schema.json", handleProtocolSchema)
+	mux.HandleFunc("GET /metrics", handleMetrics)
+	mux.HandleFunc("GET /tournaments", handleListTournaments)
+	mux.HandleFunc("POST /tournaments", handleCreateTournament)
+	mux.HandleFunc("GET /tournaments/{id}", handleGetTournament)
+	mux.HandleFunc("POST /tournaments/{id}/entrants", handleRegisterEntrant)
+	mux.HandleFunc("POST /tournaments/{id}/start", handleStartTournament)
+	mux.HandleFunc("POST /games", limitRequests(handleAPICreateGame))
+	mux.HandleFunc("POST /games/{id}/players", limitRequests(handleAPIJoinGame))
+	mux.HandleFunc("GET /games/{id}", handleAPIGetGame)
+	mux.HandleFunc("POST /games/{id}/actions", limitRequests(handleAPIAction))
+	mux.HandleFunc("GET /games/{id}/events", handleEventStream)
+	mux.HandleFunc("GET /archive/{id}", handleGetArchivedGame)
+	mux.HandleFunc("GET /players/{name}/turns", handlePendingTurns)
+	mux.HandleFunc("GET /healthz", handleHealthz)
+	mux.HandleFunc("GET /readyz", handleReadyz)
+	mux.HandleFunc("GET /admin/sessions/{id}", requireAdmin(handleAdminGetSession))
+	mux.HandleFunc("POST /admin/sessions/{id}/end", requireAdmin(handleAdminEndSession))
+	mux.HandleFunc("DELETE /admin/sessions/{id}", requireAdmin(handleAdminDeleteSession))
+	mux.HandleFunc("POST /admin/sessions/{id}/players/{playerId}/kick", requireAdmin(handleAdminKickPlayer))
+	mux.HandleFunc("POST /admin/notice", requireAdmin(handleAdminNotice))
+	if config.ServeFrontend {
+		frontend, err := newFrontendHandler()
+		if err != nil {
+			return nil, err
+		}
+		// The frontend calls the API under /api, as the Vite dev server
+		// proxies it
+		mux.Handle("/api/", http.StripPrefix("/api", mux))
+		mux.Handle("/", frontend)
+	}
+	return mux, nil
 }
 
 func handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	release, ok := limitConnections(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
+	logger := slog.With("remote", remoteIP(r))
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Println(err)
+		logger.Info("Websocket upgrade failed", "error", err)
 		return
 	}
-	defer conn.Close()
+
+	connectedClients.Add(1)
+	defer connectedClients.Add(-1)
 
 	ctx := &SessionContext{
-		Conn:          conn,
-		CurrentPlayer: "",
-		Session:       nil,
+		Client: newClient(),
+		Log:    logger,
 	}
+	ctx.Client.log = logger
+	pumpDone := make(chan struct{})
+	go func() {
+		defer close(pumpDone)
+		writePump(conn, ctx.Client)
+	}()
+
+	handleWebSocketsLoop(ctx, conn)
 
-	handleWebSocketsLoop(ctx)
+	allClients.remove(ctx.Client)
+	ctx.Client.close()
+	if session := ctx.Session; session != nil {
+		session.post(func() { session.removeClient(ctx.Client) })
+	}
+	if ctx.Relay != nil {
+		ctx.Relay.disconnect()
+	}
+	// The connection counts as connected until its close frame is sent
+	<-pumpDone
 }
 
+// SessionContext is the state of a connection's read loop. Everything about
+// the client's seat in its session is owned by the session goroutine.
 type SessionContext struct {
-	Conn          *websocket.Conn
 	Session       *GameSession
-	CurrentPlayer string
+	Client        *Client
+	HandshakeDone bool
+	Features      map[string]bool
+	Relay         *relay       // set instead of Session for a session on another instance
+	Log           *slog.Logger // carries the session and player once joined
 }
 
-func handleWebSocketsLoop(ctx *SessionContext) {
+func handleWebSocketsLoop(ctx *SessionContext, conn *websocket.Conn) {
+	// Every pong pushes the read deadline back, so a peer that stops
+	// answering pings is dropped after the pong timeout.
+	pongTimeout := time.Duration(config.PongTimeout)
+	conn.SetReadLimit(config.MaxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	})
+
+	// Every frame takes a token, malformed or not, and a client that keeps
+	// sending over the limit is dropped.
+	limiter := newTokenBucket(config.MessageRate, config.MessageBurst)
+	overLimit := 0
 	for {
-		messageType, p, err := ctx.Conn.ReadMessage()
+		_, p, err := conn.ReadMessage()
 		if err != nil {
-			log.Println(err)
+			ctx.Log.Debug("Connection closed", "error", err)
 			return
 		}
 
-		var clientMsg ClientMessage
-		if err := json.Unmarshal(p, &clientMsg); err != nil {
-			log.Println(err)
-			continue
+		allowed := limiter.allow(time.Now())
+		if allowed {
+			overLimit = 0
+		} else if overLimit++; config.MaxRateLimited > 0 && overLimit > config.MaxRateLimited {
+			ctx.Log.Warn("Closing connection: too many messages over the rate limit")
+			ctx.Client.closeWith(websocket.ClosePolicyViolation, "too many messages over the rate limit")
+			return
 		}
 
-		fmt.Println("Client message:", clientMsg)
-
-		if ctx.Session == nil {
-			if err := setupSession(ctx, clientMsg, p); err != nil {
-				sendError(ctx.Conn, err.Error())
+		clientMsg, err := decodeClientMessage(p)
+		if !allowed {
+			err = newProtocolError(CodeRateLimited, "you are sending messages too quickly")
+			if ctx.HandshakeDone {
+				sendError(ctx.Client, clientMsg, err)
 				continue
 			}
 		}
-		updateSessionActivity(ctx.Session)
-    registerClient(ctx)
-    processClientMessage(ctx, clientMsg, p)
-		broadcastGameState(ctx, messageType)
-	}
-}
-
-func processClientMessage(ctx *SessionContext, msg ClientMessage, p []byte) {
-	handleMessage(ctx.Session, msg, p)
-}
-
-func registerClient(ctx *SessionContext) {
-	ctx.Session.mu.Lock()
-	ctx.Session.Clients[ctx.CurrentPlayer] = ctx.Conn
-	ctx.Session.mu.Unlock()
-}
+		if !ctx.HandshakeDone {
+			if err == nil {
+				err = handleHello(ctx, clientMsg)
+			}
+			if err != nil {
+				ctx.Log.Info("Handshake failed", "error", err)
+				rejectClient(ctx.Client, clientMsg, err)
+				return
+			}
+			continue
+		}
+		if err != nil {
+			sendError(ctx.Client, clientMsg, err)
+			continue
+		}
 
-func setupSession(ctx *SessionContext, clientMsg ClientMessage, payload []byte) error {
-	switch clientMsg.Action {
-	case "createGame":
-		return handleCreateGame(ctx, payload)
-	case "joinGame":
-		return handleJoinGame(ctx, payload)
-	default:
-		return fmt.Errorf("invalid action")
+		if err := handleClientMessage(ctx, clientMsg); err != nil {
+			sendError(ctx.Client, clientMsg, err)
+		}
 	}
 }
 
-func handleCreateGame(ctx *SessionContext, payload []byte) error {
-	var createMsg CreateGameMessage
-	if err := json.Unmarshal(payload, &createMsg); err != nil {
-		return fmt.Errorf("invalid create game message")
+// handleClientMessage routes a decoded client message to the session
+// goroutine. Joining waits for the session's answer so later messages know
+// which session to go to; everything else is answered by the session.
+func handleClientMessage(ctx *SessionContext, msg ClientMessage) error {
+	switch msg.Type {
+	case "createGame", "joinGame", "rejoinGame", "spectateGame":
+		if ctx.Session != nil {
+			return newProtocolError(CodeBadRequest, "already in game session %s", ctx.Session.ID)
+		}
+		if ctx.Relay != nil {
+			return newProtocolError(CodeBadRequest, "already in game session %s", ctx.Relay.sessionID)
+		}
+		if owner := sessionOwner(msg); owner != "" {
+			return relayJoin(ctx, owner, msg)
+		}
+		session, err := findOrCreateSession(msg)
+		if err != nil {
+			return err
+		}
+		if err := session.call(func() error { return session.handleClientMessage(ctx.Client, msg) }); err != nil {
+			return err
+		}
+		ctx.Session = session
+		ctx.Log = ctx.Log.With("session", session.ID, "player", ctx.Client.playerID)
+		return nil
 	}
-  log.Printf("Create game %d", createMsg.NumPlayers)
-	ctx.Session = createNewSession(createMsg.NumPlayers)
-	ctx.CurrentPlayer = "1"
-	ctx.Session.GameState.Players = append(ctx.Session.GameState.Players, newPlayer(ctx.CurrentPlayer, createMsg.PlayerName))
-	sendGameStarted(ctx.Conn, ctx.Session)
-	return nil
-}
 
-func handleJoinGame(ctx *SessionContext, payload []byte) error {
-	var joinMsg JoinGameMessage
-	if err := json.Unmarshal(payload, &joinMsg); err != nil {
-		return fmt.Errorf("invalid join game message")
+	if ctx.Relay != nil {
+		return ctx.Relay.forward(msg)
 	}
-	manager.sessionsMu.RLock()
-	session, exists := manager.sessions[joinMsg.SessionID]
-	manager.sessionsMu.RUnlock()
-	if !exists {
-		return fmt.Errorf("game session not found")
+	if ctx.Session == nil {
+		return newProtocolError(CodeBadRequest, "create or join a game before sending %s", msg.Type)
 	}
-	if len(session.GameState.Players) >= session.NumberOfPlayers {
-		return fmt.Errorf("game is full")
+	session, client := ctx.Session, ctx.Client
+	posted := session.post(func() {
+		if err := session.handleClientMessage(client, msg); err != nil {
+			sendError(client, msg, err)
+		}
+	})
+	if !posted {
+		return errSessionClosed
 	}
-	ctx.Session = session
-	ctx.CurrentPlayer = fmt.Sprintf("%d", len(session.GameState.Players)+1)
-	session.GameState.Players = append(session.GameState.Players, newPlayer(ctx.CurrentPlayer, joinMsg.PlayerName))
-	sendGameStarted(ctx.Conn, ctx.Session)
 	return nil
 }
 
-func broadcastGameState(ctx *SessionContext, messageType int) {
-	serverMsg := createServerMessage(ctx.Session, ctx.CurrentPlayer)
-	fmt.Println("Server message:", serverMsg)
-
-	response, err := json.Marshal(serverMsg)
-	if err != nil {
-		log.Println(err)
-		return
-	}
+// rejectClient reports a failed handshake and closes the connection
+func rejectClient(client *Client, msg ClientMessage, err error) {
+	sendError(client, msg, err)
+	client.closeWith(websocket.CloseProtocolError, err.Error())
+}
 
-	ctx.Session.mu.RLock()
-	for id, client := range ctx.Session.Clients {
-		if err := client.WriteMessage(messageType, response); err != nil {
-			log.Printf("Write to client %s failed: %v", id, err)
-			client.Close()
-			delete(ctx.Session.Clients, id)
+// findOrCreateSession returns the session a createGame, joinGame,
+// rejoinGame or spectateGame message refers to.
+func findOrCreateSession(msg ClientMessage) (*GameSession, error) {
+	if msg.Type == "createGame" {
+		var createMsg CreateGamePayload
+		if err := decodePayload(msg, &createMsg); err != nil {
+			return nil, err
+		}
+		if createMsg.NumberOfPlayers < 2 || createMsg.NumberOfPlayers > 4 {
+			return nil, newProtocolError(CodeBadRequest, "number of players must be between 2 and 4")
 		}
+		if draining.Load() {
+			return nil, errShuttingDown
+		}
+		if config.MaxSessions > 0 && manager.count() >= config.MaxSessions {
+			return nil, newProtocolError(CodeRateLimited, "the server is hosting the maximum number of games, try again later")
+		}
+		session := newGameSession(createMsg.NumberOfPlayers)
+		if createMsg.Correspondence || createMsg.TurnHours != 0 {
+			turnDeadline, err := correspondenceTurnDeadline(createMsg)
+			if err != nil {
+				return nil, err
+			}
+			session.turnDeadline = turnDeadline
+		}
+		if createMsg.Webhook != nil {
+			if err := validateSessionWebhook(*createMsg.Webhook); err != nil {
+				return nil, err
+			}
+			session.webhook = createMsg.Webhook
+		}
+		registerSession(session)
+		return session, nil
 	}
-	ctx.Session.mu.RUnlock()
-}
 
-func newPlayer(id, name string) Player {
-	return Player{
-		ID:              id,
-		Name:            name,
-		Ships:           []ShipCard{},
-		Hand:            []SalvoCard{},
-		PlayedShips:     []ShipCard{},
-		DiscardedSalvos: []SalvoCard{},
-		DeepSixPile:     []ShipCard{},
+	var joinMsg JoinGamePayload
+	if err := decodePayload(msg, &joinMsg); err != nil {
+		return nil, err
 	}
-}
-
-func updateSessionActivity(session *GameSession) {
-	session.mu.Lock()
-	session.lastActivity = time.Now()
-	session.mu.Unlock()
-}
-
-type sessionWithID struct {
-	id      string
-	session *GameSession
+	session, exists := manager.find(joinMsg.SessionID)
+	if !exists {
+		return nil, newProtocolError(CodeNotFound, "game session not found")
+	}
+	return session, nil
 }
 
 func cleanupInactiveSessions(ctx context.Context) {
-	ticker := time.NewTicker(1 * time.Minute)
+	ticker := time.NewTicker(time.Duration(config.CleanupInterval))
 	go func() {
 		defer ticker.Stop()
 		for {
 			select {
 			case <-ticker.C:
-				var inactive []sessionWithID
-				manager.sessionsMu.RLock()
-				for id, session := range manager.sessions {
-					session.mu.RLock()
-					if time.Since(session.lastActivity) > 5*time.Minute {
-						log.Printf("Session %s inactive for %v, cleaning up", id, time.Since(session.lastActivity))
-						inactive = append(inactive, sessionWithID{id, session})
-					}
-					session.mu.RUnlock()
-				}
-				manager.sessionsMu.RUnlock()
-
-				manager.sessionsMu.Lock()
-				for _, s := range inactive {
-					s.session.mu.Lock()
-					for _, client := range s.session.Clients {
-						if err := client.Close(); err != nil {
-							log.Printf("Error closing client in session %s: %v", s.id, err)
-						}
-					}
-					s.session.Clients = make(map[string]*websocket.Conn)
-					s.session.mu.Unlock()
-
-					delete(manager.sessions, s.id)
-					log.Printf("Removed inactive session: %s", s.id)
-				}
-				manager.sessionsMu.Unlock()
-				if len(inactive) > 0 {
-					log.Printf("Cleaned up %d inactive session(s)", len(inactive))
-				}
+				expireTurns(time.Now())
+				expireSessions(time.Now())
 			case <-ctx.Done():
-				log.Println("Stopped session cleanup")
+				slog.Info("Stopped session cleanup")
 				return
 			}
 		}
 	}()
 }
-
-func sendError(conn *websocket.Conn, errorMsg string) {
-	response, _ := json.Marshal(ServerMessage{
-		MessageType: "error",
-		Error:       errorMsg,
-	})
-	conn.WriteMessage(websocket.TextMessage, response)
-}
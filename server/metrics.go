@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// The server's metrics, served at /metrics in the Prometheus text
+// exposition format.
+var (
+	connectedClients   atomic.Int64
+	gamesStarted       = &counter{}
+	gamesFinished      = &counter{}
+	actionsProcessed   = newCounterVec("type")
+	actionsRejected    = newCounterVec("reason")
+	bytesSent          = &counter{}
+	webhookDeliveries  = newCounterVec("result")
+	broadcastDurations = newHistogram(0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1)
+
+	metrics = []metric{
+		{"salvo_active_sessions", "Game sessions currently hosted.", gaugeFunc(func() float64 { return float64(manager.count()) })},
+		{"salvo_connected_clients", "Open websocket and event stream connections.", gaugeFunc(func() float64 { return float64(connectedClients.Load()) })},
+		{"salvo_games_started_total", "Games started.", gamesStarted},
+		{"salvo_games_finished_total", "Games played to a winner.", gamesFinished},
+		{"salvo_actions_total", "Client messages processed successfully, by message type.", actionsProcessed},
+		{"salvo_actions_rejected_total", "Client messages rejected, by error code.", actionsRejected},
+		{"salvo_broadcast_duration_seconds", "Time taken to send a game state update to a session's clients.", broadcastDurations},
+		{"salvo_sent_bytes_total", "Bytes of messages written to clients over websockets and event streams.", bytesSent},
+		{"salvo_webhook_deliveries_total", "Webhook deliveries by result: delivered, failed, or retried for every failed attempt tried again.", webhookDeliveries},
+	}
+)
+
+type metric struct {
+	name  string
+	help  string
+	value metricValue
+}
+
+type metricValue interface {
+	metricType() string
+	writeSamples(w io.Writer, name string)
+}
+
+// counter is a value that only goes up
+type counter struct {
+	value atomic.Uint64
+}
+
+func (c *counter) inc() {
+	c.value.Add(1)
+}
+
+func (c *counter) add(n uint64) {
+	c.value.Add(n)
+}
+
+func (c *counter) metricType() string {
+	return "counter"
+}
+
+func (c *counter) writeSamples(w io.Writer, name string) {
+	fmt.Fprintf(w, "%s %d\n", name, c.value.Load())
+}
+
+// counterVec is a set of counters told apart by the value of one label
+type counterVec struct {
+	label  string
+	mu     sync.Mutex
+	values map[string]uint64
+}
+
+func newCounterVec(label string) *counterVec {
+	return &counterVec{label: label, values: make(map[string]uint64)}
+}
+
+func (v *counterVec) inc(labelValue string) {
+	v.mu.Lock()
+	v.values[labelValue]++
+	v.mu.Unlock()
+}
+
+func (v *counterVec) metricType() string {
+	return "counter"
+}
+
+func (v *counterVec) writeSamples(w io.Writer, name string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	labelValues := make([]string, 0, len(v.values))
+	for labelValue := range v.values {
+		labelValues = append(labelValues, labelValue)
+	}
+	slices.Sort(labelValues)
+	for _, labelValue := range labelValues {
+		fmt.Fprintf(w, "%s{%s=\"%s\"} %d\n", name, v.label, escapeLabelValue(labelValue), v.values[labelValue])
+	}
+}
+
+// gaugeFunc is a value sampled when the metrics are scraped
+type gaugeFunc func() float64
+
+func (g gaugeFunc) metricType() string {
+	return "gauge"
+}
+
+func (g gaugeFunc) writeSamples(w io.Writer, name string) {
+	fmt.Fprintf(w, "%s %s\n", name, formatFloat(g()))
+}
+
+// histogram counts observations into buckets with the given upper bounds
+type histogram struct {
+	bounds []float64
+	mu     sync.Mutex
+	counts []uint64 // one per bound, not cumulative
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds ...float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (h *histogram) observe(value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if i, _ := slices.BinarySearch(h.bounds, value); i < len(h.bounds) {
+		h.counts[i]++
+	}
+	h.sum += value
+	h.count++
+}
+
+func (h *histogram) metricType() string {
+	return "histogram"
+}
+
+func (h *histogram) writeSamples(w io.Writer, name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, formatFloat(bound), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", name, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+}
+
+func writeMetrics(w io.Writer, metrics []metric) {
+	for _, m := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n", m.name, m.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.value.metricType())
+		m.value.writeSamples(w, m.name)
+	}
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeMetrics(w, metrics)
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func escapeLabelValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"game-server/engine"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	requests := &counter{}
+	requests.add(3)
+	byType := newCounterVec("type")
+	byType.inc("fireSalvo")
+	byType.inc("drawShip")
+	byType.inc("fireSalvo")
+	byType.inc(`quote"back\slash`)
+	latency := newHistogram(0.1, 1)
+	latency.observe(0.05)
+	latency.observe(0.5)
+	latency.observe(5)
+
+	var b strings.Builder
+	writeMetrics(&b, []metric{
+		{"test_requests_total", "Requests.", requests},
+		{"test_actions_total", "Actions by type.", byType},
+		{"test_temperature", "Current temperature.", gaugeFunc(func() float64 { return 21.5 })},
+		{"test_latency_seconds", "Latency.", latency},
+	})
+
+	want := `# HELP test_requests_total Requests.
+# TYPE test_requests_total counter
+test_requests_total 3
+# HELP test_actions_total Actions by type.
+# TYPE test_actions_total counter
+test_actions_total{type="drawShip"} 1
+test_actions_total{type="fireSalvo"} 2
+test_actions_total{type="quote\"back\\slash"} 1
+# HELP test_temperature Current temperature.
+# TYPE test_temperature gauge
+test_temperature 21.5
+# HELP test_latency_seconds Latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{le="0.1"} 1
+test_latency_seconds_bucket{le="1"} 2
+test_latency_seconds_bucket{le="+Inf"} 3
+test_latency_seconds_sum 5.55
+test_latency_seconds_count 3
+`
+	if got := b.String(); got != want {
+		t.Errorf("writeMetrics output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestHistogramBucketBoundsAreInclusive(t *testing.T) {
+	h := newHistogram(1, 2)
+	h.observe(1)
+	h.observe(2)
+	h.observe(2.5)
+
+	if h.counts[0] != 1 || h.counts[1] != 1 {
+		t.Errorf("bucket counts = %v, want [1 1]", h.counts)
+	}
+	if h.count != 3 {
+		t.Errorf("count = %d, want 3", h.count)
+	}
+}
+
+func TestHandleMetrics(t *testing.T) {
+	rec := httptest.NewRecorder()
+	handleMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", got)
+	}
+	types := map[string]string{}
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) == 4 && fields[1] == "TYPE" {
+			types[fields[2]] = fields[3]
+		}
+	}
+	want := map[string]string{
+		"salvo_active_sessions":            "gauge",
+		"salvo_connected_clients":          "gauge",
+		"salvo_games_started_total":        "counter",
+		"salvo_games_finished_total":       "counter",
+		"salvo_actions_total":              "counter",
+		"salvo_actions_rejected_total":     "counter",
+		"salvo_broadcast_duration_seconds": "histogram",
+		"salvo_sent_bytes_total":           "counter",
+	}
+	for name, typ := range want {
+		if types[name] != typ {
+			t.Errorf("%s has type %q, want %q", name, types[name], typ)
+		}
+	}
+}
+
+func TestGameMetrics(t *testing.T) {
+	session := createNewSession(2)
+	defer manager.remove(session)
+
+	started := gamesStarted.value.Load()
+	rejected := rejectedCount(CodeNotYourTurn)
+	broadcasts := broadcastCount()
+
+	client := newClient()
+	err := session.call(func() error {
+		for _, name := range []string{"Alice", "Bob"} {
+			if err := applyAction(session, engine.Join{Name: name}); err != nil {
+				return err
+			}
+		}
+		if err := applyAction(session, engine.Start{Seed: 1}); err != nil {
+			return err
+		}
+		session.broadcastGameState()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sendError(client, ClientMessage{Type: "drawSalvo"}, gameError(engine.ErrNotYourTurn))
+
+	if got := gamesStarted.value.Load() - started; got != 1 {
+		t.Errorf("games started increased by %d, want 1", got)
+	}
+	if got := rejectedCount(CodeNotYourTurn) - rejected; got != 1 {
+		t.Errorf("not_your_turn rejections increased by %d, want 1", got)
+	}
+	if got := broadcastCount() - broadcasts; got != 1 {
+		t.Errorf("broadcasts observed increased by %d, want 1", got)
+	}
+}
+
+func rejectedCount(code string) uint64 {
+	actionsRejected.mu.Lock()
+	defer actionsRejected.mu.Unlock()
+	return actionsRejected.values[code]
+}
+
+func broadcastCount() uint64 {
+	broadcastDurations.mu.Lock()
+	defer broadcastDurations.mu.Unlock()
+	return broadcastDurations.count
+}
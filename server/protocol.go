@@ -0,0 +1,378 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ProtocolVersion is bumped whenever a change to the message envelope or
+// payloads would break existing clients.
+const ProtocolVersion = 1
+
+// Error codes carried by error payloads
+const (
+	CodeIncompatibleProtocol = "incompatible_protocol"
+	CodeHandshakeRequired    = "handshake_required"
+	CodeBadRequest           = "bad_request"
+	CodeUnknownType          = "unknown_type"
+	CodeNotFound             = "not_found"
+	CodeGameFull             = "game_full"
+	CodeForbidden            = "forbidden"
+	CodeRateLimited          = "rate_limited"
+	CodeMissingID            = "missing_id"
+	CodeWaitingForPlayers    = "waiting_for_players"
+	CodeGameAlreadyStarted   = "game_already_started"
+	CodeGameNotStarted       = "game_not_started"
+	CodeNotYourTurn          = "not_your_turn"
+	CodeDeckEmpty            = "deck_empty"
+	CodeCardNotInHand        = "card_not_in_hand"
+	CodeNoMatchingShip       = "no_matching_ship"
+	CodeTargetNotFound       = "target_not_found"
+	CodeShuttingDown         = "shutting_down"
+)
+
+// ClientMessage is the envelope of every message sent by a client. The
+// payload is decoded once Type is known, and the ID is echoed in the ack or
+// error that answers the message.
+type ClientMessage struct {
+	Version int             `json:"v"`
+	ID      string          `json:"id"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// ServerMessage is the envelope of every message sent by the server.
+// Replies to a client message carry the client's message ID in ReplyTo.
+type ServerMessage struct {
+	Version int    `json:"v"`
+	ID      string `json:"id"`
+	ReplyTo string `json:"replyTo,omitempty"`
+	Type    string `json:"type"`
+	Payload any    `json:"payload,omitempty"`
+}
+
+// Client payloads
+
+type HelloPayload struct {
+	ProtocolVersion int      `json:"protocolVersion"`
+	Client          string   `json:"client,omitempty"`
+	Features        []string `json:"features,omitempty"`
+}
+
+// CreateGamePayload creates a game. Correspondence games are played over
+// days: each player has TurnHours for their turn, or the server's turn
+// deadline when it is zero, before the turn passes to the next player.
+// Webhook, when set, is sent the game's turn and game events.
+type CreateGamePayload struct {
+	NumberOfPlayers int            `json:"numberOfPlayers"`
+	PlayerName      string         `json:"playerName"`
+	Correspondence  bool           `json:"correspondence,omitempty"`
+	TurnHours       int            `json:"turnHours,omitempty"`
+	Webhook         *WebhookTarget `json:"webhook,omitempty"`
+	PlayerKey       string         `json:"playerKey,omitempty"`
+}
+
+// JoinGamePayload names the session by its ID or its room code. Seats in a
+// tournament match also need the entrant's token from their registration,
+// and a correspondence player sends the player key from an earlier game to
+// keep listing all their games under it.
+type JoinGamePayload struct {
+	SessionID    string `json:"sessionId"`
+	PlayerName   string `json:"playerName"`
+	EntrantToken string `json:"entrantToken,omitempty"`
+	PlayerKey    string `json:"playerKey,omitempty"`
+}
+
+// RejoinGamePayload reclaims a seat after a dropped connection using the
+// token sent in the original joined message.
+type RejoinGamePayload struct {
+	SessionID string `json:"sessionId"`
+	PlayerID  string `json:"playerId"`
+	Token     string `json:"token"`
+}
+
+type EmptyPayload struct{}
+
+type FireSalvoPayload struct {
+	Salvo  SalvoCard `json:"salvo"`
+	Target ShipCard  `json:"target"`
+}
+
+type DiscardSalvoPayload struct {
+	Salvo SalvoCard `json:"salvo"`
+}
+
+type ChatPayload struct {
+	Channel string `json:"channel,omitempty"`
+	Text    string `json:"text,omitempty"`
+	Signal  string `json:"signal,omitempty"`
+}
+
+// Server payloads
+
+type WelcomePayload struct {
+	ProtocolVersion int      `json:"protocolVersion"`
+	Server          string   `json:"server"`
+	Features        []string `json:"features"`
+}
+
+// JoinedPayload confirms a seat. The room code is the name to share with
+// other players. Players also get the token they need to rejoin the game if
+// their connection drops.
+type JoinedPayload struct {
+	SessionID string `json:"sessionId"`
+	RoomCode  string `json:"roomCode"`
+	PlayerID  string `json:"playerId"`
+	Spectator bool   `json:"spectator"`
+	Token     string `json:"token,omitempty"`
+	PlayerKey string `json:"playerKey,omitempty"`
+}
+
+type GameStartedPayload struct {
+	SessionID string `json:"sessionId"`
+}
+
+// StatePayload is a player's view of the game. In correspondence games
+// TurnDeadline is when the current player's turn passes if they have not
+// played.
+type StatePayload struct {
+	SessionID     string     `json:"sessionId"`
+	GameState     *GameState `json:"gameState"`
+	ShipDeckCount int        `json:"shipDeckCount"`
+	PlayDeckCount int        `json:"playDeckCount"`
+	DiscardCount  int        `json:"discardCount"`
+	TurnDeadline  *time.Time `json:"turnDeadline,omitempty"`
+}
+
+// PresencePayload announces that a player's connection dropped or came back
+type PresencePayload struct {
+	PlayerID string `json:"playerId"`
+	Name     string `json:"name"`
+}
+
+// SessionEndedPayload tells the clients that an administrator ended their
+// game.
+type SessionEndedPayload struct {
+	SessionID string `json:"sessionId"`
+	Reason    string `json:"reason"`
+}
+
+// SessionExpiringPayload warns that the session will be removed at
+// ExpiresAt unless there is activity before then. State is the session's
+// lifecycle state, which decides its idle timeout.
+type SessionExpiringPayload struct {
+	SessionID string    `json:"sessionId"`
+	State     string    `json:"state"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// TurnMissedPayload announces that a correspondence player let their turn
+// deadline pass and the turn went to the next player.
+type TurnMissedPayload struct {
+	SessionID string    `json:"sessionId"`
+	PlayerID  string    `json:"playerId"`
+	Deadline  time.Time `json:"deadline"`
+}
+
+// ServerNoticePayload is an announcement from the server's operators to
+// every connection.
+type ServerNoticePayload struct {
+	Message string `json:"message"`
+}
+
+// ServerShutdownPayload warns that the server is shutting down. Games in
+// progress may finish the current turn until the deadline; they are then
+// saved and can be rejoined once the server is back.
+type ServerShutdownPayload struct {
+	Deadline time.Time `json:"deadline"`
+}
+
+type ChatMessagesPayload struct {
+	Messages []ChatMessage `json:"messages"`
+}
+
+type AckPayload struct {
+	Type string `json:"type"`
+}
+
+type ErrorPayload struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// clientPayloads and serverPayloads map every message type to its payload
+// and are the source of the published JSON Schema.
+var clientPayloads = map[string]any{
+	"hello":        HelloPayload{},
+	"createGame":   CreateGamePayload{},
+	"joinGame":     JoinGamePayload{},
+	"rejoinGame":   RejoinGamePayload{},
+	"spectateGame": JoinGamePayload{},
+	"startGame":    EmptyPayload{},
+	"drawSalvo":    EmptyPayload{},
+	"drawShip":     EmptyPayload{},
+	"fireSalvo":    FireSalvoPayload{},
+	"discardSalvo": DiscardSalvoPayload{},
+	"chat":         ChatPayload{},
+	"resync":       EmptyPayload{},
+}
+
+var serverPayloads = map[string]any{
+	"welcome":            WelcomePayload{},
+	"ack":                AckPayload{},
+	"joined":             JoinedPayload{},
+	"playerDisconnected": PresencePayload{},
+	"playerReconnected":  PresencePayload{},
+	"gameStarted":        GameStartedPayload{},
+	"state":              StatePayload{},
+	"snapshot":           SnapshotPayload{},
+	"patch":              PatchPayload{},
+	"chat":               ChatMessagesPayload{},
+	"sessionEnded":       SessionEndedPayload{},
+	"sessionExpiring":    SessionExpiringPayload{},
+	"turnMissed":         TurnMissedPayload{},
+	"serverNotice":       ServerNoticePayload{},
+	"serverShutdown":     ServerShutdownPayload{},
+	"error":              ErrorPayload{},
+}
+
+// ProtocolError is an error reported to the client with a machine readable
+// code.
+type ProtocolError struct {
+	Code    string
+	Message string
+}
+
+func (e *ProtocolError) Error() string {
+	return e.Message
+}
+
+func newProtocolError(code, format string, args ...any) *ProtocolError {
+	return &ProtocolError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// asProtocolError returns err as a ProtocolError. Errors without a code are
+// reported as bad requests.
+func asProtocolError(err error) *ProtocolError {
+	var protocolErr *ProtocolError
+	if !errors.As(err, &protocolErr) {
+		protocolErr = &ProtocolError{Code: CodeBadRequest, Message: err.Error()}
+	}
+	return protocolErr
+}
+
+var lastServerMessageID atomic.Uint64
+
+func newServerMessage(msgType string, payload any) ServerMessage {
+	return ServerMessage{
+		Version: ProtocolVersion,
+		ID:      strconv.FormatUint(lastServerMessageID.Add(1), 10),
+		Type:    msgType,
+		Payload: payload,
+	}
+}
+
+// newReply creates a server message answering the client message msg
+func newReply(msg ClientMessage, msgType string, payload any) ServerMessage {
+	reply := newServerMessage(msgType, payload)
+	reply.ReplyTo = msg.ID
+	return reply
+}
+
+func decodePayload(msg ClientMessage, v any) error {
+	if len(msg.Payload) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(msg.Payload, v); err != nil {
+		return newProtocolError(CodeBadRequest, "invalid %s payload", msg.Type)
+	}
+	return nil
+}
+
+// decodeClientMessage parses the envelope and checks it against the
+// protocol version and the known message types.
+func decodeClientMessage(p []byte) (ClientMessage, error) {
+	var msg ClientMessage
+	if err := json.Unmarshal(p, &msg); err != nil {
+		return msg, newProtocolError(CodeBadRequest, "malformed message")
+	}
+	if msg.Version != ProtocolVersion {
+		return msg, newProtocolError(CodeIncompatibleProtocol, "unsupported protocol version %d, server speaks version %d", msg.Version, ProtocolVersion)
+	}
+	if _, ok := clientPayloads[msg.Type]; !ok {
+		return msg, newProtocolError(CodeUnknownType, "unknown message type %q", msg.Type)
+	}
+	if msg.ID == "" {
+		return msg, newProtocolError(CodeMissingID, "%s message has no id", msg.Type)
+	}
+	return msg, nil
+}
+
+// handleHello performs the handshake every connection must start with
+func handleHello(ctx *SessionContext, msg ClientMessage) error {
+	if msg.Type != "hello" {
+		return newProtocolError(CodeHandshakeRequired, "expected hello, got %q", msg.Type)
+	}
+	var hello HelloPayload
+	if err := decodePayload(msg, &hello); err != nil {
+		return err
+	}
+	if hello.ProtocolVersion != ProtocolVersion {
+		return newProtocolError(CodeIncompatibleProtocol, "client speaks protocol version %d, server speaks version %d", hello.ProtocolVersion, ProtocolVersion)
+	}
+	ctx.HandshakeDone = true
+
+	// Accept the optional features this server supports
+	ctx.Features = make(map[string]bool)
+	accepted := []string{}
+	for _, feature := range hello.Features {
+		if feature == FeatureDelta && !ctx.Features[feature] {
+			ctx.Features[feature] = true
+			accepted = append(accepted, feature)
+		}
+	}
+	if ctx.Features[FeatureDelta] {
+		ctx.Client.deltas = &deltaStream{}
+	}
+	ctx.Client.enqueue(newReply(msg, "welcome", WelcomePayload{
+		ProtocolVersion: ProtocolVersion,
+		Server:          "salvo",
+		Features:        accepted,
+	}))
+	allClients.add(ctx.Client)
+	return nil
+}
+
+func writeServerMessage(conn *websocket.Conn, msg ServerMessage) error {
+	response, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, response); err != nil {
+		return err
+	}
+	bytesSent.add(uint64(len(response)))
+	return nil
+}
+
+func sendAck(client *Client, msg ClientMessage) {
+	actionsProcessed.inc(msg.Type)
+	client.enqueue(newReply(msg, "ack", AckPayload{Type: msg.Type}))
+}
+
+// sendError reports err to the client. Errors that are not a ProtocolError
+// are reported as bad requests.
+func sendError(client *Client, msg ClientMessage, err error) {
+	protocolErr := asProtocolError(err)
+	actionsRejected.inc(protocolErr.Code)
+	client.enqueue(newReply(msg, "error", ErrorPayload{
+		Code:    protocolErr.Code,
+		Message: protocolErr.Message,
+	}))
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"game-server/internal/harness"
+	"github.com/gorilla/websocket"
+)
+
+func TestDecodeClientMessage(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		frame string
+		code  string
+	}{
+		{"valid", `{"v": 1, "id": "1", "type": "drawSalvo"}`, ""},
+		{"malformed", `{"v": 1,`, CodeBadRequest},
+		{"unsupported version", `{"v": 2, "id": "1", "type": "drawSalvo"}`, CodeIncompatibleProtocol},
+		{"no version", `{"id": "1", "type": "drawSalvo"}`, CodeIncompatibleProtocol},
+		{"unknown type", `{"v": 1, "id": "1", "type": "cheat"}`, CodeUnknownType},
+		{"no id", `{"v": 1, "type": "drawSalvo"}`, CodeMissingID},
+	} {
+		_, err := decodeClientMessage([]byte(test.frame))
+		code := ""
+		if err != nil {
+			code = asProtocolError(err).Code
+		}
+		if code != test.code {
+			t.Errorf("%s: got %q, want %q", test.name, code, test.code)
+		}
+	}
+}
+
+func TestHandshakeRequired(t *testing.T) {
+	srv := startServer(t)
+	alice := srv.Dial("Alice")
+	alice.Reject("createGame", map[string]any{"numberOfPlayers": 2, "playerName": "Alice"}, CodeHandshakeRequired)
+	if code := alice.ExpectClosed(); code != websocket.CloseProtocolError {
+		t.Errorf("closed with %d, want %d", code, websocket.CloseProtocolError)
+	}
+}
+
+func TestHandshakeUnsupportedVersion(t *testing.T) {
+	srv := startServer(t)
+	alice := srv.Dial("Alice")
+	alice.Reject("hello", map[string]any{"protocolVersion": ProtocolVersion + 1, "client": "future"}, CodeIncompatibleProtocol)
+	if code := alice.ExpectClosed(); code != websocket.CloseProtocolError {
+		t.Errorf("closed with %d, want %d", code, websocket.CloseProtocolError)
+	}
+
+	// After the handshake a message in another version is refused but the
+	// connection stays open.
+	bob := srv.Connect("Bob")
+	bob.SendRaw([]byte(`{"v": 2, "id": "future", "type": "createGame", "payload": {"numberOfPlayers": 2}}`))
+	for {
+		if msg := bob.Next(); msg.ReplyTo == "future" {
+			var e harness.ErrorPayload
+			if msg.Type == "error" {
+				bob.Decode(msg, &e)
+			}
+			if e.Code != CodeIncompatibleProtocol {
+				t.Fatalf("version 2 message answered with %s %s", msg.Type, msg.Payload)
+			}
+			break
+		}
+	}
+	bob.Create(2)
+	t.Cleanup(func() {
+		if session, ok := manager.find(bob.SessionID); ok {
+			manager.remove(session)
+		}
+	})
+}
+
+func TestHandshakeNegotiatesFeatures(t *testing.T) {
+	srv := startServer(t)
+	alice := srv.Dial("Alice")
+	welcome := alice.Do("hello", map[string]any{
+		"protocolVersion": ProtocolVersion,
+		"client":          "harness",
+		"features":        []string{FeatureDelta, "telepathy", FeatureDelta},
+	})
+	var payload WelcomePayload
+	alice.Decode(welcome, &payload)
+	if welcome.Type != "welcome" || !slices.Equal(payload.Features, []string{FeatureDelta}) {
+		t.Fatalf("hello answered with %s %s, want welcome with only %s", welcome.Type, welcome.Payload, FeatureDelta)
+	}
+
+	// Alice asked for deltas and is sent snapshots; Bob gets full states
+	alice.Create(2)
+	t.Cleanup(func() {
+		if session, ok := manager.find(alice.SessionID); ok {
+			manager.remove(session)
+		}
+	})
+	alice.Expect("snapshot")
+	bob := srv.Connect("Bob")
+	bob.Join(alice.RoomCode)
+	bob.Expect("state")
+	alice.ExpectNone("state", 100*time.Millisecond)
+}
+
+func TestProtocolSchemaIsCurrent(t *testing.T) {
+	var generated bytes.Buffer
+	if err := writeSchema(&generated); err != nil {
+		t.Fatal(err)
+	}
+	published, err := os.ReadFile("protocol.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(generated.Bytes(), published) {
+		t.Error("protocol.schema.json is out of date, run go generate")
+	}
+
+	var schema struct {
+		Defs map[string]struct {
+			OneOf []struct {
+				Title string `json:"title"`
+			} `json:"oneOf"`
+		} `json:"$defs"`
+	}
+	if err := json.Unmarshal(published, &schema); err != nil {
+		t.Fatal(err)
+	}
+	for def, payloads := range map[string]map[string]any{"ClientMessage": clientPayloads, "ServerMessage": serverPayloads} {
+		var titles []string
+		for _, envelope := range schema.Defs[def].OneOf {
+			titles = append(titles, envelope.Title)
+		}
+		for msgType := range payloads {
+			if !slices.Contains(titles, msgType) {
+				t.Errorf("%s has no %s message", def, msgType)
+			}
+		}
+		if len(titles) != len(payloads) {
+			t.Errorf("%s has %d messages, want %d", def, len(titles), len(payloads))
+		}
+	}
+}
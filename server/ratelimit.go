@@ -0,0 +1,138 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// tokenBucket allows bursts of up to burst messages and refills at rate
+// messages per second.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// allow takes a token if one is available
+func (b *tokenBucket) allow(now time.Time) bool {
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// connectionLimiter counts the open websocket connections from each IP
+type connectionLimiter struct {
+	mu   sync.Mutex
+	byIP map[string]int
+}
+
+var connections = &connectionLimiter{
+	byIP: make(map[string]int),
+}
+
+// acquire reserves a connection for ip, reporting false when ip already has
+// the maximum number open.
+func (l *connectionLimiter) acquire(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if limit := config.MaxConnectionsPerIP; limit > 0 && l.byIP[ip] >= limit {
+		return false
+	}
+	l.byIP[ip]++
+	return true
+}
+
+func (l *connectionLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.byIP[ip]--; l.byIP[ip] <= 0 {
+		delete(l.byIP, ip)
+	}
+}
+
+// remoteIP returns the IP address the request came from
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// limitConnections refuses websocket upgrades from IPs that already have
+// the maximum number of connections open.
+func limitConnections(w http.ResponseWriter, r *http.Request) (release func(), ok bool) {
+	ip := remoteIP(r)
+	if !connections.acquire(ip) {
+		slog.Warn("Refused connection: too many connections", "remote", ip)
+		http.Error(w, "Too many connections", http.StatusTooManyRequests)
+		return nil, false
+	}
+	return func() { connections.release(ip) }, true
+}
+
+// requestLimiter rate limits the HTTP API requests from each IP, giving each
+// IP the message budget of one websocket connection.
+type requestLimiter struct {
+	mu   sync.Mutex
+	byIP map[string]*tokenBucket
+}
+
+var requests = &requestLimiter{
+	byIP: make(map[string]*tokenBucket),
+}
+
+// sweepRequestLimiter is how many IPs the request limiter tracks before it
+// forgets the idle ones
+const sweepRequestLimiter = 1024
+
+// allow takes a token from ip's bucket if one is available
+func (l *requestLimiter) allow(ip string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	bucket, ok := l.byIP[ip]
+	if !ok {
+		if len(l.byIP) >= sweepRequestLimiter {
+			l.sweep(now)
+		}
+		bucket = newTokenBucket(config.MessageRate, config.MessageBurst)
+		l.byIP[ip] = bucket
+	}
+	return bucket.allow(now)
+}
+
+// sweep forgets the IPs whose buckets have refilled, since a new bucket
+// would allow them the same
+func (l *requestLimiter) sweep(now time.Time) {
+	for ip, bucket := range l.byIP {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*bucket.rate >= bucket.burst {
+			delete(l.byIP, ip)
+		}
+	}
+}
+
+// limitRequests refuses API requests from IPs sending them faster than the
+// message rate.
+func limitRequests(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := remoteIP(r)
+		if !requests.allow(ip, time.Now()) {
+			slog.Warn("Refused API request: rate limited", "remote", ip, "path", r.URL.Path)
+			writeAPIError(w, newProtocolError(CodeRateLimited, "you are sending requests too quickly"))
+			return
+		}
+		handler(w, r)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"game-server/internal/harness"
+	"github.com/gorilla/websocket"
+)
+
+func TestTokenBucket(t *testing.T) {
+	bucket := newTokenBucket(2, 3)
+	now := bucket.last
+	for i := range 3 {
+		if !bucket.allow(now) {
+			t.Fatalf("message %d of the burst was refused", i+1)
+		}
+	}
+	if bucket.allow(now) {
+		t.Error("a message over the burst was allowed")
+	}
+	if now = now.Add(500 * time.Millisecond); !bucket.allow(now) || bucket.allow(now) {
+		t.Error("half a second at 2 per second did not refill exactly one token")
+	}
+	now = now.Add(time.Hour)
+	allowed := 0
+	for bucket.allow(now) {
+		allowed++
+	}
+	if allowed != 3 {
+		t.Errorf("an idle bucket allowed %d messages, want the burst of 3", allowed)
+	}
+}
+
+func TestConnectionLimiter(t *testing.T) {
+	oldConfig := config
+	t.Cleanup(func() { config = oldConfig })
+	cfg := *config
+	cfg.MaxConnectionsPerIP = 2
+	config = &cfg
+
+	limiter := &connectionLimiter{byIP: make(map[string]int)}
+	if !limiter.acquire("192.0.2.1") || !limiter.acquire("192.0.2.1") {
+		t.Fatal("connections under the limit were refused")
+	}
+	if limiter.acquire("192.0.2.1") {
+		t.Error("a third connection from the same IP was allowed")
+	}
+	if !limiter.acquire("192.0.2.2") {
+		t.Error("another IP was refused")
+	}
+	limiter.release("192.0.2.1")
+	if !limiter.acquire("192.0.2.1") {
+		t.Error("a connection was refused after one closed")
+	}
+	for _, ip := range []string{"192.0.2.1", "192.0.2.1", "192.0.2.2"} {
+		limiter.release(ip)
+	}
+	if len(limiter.byIP) != 0 {
+		t.Errorf("the limiter still counts %v after every connection closed", limiter.byIP)
+	}
+
+	config.MaxConnectionsPerIP = 0
+	for i := range 100 {
+		if !limiter.acquire("192.0.2.1") {
+			t.Fatalf("connection %d refused without a limit", i+1)
+		}
+	}
+}
+
+func TestCreateGameRefusedAtMaxSessions(t *testing.T) {
+	useLifecycleConfig(t)
+	config.MaxSessions = manager.count() + 1
+
+	create := clientMessage(t, "createGame", CreateGamePayload{NumberOfPlayers: 2, PlayerName: "Alice"})
+	session, err := findOrCreateSession(create)
+	if err != nil {
+		t.Fatalf("creating a game under the limit: %v", err)
+	}
+	t.Cleanup(func() { manager.remove(session) })
+	if _, err := findOrCreateSession(create); asProtocolError(err).Code != CodeRateLimited {
+		t.Errorf("creating a game at maxSessions: %v, want rate_limited", err)
+	}
+
+	// Joining an existing game is not limited
+	join := clientMessage(t, "joinGame", JoinGamePayload{SessionID: session.ID, PlayerName: "Bob"})
+	if found, err := findOrCreateSession(join); err != nil || found != session {
+		t.Errorf("joining at maxSessions: %v, want the existing game", err)
+	}
+}
+
+// errorCode returns the code of an error message, or "" for any other
+func errorCode(msg harness.Message) string {
+	var e harness.ErrorPayload
+	if msg.Type != "error" || json.Unmarshal(msg.Payload, &e) != nil {
+		return ""
+	}
+	return e.Code
+}
+
+func TestWebSocketMessagesAreRateLimited(t *testing.T) {
+	srv := startServer(t)
+	config.MessageRate, config.MessageBurst, config.MaxRateLimited = 0.001, 2, 3
+
+	// The hello takes the first token and a malformed frame the second
+	client := srv.Connect("Mallory")
+	client.SendRaw([]byte("not json"))
+	if code := errorCode(client.Next()); code != CodeBadRequest {
+		t.Fatalf("a malformed frame answered %q, want bad_request", code)
+	}
+	for i := range config.MaxRateLimited {
+		client.SendRaw([]byte("not json"))
+		if code := errorCode(client.Next()); code != CodeRateLimited {
+			t.Fatalf("malformed frame %d over the limit answered %q, want rate_limited", i+1, code)
+		}
+	}
+	client.SendRaw([]byte("not json"))
+	if code := client.ExpectClosed(); code != websocket.ClosePolicyViolation {
+		t.Errorf("the connection closed with %d, want a policy violation", code)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+)
+
+// Room codes are short names for sessions that can be read out loud, such
+// as BLUE-DESTROYER-42. They are accepted everywhere a session ID is, in
+// any case and with spaces or underscores for the dashes.
+
+var (
+	roomCodeColors = []string{
+		"AMBER", "BLACK", "BLUE", "BRASS", "CORAL", "CRIMSON", "GOLD", "GRAY",
+		"GREEN", "IRON", "IVORY", "JADE", "NAVY", "ORANGE", "PEARL", "PURPLE",
+		"RED", "RUBY", "SILVER", "STEEL", "TEAL", "WHITE", "YELLOW", "ZINC",
+	}
+	roomCodeShips = []string{
+		"ANCHOR", "BARGE", "BATTLESHIP", "CARRIER", "CORVETTE", "CRUISER",
+		"CUTTER", "DESTROYER", "DREADNOUGHT", "FERRY", "FRIGATE", "GALLEON",
+		"GUNBOAT", "IRONCLAD", "KETCH", "LAUNCH", "MONITOR", "SCHOONER",
+		"SLOOP", "SUBMARINE", "TENDER", "TRAWLER", "TUG", "YACHT",
+	}
+)
+
+// newRoomCode returns a random room code. Callers check it is not in use.
+func newRoomCode() string {
+	return fmt.Sprintf("%s-%s-%d",
+		roomCodeColors[rand.Intn(len(roomCodeColors))],
+		roomCodeShips[rand.Intn(len(roomCodeShips))],
+		10+rand.Intn(90))
+}
+
+// normalizeRoomCode returns code in the form newRoomCode writes it
+func normalizeRoomCode(code string) string {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	return strings.NewReplacer(" ", "-", "_", "-").Replace(code)
+}
+
+// newSessionID returns a random session ID. Callers check it is not in use.
+func newSessionID() string {
+	return fmt.Sprintf("%d", rand.Intn(1000000))
+}
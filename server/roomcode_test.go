@@ -0,0 +1,65 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestNewRoomCode(t *testing.T) {
+	pattern := regexp.MustCompile(`^[A-Z]+-[A-Z]+-[1-9][0-9]$`)
+	for range 100 {
+		if code := newRoomCode(); !pattern.MatchString(code) {
+			t.Fatalf("room code %q does not look like BLUE-DESTROYER-42", code)
+		}
+	}
+}
+
+func TestNormalizeRoomCode(t *testing.T) {
+	for _, code := range []string{"BLUE-DESTROYER-42", "blue-destroyer-42", " Blue Destroyer 42 ", "blue_destroyer_42"} {
+		if got := normalizeRoomCode(code); got != "BLUE-DESTROYER-42" {
+			t.Errorf("normalizeRoomCode(%q) = %q", code, got)
+		}
+	}
+}
+
+// Names taken here or by another instance are never reused
+func TestRegisterSessionAvoidsTakenNames(t *testing.T) {
+	useCluster(t)
+	first := createNewSession(2)
+	defer manager.remove(first)
+	registry.Claim("TEAL-TUG-77", "b")
+
+	second := newGameSession(2)
+	second.ID, second.RoomCode = first.ID, "TEAL-TUG-77"
+	registerSession(second)
+	defer manager.remove(second)
+
+	if second.ID == first.ID || second.ID == "" {
+		t.Errorf("second session ID = %q, first is %q", second.ID, first.ID)
+	}
+	if second.RoomCode == "TEAL-TUG-77" || second.RoomCode == first.RoomCode {
+		t.Errorf("second session took room code %q", second.RoomCode)
+	}
+	if owner, _ := registry.Owner("TEAL-TUG-77"); owner != "b" {
+		t.Errorf("room code owned by %q, want b", owner)
+	}
+
+	if found, ok := manager.find(first.ID); !ok || found != first {
+		t.Error("session not found by ID")
+	}
+	if found, ok := manager.find(normalizeRoomCode(second.RoomCode)); !ok || found != second {
+		t.Error("session not found by room code")
+	}
+	if found, ok := manager.find(strings.ReplaceAll(second.RoomCode, "-", " ")); !ok || found != second {
+		t.Error("session not found by room code written with spaces")
+	}
+
+	manager.remove(second)
+	if _, ok := manager.find(second.RoomCode); ok {
+		t.Error("removed session still found by room code")
+	}
+	if owner, _ := registry.Owner(second.RoomCode); owner != "" {
+		t.Errorf("removed session's room code still owned by %q", owner)
+	}
+}
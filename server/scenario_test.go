@@ -0,0 +1,153 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"game-server/internal/harness"
+)
+
+// startServer serves the server's routes with a message rate that lets
+// scripted clients play a whole game quickly. The config is restored once
+// the test's connections have closed.
+func startServer(t *testing.T) *harness.Server {
+	t.Helper()
+	oldConfig := config
+	cfg := *config
+	cfg.MessageRate, cfg.MessageBurst = 10000, 10000
+	config = &cfg
+	t.Cleanup(func() {
+		waitUntil(time.Now().Add(harness.DefaultTimeout), func() bool { return connectedClients.Load() == 0 })
+		config = oldConfig
+	})
+
+	mux, err := newServeMux()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return harness.New(t, mux)
+}
+
+// seatTwoPlayers creates a two player game for Alice and seats Bob
+func seatTwoPlayers(t *testing.T, srv *harness.Server) (alice, bob *harness.Client) {
+	t.Helper()
+	alice, bob = srv.Connect("Alice"), srv.Connect("Bob")
+	alice.Create(2)
+	t.Cleanup(func() {
+		if session, ok := manager.find(alice.SessionID); ok {
+			manager.remove(session)
+		}
+	})
+	bob.Join(alice.RoomCode)
+	if bob.SessionID != alice.SessionID || bob.PlayerID == alice.PlayerID {
+		t.Fatalf("Bob joined %s as %s, want Alice's game %s in another seat", bob.SessionID, bob.PlayerID, alice.SessionID)
+	}
+	return alice, bob
+}
+
+func TestScenarioFullGame(t *testing.T) {
+	srv := startServer(t)
+	alice, bob := seatTwoPlayers(t, srv)
+	carol := srv.Connect("Carol")
+	carol.Spectate(alice.RoomCode)
+
+	alice.Start()
+	bob.Expect("gameStarted")
+	state := alice.WaitState(func(s *harness.State) bool { return s.GameState.GameStarted })
+	if me := alice.Me(); len(me.Hand) != ruleset.HandSize || len(me.PlayedShips) != ruleset.BattleLineSize {
+		t.Fatalf("Alice was dealt %d salvos and %d ships", len(me.Hand), len(me.PlayedShips))
+	}
+	for _, player := range state.GameState.Players {
+		if player.ID != alice.PlayerID && len(player.Hand) != 0 {
+			t.Errorf("Alice can see %s's hand", player.Name)
+		}
+	}
+
+	players := map[string]*harness.Client{alice.PlayerID: alice, bob.PlayerID: bob}
+	current := players[state.GameState.CurrentPlayerId]
+	for turn := 1; current.State.GameState.Winner == ""; turn++ {
+		if turn > 2000 {
+			t.Fatal("no winner after 2000 turns")
+		}
+		current.PlayTurn()
+		if current.State.GameState.Winner == "" {
+			current = players[current.State.GameState.CurrentPlayerId]
+		}
+	}
+
+	winner := current.State.GameState.Winner
+	if winner != current.PlayerID {
+		t.Errorf("%s played the last turn but %s won", current.PlayerID, winner)
+	}
+	for _, client := range []*harness.Client{alice, bob, carol} {
+		final := client.WaitState(func(s *harness.State) bool { return s.GameState.Winner != "" })
+		if final.GameState.Winner != winner || final.GameState.GameStarted {
+			t.Errorf("%s sees winner %q, started %v; want %s and the game over", client.Name, final.GameState.Winner, final.GameState.GameStarted, winner)
+		}
+	}
+	loser := alice
+	if winner == alice.PlayerID {
+		loser = bob
+	}
+	if ships := loser.Me().PlayedShips; len(ships) != 0 {
+		t.Errorf("the loser still has %d ships in their battle line", len(ships))
+	}
+	loser.Reject("drawSalvo", nil, CodeGameNotStarted)
+}
+
+func TestScenarioRejectedMoves(t *testing.T) {
+	srv := startServer(t)
+	alice := srv.Connect("Alice")
+	alice.Reject("drawSalvo", nil, CodeBadRequest)
+	alice.Create(2)
+	t.Cleanup(func() {
+		if session, ok := manager.find(alice.SessionID); ok {
+			manager.remove(session)
+		}
+	})
+	alice.Reject("startGame", nil, CodeWaitingForPlayers)
+
+	bob := srv.Connect("Bob")
+	bob.Reject("joinGame", map[string]any{"sessionId": "NO-SUCH-GAME", "playerName": "Bob"}, CodeNotFound)
+	bob.Join(alice.RoomCode)
+	srv.Connect("Eve").Reject("joinGame", map[string]any{"sessionId": alice.RoomCode, "playerName": "Eve"}, CodeGameFull)
+
+	alice.Start()
+	state := alice.WaitState(func(s *harness.State) bool { return s.GameState.GameStarted })
+	waiting := bob
+	if state.GameState.CurrentPlayerId == bob.PlayerID {
+		waiting = alice
+	}
+	waiting.Reject("drawSalvo", nil, CodeNotYourTurn)
+	waiting.Reject("startGame", nil, CodeGameAlreadyStarted)
+	waiting.Reject("fireSalvo", map[string]any{"salvo": "broadside"}, CodeBadRequest)
+}
+
+func TestScenarioRejoinAfterDrop(t *testing.T) {
+	srv := startServer(t)
+	alice, bob := seatTwoPlayers(t, srv)
+	alice.Start()
+	bob.WaitState(func(s *harness.State) bool { return s.GameState.GameStarted })
+	hand := len(bob.Me().Hand)
+
+	bob.Close()
+	alice.Expect("playerDisconnected")
+
+	again := srv.Connect("Bob")
+	again.Reject("rejoinGame", map[string]any{"sessionId": bob.SessionID, "playerId": bob.PlayerID, "token": "wrong"}, CodeForbidden)
+	again.Rejoin(bob.RoomCode, bob.PlayerID, bob.Token)
+	if again.PlayerID != bob.PlayerID || again.Token != bob.Token {
+		t.Errorf("rejoined as %s, want Bob's seat %s", again.PlayerID, bob.PlayerID)
+	}
+	alice.Expect("playerReconnected")
+
+	alice.Say("welcome back")
+	if msg := again.Expect("chat"); len(msg.Payload) == 0 {
+		t.Error("empty chat message")
+	}
+	again.WaitState(func(s *harness.State) bool { return s.GameState.GameStarted })
+	if got := len(again.Me().Hand); got != hand {
+		t.Errorf("Bob's hand has %d salvos after rejoining, want %d", got, hand)
+	}
+	alice.ExpectNone("playerDisconnected", 50*time.Millisecond)
+}
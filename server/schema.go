@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+//go:generate sh -c "go run . schema > protocol.schema.json"
+
+// protocolSchema builds a JSON Schema describing every client and server
+// message from the Go payload types registered in clientPayloads and
+// serverPayloads.
+func protocolSchema() map[string]any {
+	defs := map[string]any{}
+	defs["ClientMessage"] = map[string]any{"oneOf": envelopeSchemas(clientPayloads, false, defs)}
+	defs["ServerMessage"] = map[string]any{"oneOf": envelopeSchemas(serverPayloads, true, defs)}
+
+	return map[string]any{
+		"$schema":     "https://json-schema.org/draft/2020-12/schema",
+		"title":       "Salvo websocket protocol",
+		"description": "Messages exchanged over /ws. Every message is an envelope carrying the protocol version, a message ID and a typed payload.",
+		"version":     ProtocolVersion,
+		"oneOf": []any{
+			map[string]any{"$ref": "#/$defs/ClientMessage"},
+			map[string]any{"$ref": "#/$defs/ServerMessage"},
+		},
+		"$defs": defs,
+	}
+}
+
+func envelopeSchemas(payloads map[string]any, fromServer bool, defs map[string]any) []any {
+	types := make([]string, 0, len(payloads))
+	for msgType := range payloads {
+		types = append(types, msgType)
+	}
+	slices.Sort(types)
+
+	schemas := make([]any, 0, len(types))
+	for _, msgType := range types {
+		properties := map[string]any{
+			"v":       map[string]any{"const": ProtocolVersion},
+			"id":      map[string]any{"type": "string", "minLength": 1},
+			"type":    map[string]any{"const": msgType},
+			"payload": typeSchema(reflect.TypeOf(payloads[msgType]), defs),
+		}
+		if fromServer {
+			properties["replyTo"] = map[string]any{"type": "string"}
+		}
+		schemas = append(schemas, map[string]any{
+			"title":                msgType,
+			"type":                 "object",
+			"required":             []string{"v", "id", "type"},
+			"properties":           properties,
+			"additionalProperties": false,
+		})
+	}
+	return schemas
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// typeSchema describes t, adding named struct types to defs and referring to
+// them by name.
+func typeSchema(t reflect.Type, defs map[string]any) map[string]any {
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t == reflect.TypeOf(json.RawMessage{}):
+		return map[string]any{}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return map[string]any{"anyOf": []any{typeSchema(t.Elem(), defs), map[string]any{"type": "null"}}}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem(), defs)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(t.Elem(), defs)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return structSchema(t, defs)
+		}
+		if _, ok := defs[t.Name()]; !ok {
+			defs[t.Name()] = map[string]any{} // placeholder for recursive types
+			defs[t.Name()] = structSchema(t, defs)
+		}
+		return map[string]any{"$ref": "#/$defs/" + t.Name()}
+	default:
+		return map[string]any{}
+	}
+}
+
+func structSchema(t reflect.Type, defs map[string]any) map[string]any {
+	properties := map[string]any{}
+	required := []string{}
+	addStructFields(t, properties, &required, defs)
+	schema := map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func addStructFields(t reflect.Type, properties map[string]any, required *[]string, defs map[string]any) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" {
+			addStructFields(field.Type, properties, required, defs)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = typeSchema(field.Type, defs)
+		if !strings.Contains(options, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
+
+func writeSchema(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(protocolSchema())
+}
+
+func handleProtocolSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/schema+json")
+	writeSchema(w)
+}
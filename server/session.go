@@ -1,107 +1,645 @@
 package main
 
 import (
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"math/rand"
+	"log/slog"
+	"maps"
 	"net/http"
+	"slices"
 	"sync"
 	"time"
 
+	"game-server/engine"
 	"github.com/gorilla/websocket"
 )
 
 type GameSession struct {
-	ID           string
-	GameState    *GameState
-  NumberOfPlayers int 
-	Clients      map[string]*websocket.Conn // playerID -> connection
-	mu           sync.RWMutex
-	lastActivity time.Time
+	ID             string
+	RoomCode       string
+	GameState      *GameState
+	Clients        map[string]*Client // playerID -> connection
+	Spectators     map[string]*Client // spectatorID -> connection
+	listeners      map[*Client]bool   // read-only streams of a player's view
+	lastActivity   time.Time
+	spectatorCount int
+	chat           chatLog
+	tokens         map[string]string // playerID -> rejoin token
+	ended          bool              // ended by an administrator
+	version        int               // counts state broadcasts
+	warnedAt       time.Time         // lastActivity when the clients were warned the session would expire
+	updated        chan struct{}     // closed and replaced at every state broadcast
+	log            *slog.Logger
+
+	// Set for sessions created to play a tournament match
+	tournamentID   string
+	matchID        string
+	entrants       map[string]string // entrant name -> tournament token
+	resultReported bool
+
+	// Set for correspondence games
+	turnDeadline time.Duration     // time each player has for their turn
+	turnStarted  time.Time         // when the current player's turn began
+	playerKeys   map[string]string // playerID -> key listing the player's pending turns
+
+	webhook *WebhookTarget // sent the session's events besides the server's webhooks
+
+	// Every field above is owned by the session goroutine, which runs the
+	// commands queued here one at a time.
+	commands chan func()
+	done     chan struct{}
+	stopOnce sync.Once
 }
 
-type ClientMessage struct {
-	Action    string `json:"action"`
-	SessionID string `json:"sessionId,omitempty"`
-	PlayerID  string `json:"playerId,omitempty"`
+var (
+	errSessionClosed = newProtocolError(CodeNotFound, "game session has ended")
+	errSessionEnded  = newProtocolError(CodeForbidden, "the game was ended by an administrator")
+)
+
+// run executes the session's commands until the session is stopped
+func (session *GameSession) run() {
+	for {
+		select {
+		case command := <-session.commands:
+			command()
+		case <-session.done:
+			return
+		}
+	}
 }
 
-// Specific message types
-type StartGameMessage struct {
-	ClientMessage
-	NumPlayers int `json:"numPlayers"`
+// post queues command to run on the session goroutine. It reports false if
+// the session has stopped.
+func (session *GameSession) post(command func()) bool {
+	select {
+	case session.commands <- command:
+		return true
+	case <-session.done:
+		return false
+	}
 }
 
-type CreateGameMessage struct {
-	ClientMessage
-	NumPlayers int    `json:"numberOfPlayers"`
-	PlayerName string `json:"playerName"`
+// call runs command on the session goroutine and waits for its result
+func (session *GameSession) call(command func() error) error {
+	result := make(chan error, 1)
+	if !session.post(func() { result <- command() }) {
+		return errSessionClosed
+	}
+	select {
+	case err := <-result:
+		return err
+	case <-session.done:
+		return errSessionClosed
+	}
 }
 
-type JoinGameMessage struct {
-	ClientMessage
-	SessionID  string `json:"sessionId"`
-	PlayerName string `json:"playerName"`
+func (session *GameSession) stop() {
+	session.stopOnce.Do(func() { close(session.done) })
 }
 
 type SessionInfo struct {
-	ID          string `json:"id"`
-	PlayerCount int    `json:"playerCount"`
-	GameStarted bool   `json:"gameStarted"`
+	ID             string `json:"id"`
+	RoomCode       string `json:"roomCode"`
+	PlayerCount    int    `json:"playerCount"`
+	GameStarted    bool   `json:"gameStarted"`
+	State          string `json:"state"`
+	Correspondence bool   `json:"correspondence,omitempty"`
 }
 
-
 func handleListSessions(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	manager.sessionsMu.RLock()
-	sessionList := make([]SessionInfo, 0, len(manager.sessions))
-	for _, session := range manager.sessions {
-		sessionList = append(sessionList, SessionInfo{
-			ID:          session.ID,
-			PlayerCount: len(session.Clients),
-			GameStarted: session.GameState.GameStarted,
+	sessions := manager.list()
+	sessionList := make([]SessionInfo, 0, len(sessions))
+	for _, session := range sessions {
+		var info SessionInfo
+		err := session.call(func() error {
+			info = SessionInfo{
+				ID:             session.ID,
+				RoomCode:       session.RoomCode,
+				PlayerCount:    len(session.Clients),
+				GameStarted:    session.GameState.GameStarted,
+				State:          session.lifecycleState(),
+				Correspondence: session.turnDeadline > 0,
+			}
+			return nil
 		})
+		if err == nil {
+			sessionList = append(sessionList, info)
+		}
 	}
-	manager.sessionsMu.RUnlock()
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string][]SessionInfo{"sessions": sessionList})
 }
 
 func createNewSession(numPlayers int) *GameSession {
-	session := &GameSession{
-		ID:           fmt.Sprintf("%d", rand.Intn(1000000)),
-		GameState:    &GameState{GameStarted: false},
-		Clients:      make(map[string]*websocket.Conn),
+	session := newGameSession(numPlayers)
+	registerSession(session)
+	return session
+}
+
+// createTournamentSession creates a two player session reserved for the
+// entrants of a tournament match, who are seated with their tournament
+// tokens.
+func createTournamentSession(tournamentID, matchID string, entrants map[string]string) *GameSession {
+	session := newGameSession(2)
+	session.tournamentID = tournamentID
+	session.matchID = matchID
+	session.entrants = entrants
+	registerSession(session)
+	return session
+}
+
+func newGameSession(numPlayers int) *GameSession {
+	state := engine.NewGame(numPlayers, &ruleset)
+	return &GameSession{
+		GameState:    &state,
+		Clients:      make(map[string]*Client),
+		Spectators:   make(map[string]*Client),
+		listeners:    make(map[*Client]bool),
+		tokens:       make(map[string]string),
+		playerKeys:   make(map[string]string),
+		updated:      make(chan struct{}),
 		lastActivity: time.Now(),
-    NumberOfPlayers: numPlayers,
+		commands:     make(chan func(), 64),
+		done:         make(chan struct{}),
 	}
+}
+
+// registerSession gives the session an ID and room code that no other
+// session uses, on this instance or another, starts the session goroutine
+// and makes the session visible to joining clients. A restored session
+// keeps its own unless another instance has taken them in the meantime.
+func registerSession(session *GameSession) {
 	manager.sessionsMu.Lock()
+	id, code := session.ID, session.RoomCode
+	session.ID = manager.claim(session.ID, newSessionID)
+	session.RoomCode = manager.claim(session.RoomCode, newRoomCode)
 	manager.sessions[session.ID] = session
+	manager.codes[session.RoomCode] = session
 	manager.sessionsMu.Unlock()
-	return session
+
+	session.log = slog.With("session", session.ID)
+	if id != "" && (id != session.ID || code != session.RoomCode) {
+		session.log.Warn("Session renamed, its ID or room code was taken", "previousId", id, "previousRoomCode", code)
+	}
+	go session.run()
+	session.log.Info("Session created", "roomCode", session.RoomCode, "numberOfPlayers", session.GameState.NumberOfPlayers, "tournament", session.tournamentID)
+}
+
+// claim returns name, or a fresh one from generate when name is empty or
+// in use, once it is claimed in the registry. Session IDs and room codes
+// share the registry, which never confuses them as they look nothing
+// alike. It is called with sessionsMu held.
+func (m *SessionManager) claim(name string, generate func() string) string {
+	for {
+		if name != "" && m.sessions[name] == nil && m.codes[name] == nil {
+			err := registry.Claim(name, config.InstanceID)
+			if err == nil {
+				return name
+			}
+			if !errors.Is(err, errSessionClaimed) {
+				// Without the registry, names are only unique here
+				slog.Error("Claiming session name failed", "name", name, "error", err)
+				return name
+			}
+		}
+		name = generate()
+	}
+}
+
+// find returns the session with the given ID or room code
+func (m *SessionManager) find(id string) (*GameSession, bool) {
+	m.sessionsMu.RLock()
+	defer m.sessionsMu.RUnlock()
+	if session, exists := m.sessions[id]; exists {
+		return session, true
+	}
+	session, exists := m.codes[normalizeRoomCode(id)]
+	return session, exists
+}
+
+func (m *SessionManager) count() int {
+	m.sessionsMu.RLock()
+	defer m.sessionsMu.RUnlock()
+	return len(m.sessions)
+}
+
+func (m *SessionManager) list() []*GameSession {
+	m.sessionsMu.RLock()
+	defer m.sessionsMu.RUnlock()
+	return slices.Collect(maps.Values(m.sessions))
+}
+
+// remove stops session and forgets it. Its clients should already have been
+// closed.
+func (m *SessionManager) remove(session *GameSession) {
+	session.stop()
+	m.sessionsMu.Lock()
+	delete(m.sessions, session.ID)
+	delete(m.codes, session.RoomCode)
+	m.sessionsMu.Unlock()
+	for _, name := range []string{session.ID, session.RoomCode} {
+		if err := registry.Release(name, config.InstanceID); err != nil {
+			session.log.Error("Releasing session name failed", "name", name, "error", err)
+		}
+	}
+}
+
+// handleClientMessage applies msg from client on the session goroutine. On
+// success the client gets an ack, followed by the updated game state for
+// everyone when the game changed.
+func (session *GameSession) handleClientMessage(client *Client, msg ClientMessage) error {
+	err := session.applyClientMessage(client, msg)
+	log := session.playerLog(client).With("action", msg.Type, "messageId", msg.ID)
+	if err != nil {
+		log.Debug("Client message rejected", "error", err)
+	} else {
+		log.Debug("Client message handled")
+	}
+	return err
+}
+
+// playerLog returns the session's logger with client's player ID
+func (session *GameSession) playerLog(client *Client) *slog.Logger {
+	return session.log.With("player", client.playerID)
+}
+
+func (session *GameSession) applyClientMessage(client *Client, msg ClientMessage) error {
+	var err error
+	if session.ended && msg.Type != "chat" && msg.Type != "resync" {
+		return errSessionEnded
+	}
+	switch msg.Type {
+	case "createGame", "joinGame":
+		err = session.join(client, msg)
+	case "rejoinGame":
+		err = session.rejoin(client, msg)
+	case "spectateGame":
+		err = session.spectate(client, msg)
+	case "resync":
+		return session.resync(client, msg)
+	case "chat":
+		if err := session.handleChat(client, msg); err != nil {
+			return err
+		}
+		session.lastActivity = time.Now()
+		sendAck(client, msg)
+		return nil
+	default:
+		if client.spectator {
+			return newProtocolError(CodeForbidden, "spectators cannot take game actions")
+		}
+		err = handleMessage(session, client.playerID, msg)
+	}
+	if err != nil {
+		return err
+	}
+
+	session.lastActivity = time.Now()
+	sendAck(client, msg)
+	session.broadcastGameState()
+	return nil
+}
+
+// join seats client as the next player. It handles both createGame, for the
+// session's first player, and joinGame.
+func (session *GameSession) join(client *Client, msg ClientMessage) error {
+	var joinMsg JoinGamePayload
+	if err := decodePayload(msg, &joinMsg); err != nil {
+		return err
+	}
+	player, err := session.seat(joinMsg.PlayerName, joinMsg.EntrantToken)
+	if err != nil {
+		return err
+	}
+	session.issuePlayerKey(player, joinMsg.PlayerKey)
+	client.playerID = player.ID
+	client.playerName = player.Name
+	session.Clients[client.playerID] = client
+	session.sendJoined(client)
+	session.sendChatHistory(client)
+	return nil
+}
+
+// seat adds a player called name to the game and gives them the token that
+// identifies them from then on. A tournament match only seats its entrants,
+// each with the token they were given at registration.
+func (session *GameSession) seat(name, entrantToken string) (Player, error) {
+	if session.entrants != nil {
+		token, ok := session.entrants[name]
+		if !ok {
+			return Player{}, newProtocolError(CodeForbidden, "game is reserved for a tournament match")
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(entrantToken)) != 1 {
+			return Player{}, newProtocolError(CodeForbidden, "invalid entrant token")
+		}
+		for _, player := range session.GameState.Players {
+			if player.Name == name {
+				return Player{}, newProtocolError(CodeForbidden, "player %s already joined", name)
+			}
+		}
+	}
+
+	if err := applyAction(session, engine.Join{Name: name}); err != nil {
+		return Player{}, err
+	}
+	player := session.GameState.Players[len(session.GameState.Players)-1]
+	session.tokens[player.ID] = newToken()
+	session.log.Info("Player joined", "player", player.ID, "name", player.Name)
+	return player, nil
+}
+
+// playerForToken returns the ID of the player holding token
+func (session *GameSession) playerForToken(token string) (string, bool) {
+	for playerID, playerToken := range session.tokens {
+		if subtle.ConstantTimeCompare([]byte(playerToken), []byte(token)) == 1 {
+			return playerID, true
+		}
+	}
+	return "", false
+}
+
+// rejoin gives a player whose connection dropped their seat back. A still
+// open connection for the same seat is replaced.
+func (session *GameSession) rejoin(client *Client, msg ClientMessage) error {
+	var rejoinMsg RejoinGamePayload
+	if err := decodePayload(msg, &rejoinMsg); err != nil {
+		return err
+	}
+	token, ok := session.tokens[rejoinMsg.PlayerID]
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(rejoinMsg.Token)) != 1 {
+		return newProtocolError(CodeForbidden, "invalid rejoin token")
+	}
+	player := session.GameState.Player(rejoinMsg.PlayerID)
+	if player == nil {
+		return newProtocolError(CodeNotFound, "player %s not found", rejoinMsg.PlayerID)
+	}
+
+	if previous, connected := session.Clients[player.ID]; connected {
+		previous.closeWith(websocket.CloseNormalClosure, "replaced by a new connection")
+	}
+	client.playerID = player.ID
+	client.playerName = player.Name
+	session.Clients[client.playerID] = client
+	session.playerLog(client).Info("Player rejoined")
+	session.sendJoined(client)
+	session.sendChatHistory(client)
+	session.broadcastPresence("playerReconnected", client)
+	return nil
+}
+
+func (session *GameSession) spectate(client *Client, msg ClientMessage) error {
+	var joinMsg JoinGamePayload
+	if err := decodePayload(msg, &joinMsg); err != nil {
+		return err
+	}
+
+	session.spectatorCount++
+	client.playerID = fmt.Sprintf("s%d", session.spectatorCount)
+	client.playerName = joinMsg.PlayerName
+	client.spectator = true
+	session.Spectators[client.playerID] = client
+	session.playerLog(client).Info("Spectator joined", "name", client.playerName)
+	session.sendJoined(client)
+	session.sendChatHistory(client)
+	return nil
+}
+
+// listen attaches client to playerID's seat as a read-only listener. It gets
+// the messages and view the player's connection gets, but takes no actions
+// and leaves the connection holding the seat alone.
+func (session *GameSession) listen(client *Client, playerID string) error {
+	if session.ended {
+		return errSessionEnded
+	}
+	player := session.GameState.Player(playerID)
+	if player == nil {
+		return newProtocolError(CodeNotFound, "player %s not found", playerID)
+	}
+	client.playerID = player.ID
+	client.playerName = player.Name
+	session.listeners[client] = true
+	session.playerLog(client).Info("Player listening")
+	session.sendJoined(client)
+	session.sendChatHistory(client)
+	sendState(client, createStatePayload(session, player.ID))
+	return nil
+}
+
+// players returns the connections that get a player's view: the ones
+// holding a seat and the listeners.
+func (session *GameSession) players() []*Client {
+	players := make([]*Client, 0, len(session.Clients)+len(session.listeners))
+	for _, client := range session.Clients {
+		players = append(players, client)
+	}
+	for listener := range session.listeners {
+		players = append(players, listener)
+	}
+	return players
+}
+
+// removeClient forgets a client whose connection has closed. The player
+// keeps their seat and can take it back with rejoinGame.
+func (session *GameSession) removeClient(client *Client) {
+	if session.listeners[client] {
+		delete(session.listeners, client)
+		return
+	}
+	if client.spectator {
+		if session.Spectators[client.playerID] == client {
+			delete(session.Spectators, client.playerID)
+		}
+		return
+	}
+	if session.Clients[client.playerID] == client {
+		delete(session.Clients, client.playerID)
+		session.playerLog(client).Info("Player disconnected")
+		session.broadcastPresence("playerDisconnected", client)
+	}
+}
+
+// broadcastPresence tells everyone else at the table about a player's
+// connection.
+func (session *GameSession) broadcastPresence(msgType string, client *Client) {
+	msg := newServerMessage(msgType, PresencePayload{PlayerID: client.playerID, Name: client.playerName})
+	for _, other := range session.players() {
+		if other != client {
+			other.enqueue(msg)
+		}
+	}
+	for _, spectator := range session.Spectators {
+		spectator.enqueue(msg)
+	}
+}
+
+// closeClients disconnects everyone in the session
+func (session *GameSession) closeClients() {
+	for _, client := range session.players() {
+		client.close()
+	}
+	for _, spectator := range session.Spectators {
+		spectator.close()
+	}
+	session.Clients = make(map[string]*Client)
+	session.Spectators = make(map[string]*Client)
+	session.listeners = make(map[*Client]bool)
+}
+
+// inProgress reports whether the game has started, is not over and still
+// has a player connected.
+func (session *GameSession) inProgress() bool {
+	state := session.GameState
+	return state.GameStarted && state.Winner == "" && !session.ended && len(session.Clients) > 0
+}
+
+// end stops the game for good. The clients stay connected and can still
+// chat, but every game action is refused.
+func (session *GameSession) end(reason string) {
+	session.ended = true
+	session.log.Info("Session ended by an administrator", "reason", reason)
+	session.broadcast(newServerMessage("sessionEnded", SessionEndedPayload{SessionID: session.ID, Reason: reason}))
+}
+
+// kick disconnects a player or spectator and revokes the player's rejoin
+// token, so the seat cannot be taken back.
+func (session *GameSession) kick(playerID string) error {
+	client, connected := session.Clients[playerID]
+	if !connected {
+		client, connected = session.Spectators[playerID]
+	}
+	if !connected && session.GameState.Player(playerID) == nil {
+		return newProtocolError(CodeNotFound, "player %s not found", playerID)
+	}
+	delete(session.tokens, playerID)
+	if connected {
+		client.closeWith(websocket.ClosePolicyViolation, "kicked by an administrator")
+	}
+	for listener := range session.listeners {
+		if listener.playerID == playerID {
+			listener.closeWith(websocket.ClosePolicyViolation, "kicked by an administrator")
+			delete(session.listeners, listener)
+		}
+	}
+	session.log.Info("Player kicked by an administrator", "player", playerID)
+	return nil
 }
 
-func sendGameStarted(conn *websocket.Conn, session *GameSession) {
-	response, _ := json.Marshal(ServerMessage{
-		MessageType: "gameStarted",
-		SessionID:   session.ID,
-	})
-	conn.WriteMessage(websocket.TextMessage, response)
+// broadcast queues msg for every player and spectator
+func (session *GameSession) broadcast(msg ServerMessage) {
+	for _, client := range session.players() {
+		client.enqueue(msg)
+	}
+	for _, spectator := range session.Spectators {
+		spectator.enqueue(msg)
+	}
 }
 
-func createServerMessage(session *GameSession, playerID string) ServerMessage {
-	session.GameState.mu.RLock()
-	defer session.GameState.mu.RUnlock()
+// reportGameOver forwards the result of a finished tournament match to the
+// tournament that created the session.
+func reportGameOver(session *GameSession) {
+	if session.tournamentID == "" || session.resultReported {
+		return
+	}
 
+	winner := ""
+	for _, player := range session.GameState.Players {
+		if player.ID == session.GameState.Winner {
+			winner = player.Name
+		}
+	}
+	if winner == "" {
+		return
+	}
+
+	session.resultReported = true
+	tournaments.recordResult(session.tournamentID, session.matchID, winner)
+}
+
+// reportForfeit settles the tournament match of a session that is removed
+// before its game was won, so the tournament does not wait for it forever.
+func reportForfeit(session *GameSession) {
+	if session.tournamentID == "" || session.resultReported {
+		return
+	}
+	seated := make([]string, 0, len(session.GameState.Players))
+	for _, player := range session.GameState.Players {
+		seated = append(seated, player.Name)
+	}
+	session.resultReported = true
+	tournaments.recordForfeit(session.tournamentID, session.matchID, seated)
+}
+
+// sendJoined tells a client which session and seat it now occupies
+func (session *GameSession) sendJoined(client *Client) {
+	client.enqueue(newServerMessage("joined", JoinedPayload{
+		SessionID: session.ID,
+		RoomCode:  session.RoomCode,
+		PlayerID:  client.playerID,
+		Spectator: client.spectator,
+		Token:     session.tokens[client.playerID],
+		PlayerKey: session.playerKeys[client.playerID],
+	}))
+}
+
+// broadcastGameState sends every player their own view of the game, and
+// spectators a view without any hidden cards.
+func (session *GameSession) broadcastGameState() {
+	start := time.Now()
+	defer func() { broadcastDurations.observe(time.Since(start).Seconds()) }()
+
+	// Wake the HTTP clients waiting for a new version
+	session.version++
+	close(session.updated)
+	session.updated = make(chan struct{})
+
+	for _, client := range session.players() {
+		sendState(client, createStatePayload(session, client.playerID))
+	}
+	spectatorState := createStatePayload(session, "")
+	for _, spectator := range session.Spectators {
+		sendState(spectator, spectatorState)
+	}
+}
+
+func sendState(client *Client, state StatePayload) {
+	if msg, changed := client.stateMessage(state); changed {
+		client.enqueue(msg)
+	}
+}
+
+// resync sends a delta client a full snapshot after it detected a gap in
+// the sequence numbers.
+func (session *GameSession) resync(client *Client, msg ClientMessage) error {
+	if client.deltas == nil {
+		return newProtocolError(CodeBadRequest, "resync requires the %s feature", FeatureDelta)
+	}
+	playerID := client.playerID
+	if client.spectator {
+		playerID = ""
+	}
+	client.deltas.resync()
+	sendAck(client, msg)
+	sendState(client, createStatePayload(session, playerID))
+	return nil
+}
+
+// createStatePayload returns the game as seen by playerID. The payload is
+// marshalled by the clients' write pumps while the session goroutine carries
+// on, so it shares no slices with the live game state.
+func createStatePayload(session *GameSession, playerID string) StatePayload {
 	// Create a filtered game state for the client
 	filteredState := GameState{
 		CurrentPlayerId: session.GameState.CurrentPlayerId,
 		GameStarted:     session.GameState.GameStarted,
+		Winner:          session.GameState.Winner,
 		Players:         make([]Player, len(session.GameState.Players)),
 	}
 
@@ -110,23 +648,27 @@ func createServerMessage(session *GameSession, playerID string) ServerMessage {
 		filteredState.Players[i] = Player{
 			ID:              player.ID,
 			Name:            player.Name,
-			PlayedShips:     player.PlayedShips,
-			DiscardedSalvos: player.DiscardedSalvos,
-			DeepSixPile:     player.DeepSixPile,
+			PlayedShips:     slices.Clone(player.PlayedShips),
+			DiscardedSalvos: slices.Clone(player.DiscardedSalvos),
+			DeepSixPile:     slices.Clone(player.DeepSixPile),
+			Eliminated:      player.Eliminated,
+			Hand:            []SalvoCard{},
+			Ships:           []ShipCard{},
 		}
 
 		// Only include hand and ships for the current player
 		if player.ID == playerID {
-			filteredState.Players[i].Hand = player.Hand
-			filteredState.Players[i].Ships = player.Ships
+			filteredState.Players[i].Hand = slices.Clone(player.Hand)
+			filteredState.Players[i].Ships = slices.Clone(player.Ships)
 		}
 	}
 
-	return ServerMessage{
-		GameState:     filteredState,
+	return StatePayload{
+		SessionID:     session.ID,
+		GameState:     &filteredState,
 		ShipDeckCount: len(session.GameState.ShipDeck),
 		PlayDeckCount: len(session.GameState.PlayDeck),
 		DiscardCount:  len(session.GameState.DiscardPile),
-		SessionID:     session.ID,
+		TurnDeadline:  session.currentTurnDeadline(),
 	}
 }
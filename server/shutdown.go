@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// draining is set once a graceful shutdown begins. No new games are created
+// after that.
+var draining atomic.Bool
+
+var errShuttingDown = newProtocolError(CodeShuttingDown, "the server is shutting down, try again shortly")
+
+// drain shuts the server down without losing games. Every connection is
+// told with serverShutdown, and games in progress get the shutdown grace
+// period to finish the turn being played. Then the unfinished sessions are
+// saved to the store with the tournaments and the finished ones archived,
+// pending webhooks get
+// the shutdown timeout to be delivered, and the connections are closed and
+// the HTTP server shut down.
+func drain(server *http.Server) {
+	ready.Store(false)
+	draining.Store(true)
+
+	deadline := time.Now().Add(time.Duration(config.ShutdownGrace))
+	sent := allClients.broadcast(newServerMessage("serverShutdown", ServerShutdownPayload{Deadline: deadline}))
+	slog.Info("Draining", "grace", time.Duration(config.ShutdownGrace).String(), "notified", sent)
+	turns := currentTurns()
+	waitUntil(deadline, func() bool { return turnsFinished(turns) })
+
+	saved, archived := 0, 0
+	for _, session := range manager.list() {
+		var snapshot SessionSnapshot
+		var game *ArchivedGame
+		keep := false
+		session.call(func() error {
+			finished := session.lifecycleState() == SessionFinished
+			keep = len(session.GameState.Players) > 0 && !finished
+			if keep {
+				snapshot = session.snapshot()
+			}
+			if finished {
+				archivedGame := session.archivedGame(time.Now())
+				game = &archivedGame
+			}
+			session.closeClients()
+			return nil
+		})
+		manager.remove(session)
+		if game != nil {
+			if err := archive.Save(*game); err != nil {
+				session.log.Error("Archiving session failed", "error", err)
+			} else {
+				archived++
+			}
+		}
+		if !keep || store == nil {
+			continue
+		}
+		if err := store.Save(snapshot); err != nil {
+			session.log.Error("Saving session failed", "error", err)
+			continue
+		}
+		saved++
+	}
+	savedTournaments := 0
+	if store != nil {
+		for _, snapshot := range tournaments.snapshots() {
+			if err := store.SaveTournament(snapshot); err != nil {
+				slog.Error("Saving tournament failed", "tournament", snapshot.ID, "error", err)
+				continue
+			}
+			savedTournaments++
+		}
+	}
+	slog.Info("Saved sessions", "count", saved, "tournaments", savedTournaments, "store", store != nil, "archived", archived)
+	webhooks.close(time.Duration(config.ShutdownTimeout))
+	allClients.closeAll()
+
+	// Let the write pumps send their close frames; the HTTP server does
+	// not track hijacked websocket connections.
+	timeout := time.Duration(config.ShutdownTimeout)
+	waitUntil(time.Now().Add(timeout), func() bool { return connectedClients.Load() == 0 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Error("HTTP server shutdown failed", "error", err)
+	}
+}
+
+// currentTurns returns the player on turn in every game in progress
+func currentTurns() map[*GameSession]string {
+	turns := make(map[*GameSession]string)
+	for _, session := range manager.list() {
+		session.call(func() error {
+			if session.inProgress() {
+				turns[session] = session.GameState.CurrentPlayerId
+			}
+			return nil
+		})
+	}
+	return turns
+}
+
+// turnsFinished reports whether every game in turns has moved on from the
+// turn it was on, or stopped being played.
+func turnsFinished(turns map[*GameSession]string) bool {
+	for session, playerID := range turns {
+		finished := true
+		session.call(func() error {
+			finished = !session.inProgress() || session.GameState.CurrentPlayerId != playerID
+			return nil
+		})
+		if !finished {
+			return false
+		}
+	}
+	return true
+}
+
+// waitUntil polls done until it reports true or deadline passes
+func waitUntil(deadline time.Time, done func() bool) {
+	for !done() && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+}
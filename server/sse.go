@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// handleEventStream is the Server-Sent Events transport for networks that
+// break websockets. The stream is a client like any websocket connection:
+// the session queues the same messages for it, and an event stream pump
+// writes each one as an event whose data is the message envelope. Actions
+// are sent with POST /games/{id}/actions.
+//
+// A player authenticates with their token, as a bearer token or, since
+// browsers' EventSource cannot set headers, the token query parameter, and
+// the stream listens to their seat without taking it from the player's
+// websocket. Without a token the stream spectates under the name query
+// parameter.
+func handleEventStream(w http.ResponseWriter, r *http.Request) {
+	session, exists := manager.find(r.PathValue("id"))
+	if !exists {
+		writeAPIError(w, newProtocolError(CodeNotFound, "game session not found"))
+		return
+	}
+	release, ok := limitConnections(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
+	client := newClient()
+	client.log = slog.With("remote", remoteIP(r), "transport", "sse")
+	token := r.URL.Query().Get("token")
+	if header, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		token = header
+	}
+	err := session.call(func() error {
+		if token != "" {
+			playerID, ok := session.playerForToken(token)
+			if !ok {
+				return newProtocolError(CodeForbidden, "invalid player token")
+			}
+			return session.listen(client, playerID)
+		}
+		msg := ClientMessage{Version: ProtocolVersion, ID: "stream", Type: "spectateGame"}
+		msg.Payload, _ = json.Marshal(JoinGamePayload{SessionID: session.ID, PlayerName: r.URL.Query().Get("name")})
+		return session.handleClientMessage(client, msg)
+	})
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+
+	connectedClients.Add(1)
+	defer connectedClients.Add(-1)
+	allClients.add(client)
+	defer allClients.remove(client)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	eventPump(r.Context(), w, client)
+
+	client.close()
+	session.post(func() { session.removeClient(client) })
+}
+
+// eventPump writes the client's queued messages to an event stream and
+// sends a comment periodically to keep proxies from timing the stream out,
+// until the client is closed or the request ends. It is the event stream
+// counterpart of writePump.
+func eventPump(ctx context.Context, w http.ResponseWriter, client *Client) {
+	rc := http.NewResponseController(w)
+	flush := func() error {
+		rc.SetWriteDeadline(time.Now().Add(time.Duration(config.WriteTimeout)))
+		return rc.Flush()
+	}
+	if err := flush(); err != nil {
+		return
+	}
+	ticker := time.NewTicker(pingPeriod())
+	defer ticker.Stop()
+	for {
+		select {
+		case msg := <-client.send:
+			if err := writeEvent(w, msg); err != nil {
+				client.log.Info("Write to client failed", "error", err)
+				return
+			}
+			for len(client.send) > 0 {
+				if err := writeEvent(w, <-client.send); err != nil {
+					return
+				}
+			}
+			if err := flush(); err != nil {
+				client.log.Info("Write to client failed", "error", err)
+				return
+			}
+		case <-ticker.C:
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil || flush() != nil {
+				return
+			}
+		case <-client.done:
+			// Flush messages queued before the close, such as the error
+			// explaining it
+			for len(client.send) > 0 {
+				if err := writeEvent(w, <-client.send); err != nil {
+					return
+				}
+			}
+			flush()
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// writeEvent writes msg as an event. The JSON encoding never contains a
+// newline, so the envelope always fits on one data line.
+func writeEvent(w io.Writer, msg ServerMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	n, err := fmt.Fprintf(w, "id: %s\ndata: %s\n\n", msg.ID, data)
+	bytesSent.add(uint64(n))
+	return err
+}
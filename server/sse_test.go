@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"game-server/internal/harness"
+)
+
+// openStream opens the event stream at path on srv and returns its status
+// and the messages it carries. The stream is closed when the test ends.
+func openStream(t *testing.T, srv *harness.Server, path string) (int, <-chan harness.Message) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, "GET", srv.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := make(chan harness.Message, 64)
+	go func() {
+		defer resp.Body.Close()
+		defer close(messages)
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+			if !ok {
+				continue
+			}
+			var msg harness.Message
+			if json.Unmarshal([]byte(data), &msg) == nil {
+				messages <- msg
+			}
+		}
+	}()
+	return resp.StatusCode, messages
+}
+
+// expectEvent returns the next message of msgType on the stream, skipping
+// others, and fails the test if none comes.
+func expectEvent(t *testing.T, messages <-chan harness.Message, msgType string) harness.Message {
+	t.Helper()
+	timeout := time.After(harness.DefaultTimeout)
+	for {
+		select {
+		case msg, ok := <-messages:
+			if !ok {
+				t.Fatalf("the stream ended before a %s event", msgType)
+			}
+			if msg.Type == msgType {
+				return msg
+			}
+		case <-timeout:
+			t.Fatalf("no %s event on the stream", msgType)
+		}
+	}
+}
+
+func TestEventStreamListensWithoutTakingTheSeat(t *testing.T) {
+	srv := startServer(t)
+	alice, bob := seatTwoPlayers(t, srv)
+	path := "/games/" + alice.SessionID + "/events"
+
+	status, stream := openStream(t, srv, path+"?token="+alice.Token)
+	if status != http.StatusOK {
+		t.Fatalf("opening Alice's stream answered %d", status)
+	}
+	var joined JoinedPayload
+	if err := json.Unmarshal(expectEvent(t, stream, "joined").Payload, &joined); err != nil || joined.PlayerID != alice.PlayerID {
+		t.Errorf("the stream joined as %+v (%v), want Alice's seat %s", joined, err, alice.PlayerID)
+	}
+	expectEvent(t, stream, "state")
+
+	// Alice's websocket keeps the seat and Bob sees no one leave or return
+	bob.ExpectNone("playerDisconnected", 100*time.Millisecond)
+	alice.Start()
+	alice.WaitState(func(s *harness.State) bool { return s.GameState.GameStarted })
+	var state harness.State
+	for state.GameState.Player(alice.PlayerID) == nil || !state.GameState.GameStarted {
+		if err := json.Unmarshal(expectEvent(t, stream, "state").Payload, &state); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := len(state.GameState.Player(alice.PlayerID).Hand), len(alice.Me().Hand); got == 0 || got != want {
+		t.Errorf("the stream shows Alice %d salvos, want the %d in Alice's hand", got, want)
+	}
+	if len(state.GameState.Player(bob.PlayerID).Hand) != 0 {
+		t.Error("Alice's stream shows Bob's hand")
+	}
+	alice.Say("still here")
+	expectEvent(t, stream, "chat")
+
+	if status, _ := openStream(t, srv, path+"?token="+bob.Token+"x"); status != http.StatusForbidden {
+		t.Errorf("a wrong token answered %d, want 403", status)
+	}
+	status, spectating := openStream(t, srv, path+"?name=Carol")
+	if status != http.StatusOK {
+		t.Fatalf("spectating answered %d", status)
+	}
+	if err := json.Unmarshal(expectEvent(t, spectating, "state").Payload, &state); err != nil {
+		t.Fatal(err)
+	}
+	for _, player := range state.GameState.Players {
+		if len(player.Hand) != 0 {
+			t.Errorf("a spectator's stream shows %s's hand", player.Name)
+		}
+	}
+}
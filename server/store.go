@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// SessionSnapshot is a session saved when the server shuts down, from which
+// it is restored when the server starts again. Connections are not saved:
+// players take their seats back with rejoinGame and the token they were
+// given when they joined.
+type SessionSnapshot struct {
+	ID              string            `json:"id"`
+	RoomCode        string            `json:"roomCode"`
+	NumberOfPlayers int               `json:"numberOfPlayers"`
+	GameState       GameState         `json:"gameState"`
+	ShipDeck        []ShipCard        `json:"shipDeck"`
+	PlayDeck        []SalvoCard       `json:"playDeck"`
+	DiscardPile     []SalvoCard       `json:"discardPile"`
+	Tokens          map[string]string `json:"tokens"`
+	SpectatorCount  int               `json:"spectatorCount"`
+	Chat            []ChatMessage     `json:"chat"`
+	ChatNextID      int               `json:"chatNextId"`
+	TournamentID    string            `json:"tournamentId,omitempty"`
+	MatchID         string            `json:"matchId,omitempty"`
+	Entrants        map[string]string `json:"entrants,omitempty"`
+	TurnDeadline    Duration          `json:"turnDeadline,omitempty"`
+	TurnStarted     time.Time         `json:"turnStarted,omitempty"`
+	PlayerKeys      map[string]string `json:"playerKeys,omitempty"`
+	Webhook         *WebhookTarget    `json:"webhook,omitempty"`
+	SavedAt         time.Time         `json:"savedAt"`
+}
+
+// SessionStore keeps session and tournament snapshots across restarts
+type SessionStore interface {
+	Save(snapshot SessionSnapshot) error
+	LoadAll() ([]SessionSnapshot, error)
+	Delete(id string) error
+	SaveTournament(snapshot TournamentSnapshot) error
+	LoadTournaments() ([]TournamentSnapshot, error)
+	DeleteTournament(id string) error
+}
+
+// store is nil unless storeDir is configured, in which case sessions
+// survive a restart.
+var store SessionStore
+
+// fileStore saves each snapshot as a JSON file in dir
+type fileStore struct {
+	dir string
+}
+
+func newFileStore(dir string) (*fileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &fileStore{dir: dir}, nil
+}
+
+func (s *fileStore) path(id string) string {
+	return filepath.Join(s.dir, "session-"+id+".json")
+}
+
+func (s *fileStore) Save(snapshot SessionSnapshot) error {
+	return writeJSONFile(s.dir, filepath.Base(s.path(snapshot.ID)), snapshot)
+}
+
+func (s *fileStore) LoadAll() ([]SessionSnapshot, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "session-*.json"))
+	if err != nil {
+		return nil, err
+	}
+	snapshots := make([]SessionSnapshot, 0, len(paths))
+	for _, path := range paths {
+		var snapshot SessionSnapshot
+		if err := readJSONFile(path, &snapshot); err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots, nil
+}
+
+func (s *fileStore) Delete(id string) error {
+	if err := os.Remove(s.path(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *fileStore) tournamentPath(id string) string {
+	return filepath.Join(s.dir, "tournament-"+id+".json")
+}
+
+func (s *fileStore) SaveTournament(snapshot TournamentSnapshot) error {
+	return writeJSONFile(s.dir, filepath.Base(s.tournamentPath(snapshot.ID)), snapshot)
+}
+
+func (s *fileStore) LoadTournaments() ([]TournamentSnapshot, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "tournament-*.json"))
+	if err != nil {
+		return nil, err
+	}
+	snapshots := make([]TournamentSnapshot, 0, len(paths))
+	for _, path := range paths {
+		var snapshot TournamentSnapshot
+		if err := readJSONFile(path, &snapshot); err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots, nil
+}
+
+func (s *fileStore) DeleteTournament(id string) error {
+	if err := os.Remove(s.tournamentPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// writeJSONFile writes v as JSON to a temporary file in dir and renames it
+// to name, so a crash never leaves a half written file behind.
+func writeJSONFile(dir, name string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, name))
+}
+
+func readJSONFile(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// snapshot returns the session's state for the store. It runs on the
+// session goroutine.
+func (session *GameSession) snapshot() SessionSnapshot {
+	state := session.GameState.Clone()
+	return SessionSnapshot{
+		ID:              session.ID,
+		RoomCode:        session.RoomCode,
+		NumberOfPlayers: state.NumberOfPlayers,
+		GameState:       state,
+		ShipDeck:        state.ShipDeck,
+		PlayDeck:        state.PlayDeck,
+		DiscardPile:     state.DiscardPile,
+		Tokens:          maps.Clone(session.tokens),
+		SpectatorCount:  session.spectatorCount,
+		Chat:            slices.Clone(session.chat.history),
+		ChatNextID:      session.chat.nextID,
+		TournamentID:    session.tournamentID,
+		MatchID:         session.matchID,
+		Entrants:        maps.Clone(session.entrants),
+		TurnDeadline:    Duration(session.turnDeadline),
+		TurnStarted:     session.turnStarted,
+		PlayerKeys:      maps.Clone(session.playerKeys),
+		Webhook:         session.webhook,
+		SavedAt:         time.Now(),
+	}
+}
+
+// restoreSession registers the session saved in snapshot. Its idle timeout
+// starts over so the players have time to reconnect, and the time the
+// server was down does not count against a correspondence player's turn.
+func restoreSession(snapshot SessionSnapshot) *GameSession {
+	session := newGameSession(snapshot.NumberOfPlayers)
+	session.ID = snapshot.ID
+	session.RoomCode = snapshot.RoomCode
+
+	state := snapshot.GameState
+	state.ShipDeck = snapshot.ShipDeck
+	state.PlayDeck = snapshot.PlayDeck
+	state.DiscardPile = snapshot.DiscardPile
+	state.NumberOfPlayers = snapshot.NumberOfPlayers
+	state.Rules = &ruleset
+	session.GameState = &state
+
+	if snapshot.Tokens != nil {
+		session.tokens = snapshot.Tokens
+	}
+	session.spectatorCount = snapshot.SpectatorCount
+	session.chat.history = snapshot.Chat
+	session.chat.nextID = snapshot.ChatNextID
+	session.tournamentID = snapshot.TournamentID
+	session.matchID = snapshot.MatchID
+	session.entrants = snapshot.Entrants
+	session.turnDeadline = time.Duration(snapshot.TurnDeadline)
+	session.turnStarted = snapshot.TurnStarted.Add(time.Since(snapshot.SavedAt))
+	for playerID, key := range snapshot.PlayerKeys {
+		if player := state.Player(playerID); player != nil {
+			session.playerKeys[playerID] = key
+			playerKeys.remember(player.Name, key)
+		}
+	}
+	session.webhook = snapshot.Webhook
+	registerSession(session)
+	return session
+}
+
+// restoreSessions loads the sessions and tournaments saved at the last
+// shutdown and removes them from the store, which only holds them while the
+// server is down. The tournaments are restored after their match sessions,
+// so that matches whose session was not saved get a new one.
+func restoreSessions() error {
+	snapshots, err := store.LoadAll()
+	if err != nil {
+		return err
+	}
+	tournamentSnapshots, err := store.LoadTournaments()
+	if err != nil {
+		return err
+	}
+	for _, snapshot := range snapshots {
+		restoreSession(snapshot)
+		if err := store.Delete(snapshot.ID); err != nil {
+			return err
+		}
+	}
+	tournaments.restore(tournamentSnapshots)
+	for _, snapshot := range tournamentSnapshots {
+		if err := store.DeleteTournament(snapshot.ID); err != nil {
+			return err
+		}
+	}
+	if len(snapshots) > 0 || len(tournamentSnapshots) > 0 {
+		slog.Info("Restored sessions", "count", len(snapshots), "tournaments", len(tournamentSnapshots))
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"game-server/internal/harness"
+)
+
+// useStore gives the test a file store in a temporary directory and its
+// own webhook dispatcher, which drain closes, and undoes the drain's flags
+// when the test ends.
+func useStore(t *testing.T) *fileStore {
+	t.Helper()
+	fileStore, err := newFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldStore, oldWebhooks, wasReady := store, webhooks, ready.Load()
+	store, webhooks = fileStore, newWebhookDispatcher()
+	t.Cleanup(func() {
+		store, webhooks = oldStore, oldWebhooks
+		ready.Store(wasReady)
+		draining.Store(false)
+	})
+	return fileStore
+}
+
+func TestDrainSavesGamesThatRestoreAfterRestart(t *testing.T) {
+	srv := startServer(t)
+	config.ShutdownGrace, config.ShutdownTimeout = 0, Duration(time.Second)
+	fileStore := useStore(t)
+
+	alice, bob := seatTwoPlayers(t, srv)
+	alice.Start()
+	state := alice.WaitState(func(s *harness.State) bool { return s.GameState.GameStarted })
+	current := map[string]*harness.Client{alice.PlayerID: alice, bob.PlayerID: bob}[state.GameState.CurrentPlayerId]
+	current.DrawSalvo()
+	alice.Say("see you after the restart")
+
+	session, _ := manager.find(alice.SessionID)
+	var before StatePayload
+	session.call(func() error {
+		before = createStatePayload(session, alice.PlayerID)
+		return nil
+	})
+
+	drain(&http.Server{})
+	alice.Expect("serverShutdown")
+	if _, ok := manager.find(alice.SessionID); ok {
+		t.Fatal("the session is still hosted after draining")
+	}
+	if snapshots, err := fileStore.LoadAll(); err != nil || len(snapshots) != 1 || snapshots[0].ID != alice.SessionID {
+		t.Fatalf("the store holds %d snapshots (%v), want Alice's game", len(snapshots), err)
+	}
+
+	// The server starts again
+	draining.Store(false)
+	if err := restoreSessions(); err != nil {
+		t.Fatal(err)
+	}
+	if snapshots, _ := fileStore.LoadAll(); len(snapshots) != 0 {
+		t.Errorf("the store still holds %d snapshots after restoring them", len(snapshots))
+	}
+	restored, ok := manager.find(alice.RoomCode)
+	if !ok || restored.ID != alice.SessionID {
+		t.Fatalf("the game is not hosted under its room code %s after restoring", alice.RoomCode)
+	}
+
+	again := srv.Connect("Alice")
+	again.Reject("rejoinGame", map[string]any{"sessionId": alice.RoomCode, "playerId": alice.PlayerID, "token": bob.Token}, CodeForbidden)
+	// The chat history comes before the reply, so read it rather than
+	// letting Rejoin skip it
+	again.Send("rejoinGame", map[string]any{"sessionId": alice.RoomCode, "playerId": alice.PlayerID, "token": alice.Token})
+	if msg := again.Expect("chat"); len(msg.Payload) == 0 {
+		t.Error("empty chat history after the restart")
+	}
+	if again.PlayerID != alice.PlayerID {
+		t.Errorf("rejoined as %s, want Alice's seat %s", again.PlayerID, alice.PlayerID)
+	}
+	var after StatePayload
+	restored.call(func() error {
+		after = createStatePayload(restored, alice.PlayerID)
+		return nil
+	})
+	if !reflect.DeepEqual(after, before) {
+		t.Errorf("restored state\n%+v\nwant\n%+v", after, before)
+	}
+	again.WaitState(func(s *harness.State) bool { return s.GameState.GameStarted })
+	if got, want := len(again.Me().Hand), len(before.GameState.Player(alice.PlayerID).Hand); got != want {
+		t.Errorf("Alice holds %d salvos after rejoining, want %d", got, want)
+	}
+}
+
+func TestDrainSavesTournaments(t *testing.T) {
+	useLifecycleConfig(t)
+	config.ShutdownGrace, config.ShutdownTimeout = 0, Duration(time.Second)
+	fileStore := useStore(t)
+	id, tokens := startTournament(t, FormatSingleElimination, "Ann", "Ben", "Cat", "Dan")
+	oldTournaments := tournaments
+	t.Cleanup(func() { tournaments = oldTournaments })
+
+	// Ann is seated, so the match session is saved; nobody has joined Ben
+	// and Cat's match, so it is not
+	annDan := matchSession(t, id, "Ann", "Dan")
+	benCat := matchSession(t, id, "Ben", "Cat")
+	if err := annDan.call(func() error { _, err := annDan.seat("Ann", tokens["Ann"]); return err }); err != nil {
+		t.Fatal(err)
+	}
+	before := viewTournament(t, id)
+
+	drain(&http.Server{})
+	if snapshots, err := fileStore.LoadTournaments(); err != nil || len(snapshots) != 1 || snapshots[0].ID != id || snapshots[0].Tokens["Ann"] != tokens["Ann"] {
+		t.Fatalf("the store holds tournaments %+v (%v), want the tournament with its tokens", snapshots, err)
+	}
+
+	// The server starts again with no tournaments in memory
+	tournaments = &TournamentManager{tournaments: make(map[string]*Tournament)}
+	draining.Store(false)
+	if err := restoreSessions(); err != nil {
+		t.Fatal(err)
+	}
+	if snapshots, _ := fileStore.LoadTournaments(); len(snapshots) != 0 {
+		t.Errorf("the store still holds %d tournaments after restoring them", len(snapshots))
+	}
+	after := viewTournament(t, id)
+	if len(after.Rounds) != 1 || after.State != TournamentInProgress {
+		t.Fatalf("restored tournament %+v, want the first round in progress", after)
+	}
+	if restored := matchSession(t, id, "Ann", "Dan"); restored.ID != annDan.ID {
+		t.Errorf("Ann and Dan's match is in session %s, want the restored %s", restored.ID, annDan.ID)
+	}
+	if replaced := matchSession(t, id, "Ben", "Cat"); replaced.ID == benCat.ID || after.Rounds[0].Matches[1].SessionID == before.Rounds[0].Matches[1].SessionID {
+		t.Error("Ben and Cat's unsaved match did not get a new session")
+	}
+
+	// Results of restored matches reach the restored tournament
+	restored := matchSession(t, id, "Ann", "Dan")
+	restored.call(func() error {
+		restored.GameState.Winner = restored.GameState.Players[0].ID
+		reportGameOver(restored)
+		return nil
+	})
+	for _, match := range viewTournament(t, id).Rounds[0].Matches {
+		if match.PlayerA == "Ann" && match.Winner != "Ann" {
+			t.Errorf("Ann and Dan's match has winner %q after Ann won the restored game", match.Winner)
+		}
+	}
+	// Entrants take their seats in new match sessions with the tokens they
+	// registered with
+	benCat = matchSession(t, id, "Ben", "Cat")
+	if err := benCat.call(func() error { _, err := benCat.seat("Ben", tokens["Ben"]); return err }); err != nil {
+		t.Errorf("Ben's token was refused after the restart: %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// writeCertificate writes a self-signed certificate for name and its key to
+// certFile and keyFile and returns the certificate's DER bytes.
+func writeCertificate(t *testing.T, name, certFile, keyFile string) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+// servedCertificate returns the DER bytes of the certificate r serves
+func servedCertificate(t *testing.T, r *certReloader) []byte {
+	t.Helper()
+	cert, err := r.tlsConfig().GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert.Certificate[0]
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	first := writeCertificate(t, "old.example", certFile, keyFile)
+	r, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(servedCertificate(t, r), first) {
+		t.Fatal("the reloader does not serve the certificate it loaded")
+	}
+
+	// A renewed pair is picked up on SIGHUP
+	r.reloadOnHangup()
+	renewed := writeCertificate(t, "new.example", certFile, keyFile)
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	waitUntil(time.Now().Add(2*time.Second), func() bool { return bytes.Equal(servedCertificate(t, r), renewed) })
+	if !bytes.Equal(servedCertificate(t, r), renewed) {
+		t.Fatal("the renewed certificate was not served after SIGHUP")
+	}
+
+	// A broken pair is refused and the renewed certificate stays in use
+	if err := os.WriteFile(keyFile, []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.reload(); err == nil {
+		t.Error("a broken key was loaded")
+	}
+	if !bytes.Equal(servedCertificate(t, r), renewed) {
+		t.Error("a failed reload replaced the certificate")
+	}
+}
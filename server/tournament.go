@@ -0,0 +1,711 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math/bits"
+	"math/rand"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Supported tournament formats
+const (
+	FormatSingleElimination = "singleElimination"
+	FormatSwiss             = "swiss"
+)
+
+// Tournament lifecycle states
+const (
+	TournamentRegistration = "registration"
+	TournamentInProgress   = "inProgress"
+	TournamentFinished     = "finished"
+)
+
+var errTournamentNotFound = newProtocolError(CodeNotFound, "tournament not found")
+
+type Tournament struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Format    string   `json:"format"`
+	State     string   `json:"state"`
+	MaxRounds int      `json:"maxRounds"`
+	Entrants  []string `json:"entrants"`
+	Rounds    []Round  `json:"rounds"`
+	Champion  string   `json:"champion,omitempty"`
+
+	// tokens holds the secret each entrant was given at registration,
+	// which they need to take their seat in a match.
+	tokens map[string]string
+	// creatorToken is the secret the tournament's creator was given, which
+	// starts the tournament.
+	creatorToken string
+}
+
+type Round struct {
+	Number  int      `json:"number"`
+	Matches []*Match `json:"matches"`
+}
+
+// Match pairs two entrants in a dedicated game session. A match without a
+// PlayerB is a bye and is won by PlayerA without being played. A match whose
+// session expires before the game is won is forfeited: an entrant who took
+// their seat wins against one who never did, and otherwise both lose.
+type Match struct {
+	ID         string `json:"id"`
+	PlayerA    string `json:"playerA"`
+	PlayerB    string `json:"playerB,omitempty"`
+	SessionID  string `json:"sessionId,omitempty"`
+	Winner     string `json:"winner,omitempty"`
+	Forfeit    bool   `json:"forfeit,omitempty"`
+	DoubleLoss bool   `json:"doubleLoss,omitempty"`
+}
+
+type Standing struct {
+	Player   string `json:"player"`
+	Wins     int    `json:"wins"`
+	Losses   int    `json:"losses"`
+	Byes     int    `json:"byes"`
+	Points   int    `json:"points"`
+	Buchholz int    `json:"buchholz"`
+}
+
+type TournamentSummary struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Format   string `json:"format"`
+	State    string `json:"state"`
+	Entrants int    `json:"entrants"`
+	Round    int    `json:"round"`
+}
+
+type TournamentView struct {
+	Tournament
+	Standings []Standing `json:"standings"`
+}
+
+type CreateTournamentRequest struct {
+	Name   string `json:"name"`
+	Format string `json:"format"`
+	Rounds int    `json:"rounds"`
+}
+
+type RegisterEntrantRequest struct {
+	PlayerName string `json:"playerName"`
+}
+
+// TournamentCreation answers a creation with the tournament and the token
+// its creator needs to start it.
+type TournamentCreation struct {
+	TournamentView
+	Token string `json:"token"`
+}
+
+// EntrantRegistration answers a registration with the tournament and the
+// entrant's token. Only the entrant is given the token: it goes with their
+// playerName when they join their match sessions.
+type EntrantRegistration struct {
+	TournamentView
+	Token string `json:"token"`
+}
+
+// TournamentSnapshot is a tournament saved when the server shuts down,
+// together with the entrants' tokens that its views leave out.
+type TournamentSnapshot struct {
+	Tournament
+	Tokens       map[string]string `json:"tokens"`
+	CreatorToken string            `json:"creatorToken"`
+}
+
+type TournamentManager struct {
+	tournaments map[string]*Tournament
+	mu          sync.Mutex
+}
+
+var tournaments = &TournamentManager{
+	tournaments: make(map[string]*Tournament),
+}
+
+func (m *TournamentManager) create(name, format string, rounds int) (*Tournament, error) {
+	if format != FormatSingleElimination && format != FormatSwiss {
+		return nil, newProtocolError(CodeBadRequest, "unknown tournament format %q", format)
+	}
+	if rounds < 0 {
+		return nil, newProtocolError(CodeBadRequest, "rounds must not be negative")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := fmt.Sprintf("%d", rand.Intn(1000000))
+	for m.tournaments[id] != nil {
+		id = fmt.Sprintf("%d", rand.Intn(1000000))
+	}
+	t := &Tournament{
+		ID:        id,
+		Name:      name,
+		Format:    format,
+		State:     TournamentRegistration,
+		MaxRounds: rounds,
+		Entrants:  []string{},
+		Rounds:    []Round{},
+		tokens:    make(map[string]string),
+
+		creatorToken: newToken(),
+	}
+	m.tournaments[id] = t
+	return t, nil
+}
+
+// register adds an entrant and returns their token
+func (m *TournamentManager) register(id, playerName string) (string, error) {
+	playerName = strings.TrimSpace(playerName)
+	if playerName == "" {
+		return "", newProtocolError(CodeBadRequest, "player name is required")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.tournaments[id]
+	if !ok {
+		return "", errTournamentNotFound
+	}
+	if t.State != TournamentRegistration {
+		return "", newProtocolError(CodeGameAlreadyStarted, "registration is closed")
+	}
+	for _, entrant := range t.Entrants {
+		if entrant == playerName {
+			return "", newProtocolError(CodeForbidden, "player %q is already registered", playerName)
+		}
+	}
+	t.Entrants = append(t.Entrants, playerName)
+	t.tokens[playerName] = newToken()
+	return t.tokens[playerName], nil
+}
+
+// start closes registration and pairs the first round. Only the creator's
+// token or the admin token starts a tournament.
+func (m *TournamentManager) start(id, token string) error {
+	m.mu.Lock()
+	t, ok := m.tournaments[id]
+	if !ok {
+		m.mu.Unlock()
+		return errTournamentNotFound
+	}
+	creator := subtle.ConstantTimeCompare([]byte(token), []byte(t.creatorToken)) == 1
+	admin := config.AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(config.AdminToken)) == 1
+	if !creator && !admin {
+		m.mu.Unlock()
+		return newProtocolError(CodeForbidden, "only the tournament's creator or an administrator can start it")
+	}
+	if t.State != TournamentRegistration {
+		m.mu.Unlock()
+		return newProtocolError(CodeGameAlreadyStarted, "tournament already started")
+	}
+	if len(t.Entrants) < 2 {
+		m.mu.Unlock()
+		return newProtocolError(CodeWaitingForPlayers, "at least two entrants are required")
+	}
+	if t.Format == FormatSwiss && t.MaxRounds == 0 {
+		// Enough rounds to separate a single undefeated player
+		t.MaxRounds = bits.Len(uint(len(t.Entrants) - 1))
+	}
+	t.State = TournamentInProgress
+	slog.Info("Tournament started", "tournament", t.ID, "entrants", len(t.Entrants))
+	matches := t.advance()
+	m.mu.Unlock()
+
+	m.createSessions(t, matches)
+	return nil
+}
+
+// recordResult is called when the game session backing a match reaches game
+// over. It is a no-op for matches that are already decided.
+func (m *TournamentManager) recordResult(id, matchID, winner string) {
+	m.settle(id, matchID, func(t *Tournament, match *Match) bool {
+		if winner != match.PlayerA && winner != match.PlayerB {
+			slog.Warn("Ignoring unknown tournament match winner", "tournament", t.ID, "match", matchID, "winner", winner)
+			return false
+		}
+		match.Winner = winner
+		slog.Info("Tournament match won", "tournament", t.ID, "match", matchID, "winner", winner)
+		return true
+	})
+}
+
+// recordForfeit is called when the game session backing a match is removed
+// before the game was won, with the entrants who took their seat in it.
+func (m *TournamentManager) recordForfeit(id, matchID string, seated []string) {
+	m.settle(id, matchID, func(t *Tournament, match *Match) bool {
+		if len(seated) == 1 && (seated[0] == match.PlayerA || seated[0] == match.PlayerB) {
+			match.Winner = seated[0]
+			match.Forfeit = true
+			slog.Info("Tournament match won by forfeit", "tournament", t.ID, "match", matchID, "winner", match.Winner)
+		} else {
+			match.DoubleLoss = true
+			slog.Info("Tournament match forfeited by both entrants", "tournament", t.ID, "match", matchID)
+		}
+		return true
+	})
+}
+
+// settle runs decide on an undecided match of the current round and
+// advances the tournament when decide reports that it decided the match.
+func (m *TournamentManager) settle(id, matchID string, decide func(*Tournament, *Match) bool) {
+	m.mu.Lock()
+	t, ok := m.tournaments[id]
+	if !ok || t.State != TournamentInProgress || len(t.Rounds) == 0 {
+		m.mu.Unlock()
+		return
+	}
+	var matches []*Match
+	for _, match := range t.Rounds[len(t.Rounds)-1].Matches {
+		if match.ID == matchID && !match.decided() && decide(t, match) {
+			matches = t.advance()
+			break
+		}
+	}
+	m.mu.Unlock()
+
+	m.createSessions(t, matches)
+}
+
+// createSessions creates the game sessions for newly paired matches. It is
+// called without the manager lock, which registering a session must not
+// wait behind.
+func (m *TournamentManager) createSessions(t *Tournament, matches []*Match) {
+	for _, match := range matches {
+		m.mu.Lock()
+		entrants := map[string]string{
+			match.PlayerA: t.tokens[match.PlayerA],
+			match.PlayerB: t.tokens[match.PlayerB],
+		}
+		m.mu.Unlock()
+		session := createTournamentSession(t.ID, match.ID, entrants)
+		m.mu.Lock()
+		match.SessionID = session.ID
+		m.mu.Unlock()
+	}
+}
+
+func (m *TournamentManager) view(id string) (TournamentView, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.tournaments[id]
+	if !ok {
+		return TournamentView{}, errTournamentNotFound
+	}
+	return TournamentView{Tournament: t.snapshot(), Standings: t.standings()}, nil
+}
+
+// snapshot copies the tournament so it can be encoded without holding the
+// manager lock.
+func (t *Tournament) snapshot() Tournament {
+	copied := *t
+	copied.Entrants = slices.Clone(t.Entrants)
+	copied.tokens = nil
+	copied.Rounds = make([]Round, len(t.Rounds))
+	for i, round := range t.Rounds {
+		copied.Rounds[i] = Round{Number: round.Number, Matches: make([]*Match, len(round.Matches))}
+		for j, match := range round.Matches {
+			m := *match
+			copied.Rounds[i].Matches[j] = &m
+		}
+	}
+	return copied
+}
+
+// snapshots returns every tournament for the store
+func (m *TournamentManager) snapshots() []TournamentSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshots := make([]TournamentSnapshot, 0, len(m.tournaments))
+	for _, t := range m.tournaments {
+		snapshots = append(snapshots, TournamentSnapshot{Tournament: t.snapshot(), Tokens: maps.Clone(t.tokens), CreatorToken: t.creatorToken})
+	}
+	return snapshots
+}
+
+// restore adds the tournaments saved at the last shutdown. A match of the
+// current round whose session was not saved, because it was paired while
+// the server drained, gets a new session.
+func (m *TournamentManager) restore(snapshots []TournamentSnapshot) {
+	for _, snapshot := range snapshots {
+		t := snapshot.Tournament
+		t.tokens = snapshot.Tokens
+		t.creatorToken = snapshot.CreatorToken
+		if t.tokens == nil {
+			t.tokens = make(map[string]string)
+		}
+		var unplayed []*Match
+		if t.State == TournamentInProgress && len(t.Rounds) > 0 {
+			for _, match := range t.Rounds[len(t.Rounds)-1].Matches {
+				if _, ok := manager.find(match.SessionID); !match.decided() && !ok {
+					unplayed = append(unplayed, match)
+				}
+			}
+		}
+		m.mu.Lock()
+		m.tournaments[t.ID] = &t
+		m.mu.Unlock()
+		m.createSessions(&t, unplayed)
+	}
+}
+
+func (m *TournamentManager) list() []TournamentSummary {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := make([]TournamentSummary, 0, len(m.tournaments))
+	for _, t := range m.tournaments {
+		list = append(list, TournamentSummary{
+			ID:       t.ID,
+			Name:     t.Name,
+			Format:   t.Format,
+			State:    t.State,
+			Entrants: len(t.Entrants),
+			Round:    len(t.Rounds),
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// advance starts the next round once every match of the current round has a
+// winner, or finishes the tournament when no further round is needed. It
+// returns the new matches that need a game session. Callers must hold the
+// manager lock.
+func (t *Tournament) advance() []*Match {
+	var unplayed []*Match
+	for {
+		if len(t.Rounds) > 0 && !t.Rounds[len(t.Rounds)-1].complete() {
+			return unplayed
+		}
+
+		var pairings [][2]string
+		switch t.Format {
+		case FormatSingleElimination:
+			pairings = t.eliminationPairings()
+		case FormatSwiss:
+			if len(t.Rounds) < t.MaxRounds {
+				pairings = t.swissPairings()
+			}
+		}
+		if len(pairings) == 0 {
+			t.finish()
+			return unplayed
+		}
+		unplayed = append(unplayed, t.startRound(pairings)...)
+	}
+}
+
+// startRound pairs the next round and returns its matches that have to be
+// played; byes are won at once.
+func (t *Tournament) startRound(pairings [][2]string) []*Match {
+	var unplayed []*Match
+	round := Round{Number: len(t.Rounds) + 1}
+	for i, pair := range pairings {
+		match := &Match{
+			ID:      fmt.Sprintf("r%dm%d", round.Number, i+1),
+			PlayerA: pair[0],
+			PlayerB: pair[1],
+		}
+		switch {
+		case match.PlayerA == "":
+			// Both entrants who could have reached this match lost earlier
+			match.DoubleLoss = true
+		case match.PlayerB == "":
+			match.Winner = match.PlayerA
+		default:
+			unplayed = append(unplayed, match)
+		}
+		round.Matches = append(round.Matches, match)
+	}
+	t.Rounds = append(t.Rounds, round)
+	slog.Info("Tournament round paired", "tournament", t.ID, "round", round.Number, "matches", len(round.Matches))
+	return unplayed
+}
+
+func (t *Tournament) finish() {
+	t.State = TournamentFinished
+	switch t.Format {
+	case FormatSingleElimination:
+		// The last round has a single winner, or none when the final was
+		// forfeited by both entrants
+		if len(t.Rounds) > 0 {
+			for _, match := range t.Rounds[len(t.Rounds)-1].Matches {
+				if match.Winner != "" {
+					t.Champion = match.Winner
+				}
+			}
+		}
+	case FormatSwiss:
+		t.Champion = t.standings()[0].Player
+	}
+	slog.Info("Tournament finished", "tournament", t.ID, "champion", t.Champion)
+}
+
+func (r Round) complete() bool {
+	for _, match := range r.Matches {
+		if !match.decided() {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *Match) decided() bool {
+	return m.Winner != "" || m.DoubleLoss
+}
+
+// eliminationPairings seeds the first round in standard bracket order so the
+// top seeds receive any byes and can only meet in the later rounds. Later
+// rounds pair the winners of adjacent matches; the winner of a match next to
+// a double loss gets a bye.
+func (t *Tournament) eliminationPairings() [][2]string {
+	var players []string
+	if len(t.Rounds) == 0 {
+		size := 1 << bits.Len(uint(len(t.Entrants)-1))
+		for _, seed := range bracketOrder(size) {
+			if seed <= len(t.Entrants) {
+				players = append(players, t.Entrants[seed-1])
+			} else {
+				players = append(players, "")
+			}
+		}
+	} else {
+		for _, match := range t.Rounds[len(t.Rounds)-1].Matches {
+			players = append(players, match.Winner)
+		}
+	}
+	remaining := 0
+	for _, player := range players {
+		if player != "" {
+			remaining++
+		}
+	}
+	if remaining < 2 {
+		return nil
+	}
+
+	pairings := make([][2]string, 0, len(players)/2)
+	for i := 0; i+1 < len(players); i += 2 {
+		if players[i] == "" {
+			pairings = append(pairings, [2]string{players[i+1], ""})
+		} else {
+			pairings = append(pairings, [2]string{players[i], players[i+1]})
+		}
+	}
+	return pairings
+}
+
+// bracketOrder returns seeds 1..size ordered by bracket position, e.g.
+// [1 8 4 5 2 7 3 6] for eight slots.
+func bracketOrder(size int) []int {
+	order := []int{1}
+	for len(order) < size {
+		next := make([]int, 0, len(order)*2)
+		for _, seed := range order {
+			next = append(next, seed, len(order)*2+1-seed)
+		}
+		order = next
+	}
+	return order
+}
+
+// swissPairings pairs entrants with similar scores, avoiding rematches where
+// possible. With an odd field the lowest ranked entrant that has not had a
+// bye yet sits out.
+func (t *Tournament) swissPairings() [][2]string {
+	standings := t.standings()
+	played := t.opponents()
+
+	bye := ""
+	if len(standings)%2 == 1 {
+		i := len(standings) - 1
+		for i > 0 && standings[i].Byes > 0 {
+			i--
+		}
+		bye = standings[i].Player
+		standings = slices.Delete(standings, i, i+1)
+	}
+
+	var pairings [][2]string
+	paired := make(map[string]bool)
+	for i, s := range standings {
+		if paired[s.Player] {
+			continue
+		}
+		opponent := ""
+		for _, candidate := range standings[i+1:] {
+			if paired[candidate.Player] {
+				continue
+			}
+			if opponent == "" {
+				opponent = candidate.Player
+			}
+			if !played[s.Player][candidate.Player] {
+				opponent = candidate.Player
+				break
+			}
+		}
+		paired[s.Player] = true
+		paired[opponent] = true
+		pairings = append(pairings, [2]string{s.Player, opponent})
+	}
+	if bye != "" {
+		pairings = append(pairings, [2]string{bye, ""})
+	}
+	return pairings
+}
+
+func (t *Tournament) opponents() map[string]map[string]bool {
+	played := make(map[string]map[string]bool)
+	for _, entrant := range t.Entrants {
+		played[entrant] = make(map[string]bool)
+	}
+	for _, round := range t.Rounds {
+		for _, match := range round.Matches {
+			if match.PlayerB != "" {
+				played[match.PlayerA][match.PlayerB] = true
+				played[match.PlayerB][match.PlayerA] = true
+			}
+		}
+	}
+	return played
+}
+
+// standings ranks entrants by points, then by Buchholz score (the sum of
+// their opponents' points), then by registration order.
+func (t *Tournament) standings() []Standing {
+	byPlayer := make(map[string]*Standing, len(t.Entrants))
+	seed := make(map[string]int, len(t.Entrants))
+	for i, entrant := range t.Entrants {
+		byPlayer[entrant] = &Standing{Player: entrant}
+		seed[entrant] = i
+	}
+
+	for _, round := range t.Rounds {
+		for _, match := range round.Matches {
+			switch {
+			case match.DoubleLoss:
+				for _, player := range []string{match.PlayerA, match.PlayerB} {
+					if player != "" {
+						byPlayer[player].Losses++
+					}
+				}
+			case match.Winner == "":
+			case match.PlayerB == "":
+				byPlayer[match.PlayerA].Byes++
+				byPlayer[match.PlayerA].Points++
+			default:
+				loser := match.PlayerA
+				if match.Winner == match.PlayerA {
+					loser = match.PlayerB
+				}
+				byPlayer[match.Winner].Wins++
+				byPlayer[match.Winner].Points++
+				byPlayer[loser].Losses++
+			}
+		}
+	}
+
+	for player, opponents := range t.opponents() {
+		for opponent := range opponents {
+			byPlayer[player].Buchholz += byPlayer[opponent].Points
+		}
+	}
+
+	standings := make([]Standing, 0, len(byPlayer))
+	for _, entrant := range t.Entrants {
+		standings = append(standings, *byPlayer[entrant])
+	}
+	sort.SliceStable(standings, func(i, j int) bool {
+		a, b := standings[i], standings[j]
+		if a.Points != b.Points {
+			return a.Points > b.Points
+		}
+		if a.Buchholz != b.Buchholz {
+			return a.Buchholz > b.Buchholz
+		}
+		return seed[a.Player] < seed[b.Player]
+	})
+	return standings
+}
+
+func handleListTournaments(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string][]TournamentSummary{"tournaments": tournaments.list()})
+}
+
+func handleCreateTournament(w http.ResponseWriter, r *http.Request) {
+	var req CreateTournamentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIError(w, newProtocolError(CodeBadRequest, "invalid tournament request"))
+		return
+	}
+	if req.Format == "" {
+		req.Format = FormatSingleElimination
+	}
+	t, err := tournaments.create(req.Name, req.Format, req.Rounds)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	view, _ := tournaments.view(t.ID)
+	writeJSON(w, http.StatusCreated, TournamentCreation{TournamentView: view, Token: t.creatorToken})
+}
+
+func handleGetTournament(w http.ResponseWriter, r *http.Request) {
+	view, err := tournaments.view(r.PathValue("id"))
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, view)
+}
+
+func handleRegisterEntrant(w http.ResponseWriter, r *http.Request) {
+	var req RegisterEntrantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIError(w, newProtocolError(CodeBadRequest, "invalid registration request"))
+		return
+	}
+	id := r.PathValue("id")
+	token, err := tournaments.register(id, req.PlayerName)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	view, err := tournaments.view(id)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, EntrantRegistration{TournamentView: view, Token: token})
+}
+
+// handleStartTournament starts the tournament for a request bearing the
+// creator's token or the admin token.
+func handleStartTournament(w http.ResponseWriter, r *http.Request) {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if err := tournaments.start(r.PathValue("id"), token); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	handleGetTournament(w, r)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+// startTournament registers entrants in a new tournament of format and
+// starts it, returning its ID and the entrants' tokens. The tournament and
+// its match sessions are removed when the test ends.
+func startTournament(t *testing.T, format string, entrants ...string) (string, map[string]string) {
+	t.Helper()
+	tournament, err := tournaments.create("test", format, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := tournament.ID
+	t.Cleanup(func() {
+		tournaments.mu.Lock()
+		delete(tournaments.tournaments, id)
+		tournaments.mu.Unlock()
+		for _, session := range manager.list() {
+			if session.tournamentID == id {
+				manager.remove(session)
+			}
+		}
+	})
+	tokens := make(map[string]string, len(entrants))
+	for _, name := range entrants {
+		token, err := tournaments.register(id, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens[name] = token
+	}
+	if err := tournaments.start(id, tournament.creatorToken); err != nil {
+		t.Fatal(err)
+	}
+	return id, tokens
+}
+
+func viewTournament(t *testing.T, id string) TournamentView {
+	t.Helper()
+	view, err := tournaments.view(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return view
+}
+
+// matchSession returns the session of the match between a and b in the
+// tournament's current round.
+func matchSession(t *testing.T, id, a, b string) *GameSession {
+	t.Helper()
+	view := viewTournament(t, id)
+	for _, match := range view.Rounds[len(view.Rounds)-1].Matches {
+		if match.PlayerA == a && match.PlayerB == b {
+			session, ok := manager.find(match.SessionID)
+			if !ok {
+				t.Fatalf("match %s has no session", match.ID)
+			}
+			return session
+		}
+	}
+	t.Fatalf("no match between %s and %s in %+v", a, b, view.Rounds)
+	return nil
+}
+
+func TestExpiredMatchesAreForfeited(t *testing.T) {
+	useLifecycleConfig(t)
+	id, tokens := startTournament(t, FormatSingleElimination, "Ann", "Ben", "Cat", "Dan")
+
+	// Ann takes a seat and Dan never does; neither Ben nor Cat turn up
+	ann := matchSession(t, id, "Ann", "Dan")
+	matchSession(t, id, "Ben", "Cat")
+	if err := ann.call(func() error { _, err := ann.seat("Ann", tokens["Ann"]); return err }); err != nil {
+		t.Fatal(err)
+	}
+
+	// The entrants have longer than an ordinary lobby to turn up
+	expireSessions(time.Now().Add(time.Duration(config.LobbyIdleTimeout) + time.Minute))
+	for _, match := range viewTournament(t, id).Rounds[0].Matches {
+		if match.decided() {
+			t.Fatalf("match %+v was decided within the match start timeout", match)
+		}
+	}
+	expireSessions(time.Now().Add(time.Hour))
+
+	view := viewTournament(t, id)
+	first := view.Rounds[0].Matches
+	if first[0].Winner != "Ann" || !first[0].Forfeit {
+		t.Errorf("Ann vs Dan is %+v, want won by Ann by forfeit", first[0])
+	}
+	if first[1].Winner != "" || !first[1].DoubleLoss {
+		t.Errorf("Ben vs Cat is %+v, want a double loss", first[1])
+	}
+	if view.State != TournamentFinished || view.Champion != "Ann" || len(view.Rounds) != 1 {
+		t.Errorf("tournament is %s after %d rounds with champion %q, want finished with Ann", view.State, len(view.Rounds), view.Champion)
+	}
+	for _, standing := range view.Standings {
+		want := 1
+		if standing.Player == "Ann" {
+			want = 0
+		}
+		if standing.Losses != want {
+			t.Errorf("%s has %d losses, want %d", standing.Player, standing.Losses, want)
+		}
+	}
+}
+
+func TestMatchSeatsRequireEntrantToken(t *testing.T) {
+	id, tokens := startTournament(t, FormatSingleElimination, "Ann", "Ben")
+	session := matchSession(t, id, "Ann", "Ben")
+	for _, test := range []struct {
+		name, token string
+		ok          bool
+	}{
+		{"Eve", tokens["Ann"], false},
+		{"Ann", "", false},
+		{"Ann", tokens["Ben"], false},
+		{"Ann", tokens["Ann"], true},
+		{"Ann", tokens["Ann"], false}, // already seated
+		{"Ben", tokens["Ben"], true},
+	} {
+		err := session.call(func() error { _, err := session.seat(test.name, test.token); return err })
+		if (err == nil) != test.ok {
+			t.Errorf("seating %s: %v, want ok %v", test.name, err, test.ok)
+		}
+	}
+
+	if view := viewTournament(t, id); view.tokens != nil {
+		t.Error("the tournament view carries the entrants' tokens")
+	}
+}
+
+func TestTournamentAPI(t *testing.T) {
+	handler := useAPI(t)
+	config.AdminToken = testAdminToken
+	removeTournament := func(id string) {
+		t.Cleanup(func() {
+			tournaments.mu.Lock()
+			delete(tournaments.tournaments, id)
+			tournaments.mu.Unlock()
+			for _, session := range manager.list() {
+				if session.tournamentID == id {
+					manager.remove(session)
+				}
+			}
+		})
+	}
+	create := func() TournamentCreation {
+		var created TournamentCreation
+		if status := apiCall(t, handler, "POST", "/tournaments", "", CreateTournamentRequest{Name: "Cup"}, &created); status != http.StatusCreated {
+			t.Fatalf("creating a tournament answered %d", status)
+		}
+		removeTournament(created.ID)
+		if created.Token == "" || created.Format != FormatSingleElimination {
+			t.Fatalf("created %+v, want a single elimination tournament and the creator's token", created)
+		}
+		for _, name := range []string{"Ann", "Ben"} {
+			if status := apiCall(t, handler, "POST", "/tournaments/"+created.ID+"/entrants", "", RegisterEntrantRequest{PlayerName: name}, nil); status != http.StatusOK {
+				t.Fatalf("registering %s answered %d", name, status)
+			}
+		}
+		return created
+	}
+	cup := create()
+	var cat EntrantRegistration
+	apiCall(t, handler, "POST", "/tournaments/"+cup.ID+"/entrants", "", RegisterEntrantRequest{PlayerName: "Cat"}, &cat)
+
+	for _, test := range []struct {
+		name, path, token string
+		body              any
+		status            int
+		code              string
+	}{
+		{"malformed tournament", "/tournaments", "", "not an object", http.StatusBadRequest, CodeBadRequest},
+		{"unknown format", "/tournaments", "", CreateTournamentRequest{Format: "roundRobin"}, http.StatusBadRequest, CodeBadRequest},
+		{"unknown tournament", "/tournaments/NO-SUCH/entrants", "", RegisterEntrantRequest{PlayerName: "Dan"}, http.StatusNotFound, CodeNotFound},
+		{"duplicate entrant", "/tournaments/" + cup.ID + "/entrants", "", RegisterEntrantRequest{PlayerName: "Ann"}, http.StatusForbidden, CodeForbidden},
+		{"start without a token", "/tournaments/" + cup.ID + "/start", "", nil, http.StatusForbidden, CodeForbidden},
+		{"start with an entrant's token", "/tournaments/" + cup.ID + "/start", cat.Token, nil, http.StatusForbidden, CodeForbidden},
+		{"start an unknown tournament", "/tournaments/NO-SUCH/start", cup.Token, nil, http.StatusNotFound, CodeNotFound},
+	} {
+		var errPayload ErrorPayload
+		status := apiCall(t, handler, "POST", test.path, test.token, test.body, &errPayload)
+		if status != test.status || errPayload.Code != test.code {
+			t.Errorf("%s: answered %d %q, want %d %q", test.name, status, errPayload.Code, test.status, test.code)
+		}
+	}
+
+	var view TournamentView
+	if status := apiCall(t, handler, "POST", "/tournaments/"+cup.ID+"/start", cup.Token, nil, &view); status != http.StatusOK || view.State != TournamentInProgress {
+		t.Fatalf("the creator starting the tournament answered %d %s", status, view.State)
+	}
+	var errPayload ErrorPayload
+	if status := apiCall(t, handler, "POST", "/tournaments/"+cup.ID+"/start", cup.Token, nil, &errPayload); status != http.StatusConflict || errPayload.Code != CodeGameAlreadyStarted {
+		t.Errorf("starting it again answered %d %q, want 409", status, errPayload.Code)
+	}
+	if status := apiCall(t, handler, "POST", "/tournaments/"+cup.ID+"/entrants", "", RegisterEntrantRequest{PlayerName: "Dan"}, &errPayload); status != http.StatusConflict {
+		t.Errorf("registering after the start answered %d %q, want 409", status, errPayload.Code)
+	}
+
+	// An administrator can start anyone's tournament
+	other := create()
+	if status := apiCall(t, handler, "POST", "/tournaments/"+other.ID+"/start", testAdminToken, nil, &view); status != http.StatusOK || view.State != TournamentInProgress {
+		t.Errorf("the administrator starting a tournament answered %d %s", status, view.State)
+	}
+}
+
+func TestBracketOrder(t *testing.T) {
+	for size, want := range map[int][]int{
+		1: {1},
+		2: {1, 2},
+		4: {1, 4, 2, 3},
+		8: {1, 8, 4, 5, 2, 7, 3, 6},
+	} {
+		if got := bracketOrder(size); !slices.Equal(got, want) {
+			t.Errorf("bracketOrder(%d) = %v, want %v", size, got, want)
+		}
+	}
+}
+
+func TestEliminationGivesTopSeedsTheByes(t *testing.T) {
+	tournament := &Tournament{Format: FormatSingleElimination, Entrants: []string{"s1", "s2", "s3", "s4", "s5"}}
+	want := [][2]string{{"s1", ""}, {"s4", "s5"}, {"s2", ""}, {"s3", ""}}
+	if got := tournament.eliminationPairings(); !slices.Equal(got, want) {
+		t.Errorf("first round %v, want %v", got, want)
+	}
+
+	// The winners of adjacent matches meet, and a double loss leaves its
+	// neighbour a bye
+	tournament.Rounds = []Round{{Number: 1, Matches: []*Match{
+		{PlayerA: "s1", Winner: "s1"},
+		{PlayerA: "s4", PlayerB: "s5", Winner: "s5"},
+		{PlayerA: "s2", Winner: "s2"},
+		{PlayerA: "s3", DoubleLoss: true},
+	}}}
+	want = [][2]string{{"s1", "s5"}, {"s2", ""}}
+	if got := tournament.eliminationPairings(); !slices.Equal(got, want) {
+		t.Errorf("second round %v, want %v", got, want)
+	}
+}
+
+// swissTournament returns a Swiss tournament between A, B, C and D after
+// two rounds: A beat B and C beat D, then A beat C and D beat B.
+func swissTournament() *Tournament {
+	return &Tournament{
+		Format:    FormatSwiss,
+		MaxRounds: 3,
+		Entrants:  []string{"A", "B", "C", "D"},
+		Rounds: []Round{
+			{Number: 1, Matches: []*Match{
+				{PlayerA: "A", PlayerB: "B", Winner: "A"},
+				{PlayerA: "C", PlayerB: "D", Winner: "C"},
+			}},
+			{Number: 2, Matches: []*Match{
+				{PlayerA: "A", PlayerB: "C", Winner: "A"},
+				{PlayerA: "B", PlayerB: "D", Winner: "D"},
+			}},
+		},
+	}
+}
+
+func TestStandingsBreakTiesByBuchholz(t *testing.T) {
+	// C and D both have a point, but C lost to A, who has two, while D
+	// lost to C
+	want := []Standing{
+		{Player: "A", Wins: 2, Points: 2, Buchholz: 1},
+		{Player: "C", Wins: 1, Losses: 1, Points: 1, Buchholz: 3},
+		{Player: "D", Wins: 1, Losses: 1, Points: 1, Buchholz: 1},
+		{Player: "B", Losses: 2, Points: 0, Buchholz: 3},
+	}
+	if got := swissTournament().standings(); !slices.Equal(got, want) {
+		t.Errorf("standings\n%+v, want\n%+v", got, want)
+	}
+}
+
+func TestSwissPairingsAvoidRematches(t *testing.T) {
+	tournament := &Tournament{Format: FormatSwiss, Entrants: []string{"A", "B", "C", "D"}}
+	want := [][2]string{{"A", "B"}, {"C", "D"}}
+	if got := tournament.swissPairings(); !slices.Equal(got, want) {
+		t.Errorf("first round %v, want %v", got, want)
+	}
+
+	// A already played C, the next in the standings, so meets D instead
+	want = [][2]string{{"A", "D"}, {"C", "B"}}
+	if got := swissTournament().swissPairings(); !slices.Equal(got, want) {
+		t.Errorf("third round %v, want %v", got, want)
+	}
+}
+
+func TestSwissByeGoesToLowestRankedWithoutOne(t *testing.T) {
+	tournament := &Tournament{Format: FormatSwiss, Entrants: []string{"A", "B", "C"}}
+	want := [][2]string{{"A", "B"}, {"C", ""}}
+	if got := tournament.swissPairings(); !slices.Equal(got, want) {
+		t.Errorf("first round %v, want %v", got, want)
+	}
+
+	// C is now last among the leaders but has had a bye, so B sits out
+	tournament.Rounds = []Round{{Number: 1, Matches: []*Match{
+		{PlayerA: "A", PlayerB: "B", Winner: "A"},
+		{PlayerA: "C", Winner: "C"},
+	}}}
+	want = [][2]string{{"A", "C"}, {"B", ""}}
+	if got := tournament.swissPairings(); !slices.Equal(got, want) {
+		t.Errorf("second round %v, want %v", got, want)
+	}
+	if standing := tournament.standings()[1]; standing.Player != "C" || standing.Byes != 1 || standing.Points != 1 {
+		t.Errorf("C's standing is %+v, want one bye worth a point", standing)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Webhooks tell players about turns and games without an open connection.
+// The server posts every event to the webhookUrls in its config, and a
+// session to the webhook given in its createGame message. Each delivery is
+// signed with the target's secret and retried with exponential backoff
+// until it is accepted or the retries run out. Deliveries run concurrently,
+// so receivers order events by their time rather than by arrival.
+
+// Webhook event types
+const (
+	WebhookGameStarted = "gameStarted"
+	WebhookYourTurn    = "yourTurn"
+	WebhookGameOver    = "gameOver"
+)
+
+// webhookTimeout bounds a single delivery attempt
+const webhookTimeout = 10 * time.Second
+
+// WebhookTarget is a URL events are posted to and the secret their
+// signatures are made with.
+type WebhookTarget struct {
+	URL    string `json:"url"`
+	Secret string `json:"secret"`
+}
+
+// WebhookEvent is the body of a webhook delivery. PlayerID is the player on
+// turn for yourTurn and the winner for gameOver. Deadline is set for
+// yourTurn in correspondence games.
+type WebhookEvent struct {
+	ID         string     `json:"id"`
+	Type       string     `json:"type"`
+	SessionID  string     `json:"sessionId"`
+	RoomCode   string     `json:"roomCode"`
+	PlayerID   string     `json:"playerId,omitempty"`
+	PlayerName string     `json:"playerName,omitempty"`
+	Deadline   *time.Time `json:"deadline,omitempty"`
+	Time       time.Time  `json:"time"`
+}
+
+// webhookDispatcher delivers events in the background so a slow receiver
+// never holds up a session. Once it is closed it drops new events.
+type webhookDispatcher struct {
+	client *http.Client
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu     sync.Mutex
+	closed bool
+}
+
+var webhooks = newWebhookDispatcher()
+
+func newWebhookDispatcher() *webhookDispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &webhookDispatcher{
+		// Redirects are not followed: a receiver on an allowed host could
+		// otherwise send the server on to any address
+		client: &http.Client{
+			Timeout: webhookTimeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// send delivers event to target in the background
+func (d *webhookDispatcher) send(target WebhookTarget, event WebhookEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("Encoding webhook event failed", "error", err)
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		slog.Warn("Webhook event dropped at shutdown", "event", event.Type, "session", event.SessionID)
+		return
+	}
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		d.deliver(target, event, body)
+	}()
+}
+
+// deliver posts body to target, retrying failed attempts after a backoff
+// that doubles every time.
+func (d *webhookDispatcher) deliver(target WebhookTarget, event WebhookEvent, body []byte) {
+	log := slog.With("webhook", redactURL(target.URL), "event", event.Type, "delivery", event.ID, "session", event.SessionID)
+	backoff := time.Duration(config.WebhookBackoff)
+	for attempt := 0; ; attempt++ {
+		retry, err := d.post(target, event, body)
+		if err == nil {
+			webhookDeliveries.inc("delivered")
+			log.Debug("Webhook delivered", "attempts", attempt+1)
+			return
+		}
+		if !retry || attempt >= config.WebhookRetries {
+			webhookDeliveries.inc("failed")
+			log.Warn("Webhook delivery failed", "attempts", attempt+1, "error", err)
+			return
+		}
+		webhookDeliveries.inc("retried")
+		log.Debug("Webhook delivery failed, retrying", "attempt", attempt+1, "backoff", backoff.String(), "error", err)
+		select {
+		case <-time.After(backoff):
+		case <-d.ctx.Done():
+			webhookDeliveries.inc("failed")
+			log.Warn("Webhook delivery abandoned at shutdown", "attempts", attempt+1)
+			return
+		}
+		backoff *= 2
+	}
+}
+
+// post makes one delivery attempt. It reports whether a failed attempt is
+// worth retrying: network errors, 429 and 5xx are, other refusals and
+// redirects are not.
+func (d *webhookDispatcher) post(target WebhookTarget, event WebhookEvent, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, target.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "salvo-webhooks")
+	req.Header.Set("X-Salvo-Event", event.Type)
+	req.Header.Set("X-Salvo-Delivery", event.ID)
+	req.Header.Set("X-Salvo-Signature", signWebhook(target.Secret, body))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("receiver answered %s", resp.Status)
+}
+
+// close stops accepting events, waits up to timeout for the deliveries
+// under way, then abandons the rest.
+func (d *webhookDispatcher) close(timeout time.Duration) {
+	d.mu.Lock()
+	d.closed = true
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		slog.Warn("Webhook deliveries still pending at shutdown")
+	}
+	d.cancel()
+}
+
+// signWebhook returns the X-Salvo-Signature of body: its HMAC-SHA256 with
+// secret, hex encoded.
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// notifyWebhooks sends an event about playerID, which may be empty, to the
+// server's webhooks and the session's. It runs on the session goroutine.
+func (session *GameSession) notifyWebhooks(eventType, playerID string) {
+	targets := make([]WebhookTarget, 0, len(config.WebhookURLs)+1)
+	for _, u := range config.WebhookURLs {
+		targets = append(targets, WebhookTarget{URL: u, Secret: config.WebhookSecret})
+	}
+	if session.webhook != nil {
+		targets = append(targets, *session.webhook)
+	}
+	if len(targets) == 0 {
+		return
+	}
+
+	event := WebhookEvent{
+		ID:        newToken(),
+		Type:      eventType,
+		SessionID: session.ID,
+		RoomCode:  session.RoomCode,
+		PlayerID:  playerID,
+		Time:      time.Now(),
+	}
+	if player := session.GameState.Player(playerID); player != nil {
+		event.PlayerName = player.Name
+	}
+	if eventType == WebhookYourTurn {
+		event.Deadline = session.currentTurnDeadline()
+	}
+	for _, target := range targets {
+		webhooks.send(target, event)
+	}
+}
+
+// validateSessionWebhook checks the webhook a createGame message asks for.
+// Sessions may only send to the hosts the server allows, so clients cannot
+// make the server post to arbitrary addresses.
+func validateSessionWebhook(target WebhookTarget) error {
+	u, err := url.Parse(target.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return newProtocolError(CodeBadRequest, "webhook url must be an http or https URL")
+	}
+	if !slices.Contains(config.WebhookAllowedHosts, "*") && !slices.Contains(config.WebhookAllowedHosts, u.Hostname()) {
+		return newProtocolError(CodeForbidden, "webhooks to %s are not allowed on this server", u.Hostname())
+	}
+	if len(target.Secret) < 16 {
+		return newProtocolError(CodeBadRequest, "webhook secret must be at least 16 characters")
+	}
+	return nil
+}
+
+// redactURL returns u without its query and user info, which may carry
+// credentials, for logging.
+func redactURL(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return "[invalid url]"
+	}
+	parsed.User, parsed.RawQuery = nil, ""
+	return parsed.String()
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testWebhookSecret = "0123456789abcdef"
+
+// delivery is a webhook request seen by a test receiver
+type delivery struct {
+	header http.Header
+	event  WebhookEvent
+}
+
+// useWebhooks gives the test its own config and dispatcher, with retries
+// that do not wait, and a receiver answering with the statuses in order
+// and 200 after them. It returns the receiver's URL and what it received.
+func useWebhooks(t *testing.T, statuses ...int) (string, <-chan delivery) {
+	t.Helper()
+	oldConfig, oldWebhooks := config, webhooks
+	cfg := *config
+	cfg.WebhookBackoff = Duration(time.Millisecond)
+	cfg.WebhookRetries = 3
+	cfg.WebhookAllowedHosts = []string{"127.0.0.1"}
+	config, webhooks = &cfg, newWebhookDispatcher()
+	t.Cleanup(func() {
+		webhooks.close(time.Second)
+		config, webhooks = oldConfig, oldWebhooks
+	})
+
+	received := make(chan delivery, 16)
+	var requests atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if got := r.Header.Get("X-Salvo-Signature"); got != signWebhook(testWebhookSecret, body) {
+			t.Errorf("signature %q does not match the body", got)
+		}
+		var event WebhookEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Errorf("invalid webhook body %s", body)
+		}
+		received <- delivery{header: r.Header, event: event}
+		if n := int(requests.Add(1)); n <= len(statuses) {
+			w.WriteHeader(statuses[n-1])
+		}
+	}))
+	t.Cleanup(receiver.Close)
+	return receiver.URL, received
+}
+
+func nextDelivery(t *testing.T, received <-chan delivery) delivery {
+	t.Helper()
+	select {
+	case d := <-received:
+		return d
+	case <-time.After(2 * time.Second):
+		t.Fatal("no webhook delivery")
+		return delivery{}
+	}
+}
+
+func noDelivery(t *testing.T, received <-chan delivery) {
+	t.Helper()
+	select {
+	case d := <-received:
+		t.Errorf("unexpected delivery of %s", d.event.Type)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestWebhookIsRetriedWithBackoff(t *testing.T) {
+	url, received := useWebhooks(t, http.StatusInternalServerError, http.StatusTooManyRequests)
+	event := WebhookEvent{ID: "d1", Type: WebhookGameOver, SessionID: "s1", Time: time.Now()}
+	webhooks.send(WebhookTarget{URL: url, Secret: testWebhookSecret}, event)
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		d := nextDelivery(t, received)
+		if d.event.ID != "d1" || d.header.Get("X-Salvo-Delivery") != "d1" || d.header.Get("X-Salvo-Event") != WebhookGameOver {
+			t.Errorf("attempt %d delivered %+v with headers %v", attempt, d.event, d.header)
+		}
+	}
+	noDelivery(t, received)
+}
+
+func TestWebhookRefusalIsNotRetried(t *testing.T) {
+	url, received := useWebhooks(t, http.StatusBadRequest)
+	webhooks.send(WebhookTarget{URL: url, Secret: testWebhookSecret}, WebhookEvent{ID: "d1", Type: WebhookYourTurn})
+	nextDelivery(t, received)
+	noDelivery(t, received)
+}
+
+func TestWebhookRedirectIsNotFollowed(t *testing.T) {
+	var followed atomic.Bool
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		followed.Store(true)
+	}))
+	defer internal.Close()
+	_, received := useWebhooks(t)
+	redirector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, internal.URL, http.StatusTemporaryRedirect)
+	}))
+	defer redirector.Close()
+
+	webhooks.send(WebhookTarget{URL: redirector.URL, Secret: testWebhookSecret}, WebhookEvent{ID: "d1", Type: WebhookYourTurn})
+	webhooks.close(time.Second)
+	if followed.Load() {
+		t.Error("the dispatcher followed the redirect")
+	}
+	noDelivery(t, received)
+}
+
+func TestWebhookEventsAfterCloseAreDropped(t *testing.T) {
+	url, received := useWebhooks(t)
+	webhooks.close(time.Second)
+	webhooks.send(WebhookTarget{URL: url, Secret: testWebhookSecret}, WebhookEvent{ID: "d1", Type: WebhookGameOver})
+	noDelivery(t, received)
+}
+
+func TestGameEventsAreSentToWebhooks(t *testing.T) {
+	url, received := useWebhooks(t)
+	msg := clientMessage(t, "createGame", CreateGamePayload{NumberOfPlayers: 2, PlayerName: "Alice",
+		Webhook: &WebhookTarget{URL: url, Secret: testWebhookSecret}})
+	session, err := findOrCreateSession(msg)
+	if err != nil {
+		t.Fatalf("createGame: %v", err)
+	}
+	defer manager.remove(session)
+
+	var first string
+	err = session.call(func() error {
+		for _, name := range []string{"Alice", "Bob"} {
+			if _, err := session.seat(name, ""); err != nil {
+				return err
+			}
+		}
+		if err := handleMessage(session, "", clientMessage(t, "startGame", nil)); err != nil {
+			return err
+		}
+		first = session.GameState.CurrentPlayerId
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := map[string]WebhookEvent{}
+	for range 2 {
+		d := nextDelivery(t, received)
+		events[d.event.Type] = d.event
+	}
+	if started := events[WebhookGameStarted]; started.SessionID != session.ID || started.RoomCode != session.RoomCode {
+		t.Errorf("gameStarted %+v, want the session's ID and room code", started)
+	}
+	if turn := events[WebhookYourTurn]; turn.PlayerID != first || turn.PlayerName == "" || turn.Deadline != nil {
+		t.Errorf("yourTurn %+v, want player %s without a deadline", turn, first)
+	}
+
+	// Leave the next player one ship the current player can sink
+	err = session.call(func() error {
+		state := session.GameState
+		for i := range state.Players {
+			player := &state.Players[i]
+			player.PlayedShips = []ShipCard{{GunSize: 1, HitPoints: 1}}
+			if player.ID == first {
+				player.Hand = []SalvoCard{{GunSize: 1, Damage: 1}}
+			}
+		}
+		return handleMessage(session, first, clientMessage(t, "fireSalvo", FireSalvoPayload{
+			Salvo:  SalvoCard{GunSize: 1, Damage: 1},
+			Target: ShipCard{GunSize: 1, HitPoints: 1},
+		}))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if over := nextDelivery(t, received).event; over.Type != WebhookGameOver || over.PlayerID != first {
+		t.Errorf("sent %+v, want gameOver won by %s", over, first)
+	}
+}
+
+func TestSessionWebhookMustBeAllowed(t *testing.T) {
+	useWebhooks(t)
+	for _, test := range []struct {
+		target WebhookTarget
+		code   string
+	}{
+		{WebhookTarget{URL: "http://127.0.0.1:9000/hook", Secret: testWebhookSecret}, ""},
+		{WebhookTarget{URL: "http://169.254.169.254/latest", Secret: testWebhookSecret}, CodeForbidden},
+		{WebhookTarget{URL: "file:///etc/passwd", Secret: testWebhookSecret}, CodeBadRequest},
+		{WebhookTarget{URL: "http://127.0.0.1/hook", Secret: "short"}, CodeBadRequest},
+	} {
+		code := ""
+		if err := validateSessionWebhook(test.target); err != nil {
+			code = asProtocolError(err).Code
+		}
+		if code != test.code {
+			t.Errorf("%+v: error code %q, want %q", test.target, code, test.code)
+		}
+	}
+}
@@ -7,12 +7,12 @@ const CreateGame = (): React.ReactNode => {
   const [numPlayers, setNumPlayers] = useState(2)
   const [playerName, setPlayerName] = useState('')
   const [error, setError] = useState<string>()
+  const [roomCode, setRoomCode] = useState<string>()
 
   const createNewGame = () => {
     const createGame: CreateGameMessage = {
-      action: 'createGame',
-      numPlayers: 4,
-      playerName: 'bob',
+      type: 'createGame',
+      payload: { numberOfPlayers: numPlayers, playerName: playerName.trim() },
     }
 
     wsService.sendMessage(createGame)
@@ -22,15 +22,13 @@ const CreateGame = (): React.ReactNode => {
     const handleMessage = (message: ServerMessage) => {
       console.log('handleMessage message:', message)
 
-      if (message.messageType === 'error') {
-        setError(message.error)
-        return
+      if (message.type === 'error') {
+        setError(message.payload.message)
       }
-
-      // If we don't have a session ID yet, store it
-      if (!wsService.getSessionId() && message.gameState.players.length > 0) {
-        wsService.setSessionId(message.sessionId)
-        wsService.setPlayerId(message.gameState.players[0].id)
+      // Other players join with the room code or the link to it
+      if (message.type === 'joined') {
+        setRoomCode(message.payload.roomCode)
+        setError(undefined)
       }
     }
 
@@ -45,29 +43,41 @@ const CreateGame = (): React.ReactNode => {
     <div>
       <ThemeLinkButton to="/">Home</ThemeLinkButton>
       {error && <div style={{ color: 'red', marginBottom: '10px' }}>{error}</div>}
-      <div style={{ display: 'flex', flexDirection: 'column', gap: '10px' }}>
-          <>
-            <input
-              type="text"
-              placeholder="Enter your name"
-              value={playerName}
-              onChange={e => setPlayerName(e.target.value)}
-              style={{ padding: '8px', borderRadius: '4px', border: '1px solid #ccc' }}
-            />
-            <select
-              value={numPlayers}
-              onChange={e => setNumPlayers(Number(e.target.value))}
-              style={{ padding: '8px', borderRadius: '4px', border: '1px solid #ccc' }}
-            >
-              {[2, 3, 4].map(num => (
-                <option key={num} value={num}>
-                  {num} Players
-                </option>
-              ))}
-            </select>
-          </>
-        <ThemeButton onClick={createNewGame}>Create Game</ThemeButton>
-      </div>
+      {roomCode ? (
+        <div style={{ display: 'flex', flexDirection: 'column', gap: '10px' }}>
+          <div>
+            Room code: <strong>{roomCode}</strong>
+          </div>
+          <div>
+            Invite players with{' '}
+            <a href={`/joingame/${roomCode}`}>{`${window.location.origin}/joingame/${roomCode}`}</a>
+          </div>
+        </div>
+      ) : (
+        <div style={{ display: 'flex', flexDirection: 'column', gap: '10px' }}>
+            <>
+              <input
+                type="text"
+                placeholder="Enter your name"
+                value={playerName}
+                onChange={e => setPlayerName(e.target.value)}
+                style={{ padding: '8px', borderRadius: '4px', border: '1px solid #ccc' }}
+              />
+              <select
+                value={numPlayers}
+                onChange={e => setNumPlayers(Number(e.target.value))}
+                style={{ padding: '8px', borderRadius: '4px', border: '1px solid #ccc' }}
+              >
+                {[2, 3, 4].map(num => (
+                  <option key={num} value={num}>
+                    {num} Players
+                  </option>
+                ))}
+              </select>
+            </>
+          <ThemeButton onClick={createNewGame}>Create Game</ThemeButton>
+        </div>
+      )}
     </div>
   )
 }
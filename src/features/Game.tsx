@@ -68,7 +68,6 @@ const EmptyCard = styled.div<{ themeColors: ThemeColors }>`
 const Game: React.FC = () => {
   const { themeColors, toggleTheme, theme } = useTheme()
   const [gameState, setGameState] = useState<GameState>()
-  const [sessionId, setSessionId] = useState<string>()
   const [selectedSalvo, setSelectedSalvo] = useState<{ card: SalvoCard; index: number } | null>(null)
   const [hasDrawnCard, setHasDrawnCard] = useState(false)
   const [deckCounts, setDeckCounts] = useState({
@@ -83,23 +82,18 @@ const Game: React.FC = () => {
     const handleMessage = (message: ServerMessage) => {
       console.log('handleMessage message:', message)
 
-      if (message.messageType === 'error') {
-        setError(message.error)
-        return
-      }
-
-      setGameState(message.gameState)
-      setSessionId(message.sessionId)
-      setDeckCounts({
-        shipDeck: message.shipDeckCount,
-        playDeck: message.playDeckCount,
-        discardPile: message.discardCount,
-      })
-
-      // If we don't have a session ID yet, store it
-      if (!wsService.getSessionId() && message.gameState.players.length > 0) {
-        wsService.setSessionId(message.sessionId)
-        wsService.setPlayerId(message.gameState.players[0].id)
+      switch (message.type) {
+        case 'error':
+          setError(message.payload.message)
+          break
+        case 'state':
+          setGameState(message.payload.gameState)
+          setDeckCounts({
+            shipDeck: message.payload.shipDeckCount,
+            playDeck: message.payload.playDeckCount,
+            discardPile: message.payload.discardCount,
+          })
+          break
       }
     }
 
@@ -111,7 +105,7 @@ const Game: React.FC = () => {
   }, [])
 
   const startGame = () => {
-    wsService.sendMessage({ action: 'startGame', numPlayers: 4 })
+    wsService.sendMessage({ type: 'startGame' })
   }
 
   const drawSalvo = () => {
@@ -119,7 +113,7 @@ const Game: React.FC = () => {
       alert('No game state found')
       return
     }
-    wsService.sendMessage({ action: 'drawSalvo', sessionId: sessionId })
+    wsService.sendMessage({ type: 'drawSalvo' })
     setHasDrawnCard(true)
   }
 
@@ -128,7 +122,7 @@ const Game: React.FC = () => {
       alert('No game state found')
       return
     }
-    wsService.sendMessage({ action: 'drawShip', sessionId: sessionId })
+    wsService.sendMessage({ type: 'drawShip' })
   }
 
   const selectSalvo = (salvo: SalvoCard, index: number) => {
@@ -152,9 +146,8 @@ const Game: React.FC = () => {
     }
 
     wsService.sendMessage({
-      action: 'discardSalvo',
-      sessionId: sessionId,
-      salvo: selectedSalvo.card,
+      type: 'discardSalvo',
+      payload: { salvo: selectedSalvo.card },
     })
 
     setSelectedSalvo(null)
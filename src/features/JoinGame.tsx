@@ -15,11 +15,11 @@ const JoinGame: React.FC<Props> = ({sessionId}) => {
       setError('Please enter your name')
       return
     }
-    wsService.sendMessage({
-      action: 'joinGame',
-      sessionId: sessionId,
-      playerName: playerName.trim(),
-    } as JoinGameMessage)
+    const join: JoinGameMessage = {
+      type: 'joinGame',
+      payload: { sessionId: sessionId, playerName: playerName.trim() },
+    }
+    wsService.sendMessage(join)
   }
   return (
       <>
@@ -1,6 +1,6 @@
 import React, { useEffect, useState } from 'react'
 import styled from '@emotion/styled'
-import { wsService } from '../services/websocket.ts'
+import { useNavigate } from '@tanstack/react-router'
 import { ThemeColors } from '../types/theme.ts'
 import ThemeLinkButton from '../components/ThemeLinkButton.tsx'
 import { useTheme } from '../context/useTheme.tsx'
@@ -47,6 +47,7 @@ const GameInfo = styled.div`
 
 interface Game {
   id: string
+  roomCode: string
   name: string
   playerCount: number
   currentPlayers: number
@@ -59,6 +60,7 @@ interface WelcomeProps {
 
 const Welcome: React.FC<WelcomeProps> = () => {
   const { themeColors } = useTheme()
+  const navigate = useNavigate()
   const [games, setGames] = useState<Game[]>([])
   const [loading, setLoading] = useState(true)
 
@@ -81,12 +83,9 @@ const Welcome: React.FC<WelcomeProps> = () => {
     return () => clearInterval(interval)
   }, [])
 
-  const joinGame = async (gameId: string) => {
-    try {
-      await wsService.sendMessage({ action: 'joinGame', sessionId: gameId, playerName: 'test' })
-    } catch (error) {
-      console.error('Error joining game:', error)
-    }
+  // Games are shared and joined by their room code
+  const joinGame = (roomCode: string) => {
+    navigate({ to: '/joingame/$sessionId', params: { sessionId: roomCode } }).catch(console.error)
   }
 
   return (
@@ -99,9 +98,9 @@ const Welcome: React.FC<WelcomeProps> = () => {
           <div style={{ color: themeColors.text }}>No games available</div>
         ) : (
           games.map(game => (
-            <GameItem key={game.id} themeColors={themeColors} onClick={() => joinGame(game.id)}>
+            <GameItem key={game.id} themeColors={themeColors} onClick={() => joinGame(game.roomCode)}>
               <GameInfo>
-                <span>Game {game.id}</span>
+                <span>{game.roomCode}</span>
                 <span>
                   {game.currentPlayers}/{game.playerCount} players
                 </span>
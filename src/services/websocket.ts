@@ -1,79 +1,132 @@
 import { GameState, ShipCard, SalvoCard } from '../types/game'
 
-export type ClientMessage = {
-  action: 'startGame' | 'drawSalvo' | 'drawShip' | 'fireSalvo' | 'discardSalvo' | 'createGame' | 'joinGame'
-  sessionId?: string
-  playerId?: string
-  playerName?: string
+// Must match ProtocolVersion in server/protocol.go
+export const PROTOCOL_VERSION = 1
+
+// The server is on the page's origin, directly when it serves the frontend
+// and through the Vite proxy in development. Set VITE_WS_URL, e.g.
+// wss://salvo.example.com/ws, when it is elsewhere.
+const WS_URL =
+  import.meta.env.VITE_WS_URL ?? `${window.location.protocol === 'https:' ? 'wss:' : 'ws:'}//${window.location.host}/ws`
+
+export type ChatSignal = 'gg' | 'goodLuck' | 'niceShot' | 'wellPlayed' | 'oops' | 'thinking' | 'hurryUp'
+export type ChatChannel = 'players' | 'spectators'
+
+export type ChatMessage = {
+  id: number
+  from: string
+  name: string
+  channel: ChatChannel
+  text?: string
+  signal?: ChatSignal
+  sentAt: string
 }
 
-export type StartGameMessage = ClientMessage & {
-  action: 'startGame'
-  numPlayers: number
+export type CreateGameMessage = {
+  type: 'createGame'
+  payload: {
+    numberOfPlayers: number
+    playerName: string
+    correspondence?: boolean
+    turnHours?: number
+    webhook?: { url: string; secret: string }
+    playerKey?: string
+  }
 }
 
-export type DrawSalvoMessage = ClientMessage & {
-  action: 'drawSalvo'
+export type JoinGameMessage = {
+  type: 'joinGame' | 'spectateGame'
+  payload: { sessionId: string; playerName: string; entrantToken?: string; playerKey?: string }
 }
 
-export type DrawShipMessage = ClientMessage & {
-  action: 'drawShip'
-}
+export type StartGameMessage = { type: 'startGame' }
+export type DrawSalvoMessage = { type: 'drawSalvo' }
+export type DrawShipMessage = { type: 'drawShip' }
 
-export type FireSalvoMessage = ClientMessage & {
-  action: 'fireSalvo'
-  salvo: SalvoCard
-  target: ShipCard
+export type FireSalvoMessage = {
+  type: 'fireSalvo'
+  payload: { salvo: SalvoCard; target: ShipCard }
 }
 
-export type DiscardSalvoMessage = ClientMessage & {
-  action: 'discardSalvo'
-  salvo: SalvoCard
+export type DiscardSalvoMessage = {
+  type: 'discardSalvo'
+  payload: { salvo: SalvoCard }
 }
 
-export type CreateGameMessage = ClientMessage & {
-  action: 'createGame'
-  numPlayers: number
-  playerName: string
-}
-
-export type JoinGameMessage = ClientMessage & {
-  action: 'joinGame'
-  sessionId: string
-  playerName: string
+export type SendChatMessage = {
+  type: 'chat'
+  payload: { channel?: ChatChannel; text?: string; signal?: ChatSignal }
 }
 
-export type ClientMessageType = 
-  | StartGameMessage 
-  | DrawSalvoMessage 
-  | DrawShipMessage 
-  | FireSalvoMessage 
-  | DiscardSalvoMessage
+export type ClientMessageType =
   | CreateGameMessage
   | JoinGameMessage
+  | StartGameMessage
+  | DrawSalvoMessage
+  | DrawShipMessage
+  | FireSalvoMessage
+  | DiscardSalvoMessage
+  | SendChatMessage
 
-export type ServerMessage = {
+export type StatePayload = {
+  sessionId: string
   gameState: GameState
   shipDeckCount: number
   playDeckCount: number
   discardCount: number
-  sessionId: string
-  messageType: 'gameState' | 'playerHand' | 'gameStarted' | 'error'
-  error?: string
+  turnDeadline?: string
 }
 
+// replyTo carries the id of the client message an ack or error answers
+type Envelope<T extends string, P> = { v: number; id: string; replyTo?: string; type: T; payload: P }
+
+export type ServerMessage =
+  | Envelope<'welcome', { protocolVersion: number; server: string }>
+  | Envelope<'ack', { type: ClientMessageType['type'] }>
+  | Envelope<
+      'joined',
+      { sessionId: string; roomCode: string; playerId: string; spectator: boolean; token?: string; playerKey?: string }
+    >
+  | Envelope<'playerDisconnected' | 'playerReconnected', { playerId: string; name: string }>
+  | Envelope<'gameStarted', { sessionId: string }>
+  | Envelope<'state', StatePayload>
+  | Envelope<'snapshot', { seq: number; state: StatePayload }>
+  | Envelope<'patch', { seq: number; ops: { op: 'add' | 'remove' | 'replace'; path: string; value?: unknown }[] }>
+  | Envelope<'chat', { messages: ChatMessage[] }>
+  | Envelope<'sessionEnded', { sessionId: string; reason: string }>
+  | Envelope<'sessionExpiring', { sessionId: string; state: string; expiresAt: string }>
+  | Envelope<'turnMissed', { sessionId: string; playerId: string; deadline: string }>
+  | Envelope<'serverNotice', { message: string }>
+  | Envelope<'serverShutdown', { deadline: string }>
+  | Envelope<'error', { code: string; message: string }>
+
 class WebSocketService {
   private ws: WebSocket | null = null
   private messageHandlers: ((message: ServerMessage) => void)[] = []
   private sessionId: string | null = null
   private playerId: string | null = null
+  private token: string | null = null
+  private lastMessageId = 0
 
   connect() {
-    this.ws = new WebSocket('ws://localhost:8080/ws')
+    this.ws = new WebSocket(WS_URL)
+
+    this.ws.onopen = () => {
+      this.send('hello', { protocolVersion: PROTOCOL_VERSION, client: 'web' })
+      // Take our seat back after a dropped connection
+      if (this.sessionId && this.playerId && this.token) {
+        this.send('rejoinGame', { sessionId: this.sessionId, playerId: this.playerId, token: this.token })
+      }
+    }
 
     this.ws.onmessage = event => {
       const message: ServerMessage = JSON.parse(event.data)
       console.log('Received message:', message)
+      if (message.type === 'joined') {
+        this.setSessionId(message.payload.sessionId)
+        this.setPlayerId(message.payload.playerId)
+        this.token = message.payload.token ?? null
+      }
       this.messageHandlers.forEach(handler => handler(message))
     }
 
@@ -88,24 +141,24 @@ class WebSocketService {
     }
   }
 
-  sendMessage(message: ClientMessageType): void {
+  // Returns the message id echoed in the server's ack or error
+  sendMessage(message: ClientMessageType): string | undefined {
     console.log('Sending message:', message)
     if (this.ws?.readyState === WebSocket.OPEN) {
-      console.error('WebSocket is connected')
-      // Add session and player information to all messages
-      const messageWithSession = {
-        ...message,
-        sessionId: this.sessionId,
-        playerId: this.playerId,
-      }
-      this.ws.send(JSON.stringify(messageWithSession))
+      return this.send(message.type, 'payload' in message ? message.payload : undefined)
     } else {
       console.error('WebSocket is not connected')
-      alert("Session timed out")
-      
+      alert('Session timed out')
     }
   }
 
+  private send(type: string, payload: unknown): string {
+    this.lastMessageId++
+    const id = String(this.lastMessageId)
+    this.ws?.send(JSON.stringify({ v: PROTOCOL_VERSION, id, type, payload }))
+    return id
+  }
+
   addMessageHandler(handler: (message: ServerMessage) => void) {
     this.messageHandlers.push(handler)
   }
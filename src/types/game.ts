@@ -20,6 +20,7 @@ export type Player = {
   hand: SalvoCard[]
   playedShips: ShipCard[]
   deepSixPile: ShipCard[]
+  eliminated?: boolean
 }
 
 export type GameState = {
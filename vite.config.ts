@@ -17,7 +17,11 @@ export default defineConfig({
         target: 'http://localhost:8080',
         changeOrigin: true,
         rewrite: (path) => path.replace(/^\/api/, ''),
-      }
+      },
+      '/ws': {
+        target: 'ws://localhost:8080',
+        ws: true,
+      },
     }
   }
 })